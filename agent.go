@@ -0,0 +1,36 @@
+package remex
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// forwardAgent makes client serve the agent requests of the sessions that ask for
+// agent forwarding with the agent of config, the returned closer releases the
+// connection to SSH_AUTH_SOCK when that agent is used
+func forwardAgent(client *ssh.Client, config *SSHConfig) (io.Closer, error) {
+	if config.Agent != nil {
+		return nil, agent.ForwardToAgent(client, config.Agent)
+	}
+
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, errors.New("agent forwarding requested but SSH_AUTH_SOCK is not set")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SSH agent: %w", err)
+	}
+
+	if err := agent.ForwardToAgent(client, agent.NewClient(conn)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,63 @@
+package remex
+
+import (
+	"fmt"
+	"slices"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Algorithms restricts the algorithms negotiated with the server, an empty list
+// keeps the defaults of golang.org/x/crypto/ssh. Names are the ones used by
+// OpenSSH, e.g. "aes256-gcm@openssh.com" or "curve25519-sha256", see
+// ssh.SupportedAlgorithms. Algorithms listed by ssh.InsecureAlgorithms are
+// accepted too, but only when named explicitly.
+type Algorithms struct {
+	Ciphers      []string
+	KeyExchanges []string
+	MACs         []string
+	// HostKeyAlgorithms lists the accepted host key signature algorithms, e.g.
+	// "ssh-ed25519" or "rsa-sha2-512"
+	HostKeyAlgorithms []string
+}
+
+// clone returns a deep copy of the algorithms
+func (a Algorithms) clone() Algorithms {
+	return Algorithms{
+		Ciphers:           slices.Clone(a.Ciphers),
+		KeyExchanges:      slices.Clone(a.KeyExchanges),
+		MACs:              slices.Clone(a.MACs),
+		HostKeyAlgorithms: slices.Clone(a.HostKeyAlgorithms),
+	}
+}
+
+// validate returns an error naming the first algorithm not implemented by golang.org/x/crypto/ssh
+func (a Algorithms) validate() error {
+	supported, insecure := ssh.SupportedAlgorithms(), ssh.InsecureAlgorithms()
+
+	for _, list := range []struct {
+		kind            string
+		names           []string
+		known, insecure []string
+	}{
+		{"cipher", a.Ciphers, supported.Ciphers, insecure.Ciphers},
+		{"key exchange", a.KeyExchanges, supported.KeyExchanges, insecure.KeyExchanges},
+		{"MAC", a.MACs, supported.MACs, insecure.MACs},
+		{"host key", a.HostKeyAlgorithms, supported.HostKeys, insecure.HostKeys},
+	} {
+		for _, name := range list.names {
+			if !slices.Contains(list.known, name) && !slices.Contains(list.insecure, name) {
+				return fmt.Errorf("unsupported %s algorithm %q, supported: %v", list.kind, name, list.known)
+			}
+		}
+	}
+	return nil
+}
+
+// apply sets the algorithms on the client configuration
+func (a Algorithms) apply(config *ssh.ClientConfig) {
+	config.Ciphers = a.Ciphers
+	config.KeyExchanges = a.KeyExchanges
+	config.MACs = a.MACs
+	config.HostKeyAlgorithms = a.HostKeyAlgorithms
+}
@@ -0,0 +1,56 @@
+package remex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrInvalidArgs is returned by the commands wrapped with ExactArgs, MinArgs or
+// RangeArgs when they are called with the wrong arguments
+var ErrInvalidArgs = errors.New("invalid arguments")
+
+// ExactArgs wraps a remex command so it only runs with exactly n non-empty arguments
+func ExactArgs(n int, command remexCommand) remexCommand {
+	return RangeArgs(n, n, command)
+}
+
+// MinArgs wraps a remex command so it only runs with at least n non-empty arguments
+func MinArgs(n int, command remexCommand) remexCommand {
+	return RangeArgs(n, -1, command)
+}
+
+// RangeArgs wraps a remex command so it only runs with minArgs to maxArgs non-empty
+// arguments, a negative maxArgs means no upper limit. The arguments are counted
+// before any flag parsing of the command, so flags count as arguments too.
+func RangeArgs(minArgs, maxArgs int, command remexCommand) remexCommand {
+	return func(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+		if err := checkArgs(minArgs, maxArgs, args); err != nil {
+			return "", err
+		}
+		return command(ctx, client, args...)
+	}
+}
+
+// checkArgs returns an error wrapping ErrInvalidArgs when the number of args is
+// outside minArgs to maxArgs or one of them is blank
+func checkArgs(minArgs, maxArgs int, args []string) error {
+	switch {
+	case maxArgs >= 0 && minArgs == maxArgs && len(args) != minArgs:
+		return fmt.Errorf("%w: requires exactly %d argument(s), got %d", ErrInvalidArgs, minArgs, len(args))
+	case len(args) < minArgs:
+		return fmt.Errorf("%w: requires at least %d argument(s), got %d", ErrInvalidArgs, minArgs, len(args))
+	case maxArgs >= 0 && len(args) > maxArgs:
+		return fmt.Errorf("%w: accepts at most %d argument(s), got %d", ErrInvalidArgs, maxArgs, len(args))
+	}
+
+	for i, arg := range args {
+		if strings.TrimSpace(arg) == "" {
+			return fmt.Errorf("%w: argument %d cannot be empty", ErrInvalidArgs, i+1)
+		}
+	}
+	return nil
+}
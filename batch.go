@@ -0,0 +1,92 @@
+package remex
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// batchFailedMarker is printed with the index of the failing command by the EXIT
+// trap of a failed batch, on the last line of stdout
+const batchFailedMarker = "__remex_batch_failed__"
+
+// BatchError is returned by SSHClient.ExecuteBatch when a command of the batch fails
+type BatchError struct {
+	// Index is the position of the failing command in the batch
+	Index   int
+	Command string
+	// Err is the exit error of the batch
+	Err error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch command %d %q failed: %v", e.Index, e.Command, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// ExecuteBatch runs the commands one after another in a single session, saving the
+// session setup of every command. The commands share one shell, so a cd or an
+// exported variable affects the following ones. Execution stops at the first
+// failing command, it is reported by a *BatchError. The output of all the commands
+// run is returned combined. The batch runs with bash -c whatever the login shell
+// of the user, so bash has to be installed on the host. Internal remex. commands
+// cannot be batched and sudo password prompts are not answered, run those with
+// ExecuteCommand.
+func (sc *SSHClient) ExecuteBatch(ctx context.Context, commands []string) (string, error) {
+	script, err := batchScript(commands)
+	if err != nil {
+		return "", err
+	}
+
+	output, err := sc.ExecuteCommand(ctx, script)
+	output, failed := cutBatchMarker(output)
+	if err != nil && failed >= 0 && failed < len(commands) {
+		err = &BatchError{Index: failed, Command: commands[failed], Err: err}
+	}
+	return output, err
+}
+
+// batchScript joins the commands into a script stopping at the first failure and
+// wraps it in bash -c, so the EXIT trap does not depend on the login shell, which
+// may be fish, csh or a sh without it
+func batchScript(commands []string) (string, error) {
+	if len(commands) == 0 {
+		return "", ErrEmptyCommand
+	}
+
+	var b strings.Builder
+	// EXIT trap 也能报告以 exit 结束批处理的命令
+	fmt.Fprintf(&b, "trap '__remex_rc=$?; [ $__remex_rc -eq 0 ] || printf \"\\n%s %%d\\n\" \"$__remex_i\"' EXIT\n", batchFailedMarker)
+	for i, command := range commands {
+		if strings.TrimSpace(command) == "" {
+			return "", fmt.Errorf("batch command %d: %w", i, ErrEmptyCommand)
+		}
+		if strings.HasPrefix(command, "remex.") {
+			return "", fmt.Errorf("batch command %d: remex commands cannot be batched: %q", i, command)
+		}
+
+		// 换行结束命令，使以 & 或注释结尾的命令不影响后续语句
+		fmt.Fprintf(&b, "__remex_i=%d\n{ %s\n} || exit\n", i, command)
+	}
+	return QuoteCommand("bash", "-c", b.String())
+}
+
+// cutBatchMarker removes the failure marker from the output, it returns the index
+// of the failing command or -1 when the output carries no marker
+func cutBatchMarker(output string) (string, int) {
+	i := strings.LastIndex(output, "\n"+batchFailedMarker+" ")
+	if i < 0 {
+		return output, -1
+	}
+
+	line, rest, _ := strings.Cut(output[i+len(batchFailedMarker)+2:], "\n")
+	index, err := strconv.Atoi(line)
+	if err != nil {
+		return output, -1
+	}
+	return output[:i] + rest, index
+}
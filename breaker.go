@@ -0,0 +1,168 @@
+package remex
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned for the hosts skipped by the circuit breaker, see WithCircuitBreaker
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState is the state of the circuit breaker of a host, see Remex.HostState
+type CircuitState int
+
+const (
+	// CircuitClosed lets the commands run on the host
+	CircuitClosed CircuitState = iota
+	// CircuitOpen skips the host until the cooldown expires
+	CircuitOpen
+	// CircuitHalfOpen allows a single trial execution on the host, its success
+	// closes the circuit and its failure opens it again
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// circuitBreaker tracks the consecutive failures of every host, the methods
+// are no-ops on a nil breaker
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mutex sync.Mutex
+	hosts map[string]*hostCircuit
+}
+
+type hostCircuit struct {
+	failures int
+	state    CircuitState
+	openedAt time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, hosts: make(map[string]*hostCircuit)}
+}
+
+// allow returns an error wrapping ErrCircuitOpen when the host must be skipped,
+// once the cooldown expired it lets a single trial execution through
+func (b *circuitBreaker) allow(id string) error {
+	if b == nil {
+		return nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	host, ok := b.hosts[id]
+	if !ok {
+		return nil
+	}
+
+	switch host.state {
+	case CircuitOpen:
+		if remaining := b.cooldown - time.Since(host.openedAt); remaining > 0 {
+			return fmt.Errorf("%w: host %s failed %d times in a row, next trial in %v", ErrCircuitOpen, id, host.failures, remaining.Round(time.Millisecond))
+		}
+		host.state = CircuitHalfOpen
+	case CircuitHalfOpen:
+		return fmt.Errorf("%w: trial execution on host %s is running", ErrCircuitOpen, id)
+	}
+	return nil
+}
+
+// record counts the outcome of an execution allowed on the host
+func (b *circuitBreaker) record(id string, err error) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err == nil {
+		delete(b.hosts, id)
+		return
+	}
+
+	host, ok := b.hosts[id]
+	if !ok {
+		host = &hostCircuit{}
+		b.hosts[id] = host
+	}
+	host.failures++
+	if host.state == CircuitHalfOpen || host.failures >= b.threshold {
+		host.state = CircuitOpen
+		host.openedAt = time.Now()
+	}
+}
+
+// abandon forgets an execution that was canceled before it could succeed or
+// fail, a trial execution is allowed again
+func (b *circuitBreaker) abandon(id string) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if host, ok := b.hosts[id]; ok && host.state == CircuitHalfOpen {
+		host.state = CircuitOpen
+	}
+}
+
+func (b *circuitBreaker) state(id string) CircuitState {
+	if b == nil {
+		return CircuitClosed
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	host, ok := b.hosts[id]
+	switch {
+	case !ok:
+		return CircuitClosed
+	case host.state == CircuitOpen && time.Since(host.openedAt) >= b.cooldown:
+		return CircuitHalfOpen
+	default:
+		return host.state
+	}
+}
+
+// HostState returns the circuit breaker state of the host with the given ID, it
+// is always CircuitClosed without WithCircuitBreaker
+func (r *Remex) HostState(id string) CircuitState {
+	return r.breaker.state(id)
+}
+
+// connectGuarded connects the host like connectHost unless its circuit is open,
+// so a dead host in lazy mode is not dialed on every execution. A failed connect
+// counts as a failure of the host, a successful one leaves the outcome to the
+// execution that follows it.
+func (r *Remex) connectGuarded(id string) (RemoteClient, error) {
+	if err := r.breaker.allow(id); err != nil {
+		return nil, err
+	}
+
+	client, err := r.connectHost(id)
+	if err != nil {
+		r.recordBreaker(r.ctx, id, err)
+		return nil, err
+	}
+	r.breaker.abandon(id)
+	return client, nil
+}
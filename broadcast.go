@@ -0,0 +1,199 @@
+package remex
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+// BroadcastUpload uploads the local file to remotePath on every connected host in
+// parallel, bounded by WithMaxConcurrency, and returns the error of every host
+// keyed by ID, nil for the hosts that succeeded. The file is read once and the
+// same bytes are sent to every host, so it has to fit in memory. The upload runs
+// as the command "remex.upload <localPath> <remotePath>": it is checked by the
+// policy and the circuit breaker and reported to the handlers and Stats like a
+// command of Execute. The upload stops when ctx or the Remex context is done.
+func (r *Remex) BroadcastUpload(ctx context.Context, localPath, remotePath string) map[string]error {
+	ctx, stop := r.withEngineContext(ctx)
+	defer stop()
+
+	clients := r.targetClients()
+	results := make(map[string]error, len(clients))
+
+	done, err := r.startExecution()
+	if err != nil {
+		for id := range clients {
+			results[id] = err
+		}
+		return results
+	}
+	defer done()
+
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		err = fmt.Errorf("failed to read local file: %w", err)
+		for id := range clients {
+			results[id] = err
+		}
+		return results
+	}
+	command, err := QuoteCommand("remex.upload", localPath, remotePath)
+	if err != nil {
+		for id := range clients {
+			results[id] = err
+		}
+		return results
+	}
+
+	ctx, span := r.startSpan(ctx, "remex.BroadcastUpload", attrHosts.Int(len(clients)), attrCommand.String(command))
+
+	stats := newStatsRecorder()
+	connectErrs := r.connectLazy(clients)
+	maps.Copy(results, r.keepDisconnected(connectErrs))
+	for id, err := range connectErrs {
+		stats.done(id, err)
+		results[id] = err
+	}
+
+	upload := func(ctx context.Context, client RemoteClient, _ string) (CommandOutput, error) {
+		if _, err := UploadMemoryFile(ctx, client, bytes.NewReader(data), remotePath); err != nil {
+			return CommandOutput{ExitCode: -1}, err
+		}
+		return CommandOutput{}, nil
+	}
+
+	var (
+		mutex sync.Mutex
+		wg    sync.WaitGroup
+	)
+	for id, client := range clients {
+		wg.Go(func() {
+			err := r.execSteps(ctx, client, []string{command}, upload, stats.collect(nil))
+			stats.done(id, err)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			results[id] = err
+		})
+	}
+	wg.Wait()
+
+	r.recordStats(stats)
+
+	failed := 0
+	for _, err := range results {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		span.SetStatus(codes.Error, fmt.Sprintf("upload failed on %d of %d hosts", failed, len(results)))
+	}
+	span.End()
+
+	return results
+}
+
+// Broadcast executes command on every connected host in parallel, bounded by
+// WithMaxConcurrency, and returns the StageFinish result of every host keyed by
+// ID. The handlers are notified as for Execute. A host that could not run the
+// command, e.g. because it was blocked by the policy, gets a result with only
+// the error set. The execution stops when ctx or the Remex context is done.
+func (r *Remex) Broadcast(ctx context.Context, command string) map[string]ExecResult {
+	ctx, stop := r.withEngineContext(ctx)
+	defer stop()
+
+	clients := r.targetClients()
+	results := make(map[string]ExecResult, len(clients))
+
+	done, err := r.startExecution()
+	if err != nil {
+		for id, client := range clients {
+			results[id] = failedResult(id, client, command, err)
+		}
+		return results
+	}
+	defer done()
+
+	ctx, span := r.startSpan(ctx, "remex.Broadcast", attrHosts.Int(len(clients)), attrCommand.String(command))
+
+	stats := newStatsRecorder()
+	connectErrs := r.connectLazy(clients)
+	for id, err := range r.keepDisconnected(connectErrs) {
+		results[id] = failedResult(id, nil, command, err)
+	}
+	for id, err := range connectErrs {
+		stats.done(id, err)
+		results[id] = failedResult(id, nil, command, err)
+	}
+	hostCommands := r.renderHostCommands(clients, []string{command})
+
+	var (
+		mutex sync.Mutex
+		wg    sync.WaitGroup
+	)
+	for id, client := range clients {
+		wg.Go(func() {
+			var (
+				result   ExecResult
+				finished bool
+			)
+			err := r.execCommands(ctx, client, hostCommands[id], stats.collect(func(finish ExecResult) {
+				result, finished = finish, true
+			}))
+			stats.done(id, err)
+			if !finished {
+				result = failedResult(id, client, hostCommands[id][0], err)
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			results[id] = result
+		})
+	}
+	wg.Wait()
+
+	r.recordStats(stats)
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		span.SetStatus(codes.Error, fmt.Sprintf("command failed on %d of %d hosts", failed, len(results)))
+	}
+	span.End()
+
+	return results
+}
+
+// failedResult returns the result of a host that could not run command because of err
+func failedResult(id string, client RemoteClient, command string, err error) ExecResult {
+	result := ExecResult{ID: id, Command: command, Stage: StageFinish, ExitCode: -1, Error: err, Time: time.Now()}
+	if client != nil {
+		result.RemoteAddr = client.RemoteAddr()
+	}
+	return result
+}
+
+// withEngineContext returns a context that is also canceled when the Remex context
+// is done and runs the remex commands of the instance registry
+func (r *Remex) withEngineContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(withRegistry(ctx, r.commands))
+	stop := context.AfterFunc(r.ctx, func() {
+		cancel(context.Cause(r.ctx))
+	})
+
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
+}
@@ -3,12 +3,21 @@ package remex
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
@@ -20,53 +29,284 @@ import (
 // remexCommand represents an remex command function
 type remexCommand func(context.Context, *ssh.Client, ...string) (string, error)
 
+// CommandInfo describes a registered remex command, see ListCommandsWithMeta
+type CommandInfo struct {
+	// Name is the command name including the remex. prefix
+	Name string
+	// Usage shows the flags and arguments, e.g. "upload <local> <remote>"
+	Usage string
+	// Description is a short summary of what the command does
+	Description string
+}
+
+// registeredCommand is a remex command and its metadata, a nil fn hides the
+// command of the parent registry
+type registeredCommand struct {
+	fn   remexCommand
+	info CommandInfo
+}
+
 // remexRegistry manages remex commands
 type remexRegistry struct {
-	commands map[string]remexCommand
+	mutex    sync.RWMutex
+	commands map[string]registeredCommand
+	// parent provides the commands missing from commands
+	parent *remexRegistry
 }
 
-var registry = &remexRegistry{
-	commands: map[string]remexCommand{
-		"remex.upload":   uploadFile,
-		"remex.download": downloadFile,
-		"remex.exec":     localCommand,
-		"remex.mkdir":    createRemoteDirectory,
-	},
+var registry = &remexRegistry{commands: builtinCommands()}
+
+// builtinCommands returns the remex commands registered by default
+func builtinCommands() map[string]registeredCommand {
+	commands := make(map[string]registeredCommand)
+	add := func(fn remexCommand, name, usage, description string) {
+		commands["remex."+name] = registeredCommand{fn: fn, info: CommandInfo{Name: "remex." + name, Usage: usage, Description: description}}
+	}
+
+	add(uploadFile, "upload", "upload [flags] <local> <remote>", "upload a local file to the remote host")
+	add(downloadFile, "download", "download [flags] <remote> <local>", "download a remote file to the local host")
+	add(localCommand, "exec", "exec <script>", "run a shell script on the local host")
+	add(remoteScript, "rsh", "rsh <script>", "run a shell script on the remote host")
+	add(createRemoteDirectory, "mkdir", "mkdir <path>", "create a remote directory and its parents")
+	add(touchRemoteFile, "touch", "touch <path>", "create a remote file or update its times")
+	add(removeRemotePath, "rm", "rm [-r] <path>", "remove a remote file or empty directory")
+	add(removeRemoteTree, "rmdir", "rmdir <path>", "remove a remote directory tree")
+	add(renameRemotePath, "rename", "rename <old> <new>", "rename a remote path")
+	add(renameRemotePath, "mv", "mv <old> <new>", "rename a remote path")
+	add(createRemoteSymlink, "symlink", "symlink [-f] <target> <link>", "create a remote symbolic link")
+	add(readRemoteLink, "readlink", "readlink <path>", "print the target of a remote symbolic link")
+	add(fileExists, "exists", "exists <path>", "report whether a remote path exists")
+	add(filesExist, "existsAll", "existsAll <path>...", "report whether each remote path exists as JSON")
+	add(listRemoteDirectory, "ls", "ls [-json] <path>", "list a remote directory")
+	add(statRemotePath, "stat", "stat [-json] <path>", "print the metadata of a remote path")
+	add(catRemoteFile, "cat", "cat [-max-bytes n] <path>", "print a remote file")
+	add(tailRemoteFile, "tail", "tail [-f] <path> <lines>", "print the last lines of a remote file")
+	add(diskFree, "df", "df [-json] <path>", "print the disk usage of the remote file system")
+	add(diskUsage, "du", "du [-json] <path>", "print the total size of a remote path")
+	add(remoteChecksum, "sha256", "sha256 <path>", "print the SHA-256 checksum of a remote file")
+	add(remoteMD5, "md5", "md5 <path>", "print the MD5 checksum of a remote file")
+	return commands
 }
 
-// RegisterCommand registers a new remex command
-func RegisterCommand(name string, command remexCommand) error {
-	if name == "" {
+func (reg *remexRegistry) register(info CommandInfo, command remexCommand) error {
+	if info.Name == "" {
 		return errors.New("command name cannot be empty")
 	}
 	if command == nil {
 		return errors.New("command function cannot be nil")
 	}
+	info.Name = commandName(info.Name)
+
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+	reg.commands[info.Name] = registeredCommand{fn: command, info: info}
+	return nil
+}
+
+func (reg *remexRegistry) unregister(name string) bool {
+	name = commandName(name)
+
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	cmd, exists := reg.commands[name]
+	if reg.parent == nil {
+		delete(reg.commands, name)
+		return exists
+	}
+
+	if !exists {
+		_, exists = reg.parent.lookup(name)
+	} else {
+		exists = cmd.fn != nil
+	}
+	reg.commands[name] = registeredCommand{}
+	return exists
+}
+
+func (reg *remexRegistry) lookup(name string) (registeredCommand, bool) {
+	reg.mutex.RLock()
+	cmd, exists := reg.commands[name]
+	reg.mutex.RUnlock()
+
+	if !exists && reg.parent != nil {
+		return reg.parent.lookup(name)
+	}
+	return cmd, cmd.fn != nil
+}
+
+func (reg *remexRegistry) list() []CommandInfo {
+	var infos []CommandInfo
+	if reg.parent != nil {
+		infos = reg.parent.list()
+	}
+
+	reg.mutex.RLock()
+	defer reg.mutex.RUnlock()
+
+	infos = slices.DeleteFunc(infos, func(info CommandInfo) bool {
+		_, exists := reg.commands[info.Name]
+		return exists
+	})
+	for _, cmd := range reg.commands {
+		if cmd.fn != nil {
+			infos = append(infos, cmd.info)
+		}
+	}
+	return infos
+}
+
+func (reg *remexRegistry) names() []string {
+	var names []string
+	for _, info := range reg.list() {
+		names = append(names, info.Name)
+	}
+	return names
+}
+
+// commandName adds the remex. prefix to name when it is missing
+func commandName(name string) string {
 	if !strings.HasPrefix(name, "remex.") {
-		name = "remex." + name
+		return "remex." + name
 	}
+	return name
+}
 
-	registry.commands[name] = command
-	return nil
+// sortedCommandInfos returns infos sorted by name
+func sortedCommandInfos(infos []CommandInfo) []CommandInfo {
+	slices.SortFunc(infos, func(a, b CommandInfo) int { return strings.Compare(a.Name, b.Name) })
+	return infos
+}
+
+// RegisterCommand registers a new remex command
+func RegisterCommand(name string, command remexCommand) error {
+	return registry.register(CommandInfo{Name: name}, command)
+}
+
+// RegisterCommandWithMeta registers a new remex command like RegisterCommand
+// with the usage and description reported by DescribeCommand
+func RegisterCommandWithMeta(name, usage, desc string, command remexCommand) error {
+	return registry.register(CommandInfo{Name: name, Usage: usage, Description: desc}, command)
+}
+
+// UnregisterCommand removes a remex command, the remex. prefix is added to name
+// like in RegisterCommand. It reports whether the command was registered.
+func UnregisterCommand(name string) bool {
+	return registry.unregister(name)
+}
+
+// ResetCommands restores the built-in remex commands, dropping the registered
+// custom commands and undoing the replaced or removed built-in ones
+func ResetCommands() {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.commands = builtinCommands()
 }
 
 // GetCommand returns an remex command by name
 func GetCommand(name string) (remexCommand, bool) {
-	cmd, exists := registry.commands[name]
-	return cmd, exists
+	cmd, exists := registry.lookup(name)
+	return cmd.fn, exists
+}
+
+// DescribeCommand returns the metadata of a remex command, the remex. prefix
+// is added to name like in RegisterCommand
+func DescribeCommand(name string) (CommandInfo, bool) {
+	cmd, exists := registry.lookup(commandName(name))
+	return cmd.info, exists
 }
 
 // ListCommands returns all registered remex command names
 func ListCommands() []string {
-	names := make([]string, 0, len(registry.commands))
-	for name := range registry.commands {
-		names = append(names, name)
+	return registry.names()
+}
+
+// ListCommandsWithMeta returns the metadata of all registered remex commands sorted by name
+func ListCommandsWithMeta() []CommandInfo {
+	return sortedCommandInfos(registry.list())
+}
+
+// RegisterCommand registers a remex command for this instance only, it takes
+// precedence over the command of the same name registered with RegisterCommand
+func (r *Remex) RegisterCommand(name string, command remexCommand) error {
+	return r.commands.register(CommandInfo{Name: name}, command)
+}
+
+// RegisterCommandWithMeta registers a remex command for this instance only
+// like Remex.RegisterCommand, with its usage and description
+func (r *Remex) RegisterCommandWithMeta(name, usage, desc string, command remexCommand) error {
+	return r.commands.register(CommandInfo{Name: name, Usage: usage, Description: desc}, command)
+}
+
+// UnregisterCommand hides a remex command from this instance, including the
+// commands registered globally. It reports whether the command was available.
+func (r *Remex) UnregisterCommand(name string) bool {
+	return r.commands.unregister(name)
+}
+
+// ListCommands returns the remex command names available to this instance
+func (r *Remex) ListCommands() []string {
+	return r.commands.names()
+}
+
+// ListCommandsWithMeta returns the metadata of the remex commands available to
+// this instance sorted by name
+func (r *Remex) ListCommandsWithMeta() []CommandInfo {
+	return sortedCommandInfos(r.commands.list())
+}
+
+type registryKey struct{}
+
+// withRegistry makes the remex commands of reg available to the commands run with ctx
+func withRegistry(ctx context.Context, reg *remexRegistry) context.Context {
+	return context.WithValue(ctx, registryKey{}, reg)
+}
+
+// registryFromContext returns the registry of ctx, falling back to the global one
+func registryFromContext(ctx context.Context) *remexRegistry {
+	if reg, ok := ctx.Value(registryKey{}).(*remexRegistry); ok && reg != nil {
+		return reg
 	}
-	return names
+	return registry
+}
+
+type sshClientKey struct{}
+
+// withSSHClient makes the SSHClient running a remex command available to it
+func withSSHClient(ctx context.Context, sc *SSHClient) context.Context {
+	return context.WithValue(ctx, sshClientKey{}, sc)
+}
+
+// newSFTPClient returns an SFTP client for a remex command, the cached client of the
+// SSHClient running the command is reused when no custom options are given.
+// The returned release function closes the client unless it is cached.
+func newSFTPClient(ctx context.Context, client *ssh.Client, opts ...sftp.ClientOption) (*sftp.Client, func() error, error) {
+	if sc, ok := ctx.Value(sshClientKey{}).(*SSHClient); ok && len(opts) == 0 && client != nil && sc.Client == client {
+		sftpClient, err := sc.SFTPClient()
+		if err != nil {
+			return nil, nil, err
+		}
+		return sftpClient, func() error { return nil }, nil
+	}
+
+	sftpClient, err := sftp.NewClient(client, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sftpClient, sftpClient.Close, nil
 }
 
 // downloadFile downloads a file from remote host to local machine
 func downloadFile(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+	return downloadFileWithOptions(ctx, client, TransferOptions{}, args...)
+}
+
+// downloadFileWithOptions downloads a file using defaults overridden by the leading command flags
+func downloadFileWithOptions(ctx context.Context, client *ssh.Client, defaults TransferOptions, args ...string) (string, error) {
+	opts, args, err := parseTransferFlags("download", defaults, args)
+	if err != nil {
+		return "", err
+	}
 	if len(args) != 2 {
 		return "", errors.New("download requires exactly 2 arguments: remoteFilePath localFilePath")
 	}
@@ -86,11 +326,11 @@ func downloadFile(ctx context.Context, client *ssh.Client, args ...string) (stri
 		return "", fmt.Errorf("failed to create local directory: %w", err)
 	}
 
-	sftpClient, err := sftp.NewClient(client)
+	sftpClient, release, err := newSFTPClient(ctx, client, opts.SFTPOptions...)
 	if err != nil {
 		return "", fmt.Errorf("failed to create SFTP client: %w", err)
 	}
-	defer sftpClient.Close()
+	defer release()
 
 	// Check if remote file exists
 	remoteFileInfo, err := sftpClient.Stat(remoteFilePath)
@@ -107,25 +347,76 @@ func downloadFile(ctx context.Context, client *ssh.Client, args ...string) (stri
 	}
 	defer remoteFile.Close()
 
-	localFile, err := os.Create(localFilePath)
+	localFile, offset, err := openDownloadFile(localFilePath, remoteFileInfo.Size(), opts.Resume)
 	if err != nil {
-		return "", fmt.Errorf("failed to create local file: %w", err)
+		return "", err
 	}
 	defer localFile.Close()
 
-	bytesCopied, err := io.Copy(localFile, newInterruptibleReader(ctx, remoteFile))
+	var (
+		dst  io.Writer = localFile
+		hash           = sha256.New()
+	)
+	if opts.VerifyChecksum {
+		dst = io.MultiWriter(localFile, hash)
+	}
+
+	if offset > 0 {
+		// The checksum has to cover the part downloaded previously
+		if opts.VerifyChecksum {
+			if _, err := io.Copy(hash, io.NewSectionReader(localFile, 0, offset)); err != nil {
+				return "", fmt.Errorf("failed to read local file: %w", err)
+			}
+		}
+		if _, err := remoteFile.Seek(offset, io.SeekStart); err != nil {
+			return "", fmt.Errorf("failed to seek remote file: %w", err)
+		}
+	}
+
+	bytesCopied, err := copyBuffer(NewInterruptibleWriter(ctx, dst), newInterruptibleReader(ctx, remoteFile), opts)
 	if err != nil {
-		// Clean up partially downloaded file
-		os.Remove(localFilePath)
+		// Clean up partially downloaded file unless it can be resumed later
+		if !opts.Resume {
+			os.Remove(localFilePath)
+		}
 		return "", fmt.Errorf("failed to copy file content: %w", err)
 	}
 
+	if opts.VerifyChecksum {
+		if err := verifyRemoteChecksum(ctx, client, sftpClient, remoteFilePath, hash.Sum(nil)); err != nil {
+			localFile.Close()
+			os.Remove(localFilePath)
+			return "", err
+		}
+	}
+
+	if opts.PreserveTimes {
+		localFile.Close()
+		atime, mtime := fileTimes(remoteFileInfo)
+		if err := os.Chtimes(localFilePath, atime, mtime); err != nil {
+			return "", fmt.Errorf("failed to preserve modification time: %w", err)
+		}
+	}
+
+	if offset > 0 {
+		return fmt.Sprintf("Download completed: %d bytes transferred from %s to %s, resumed at byte %d",
+			bytesCopied, remoteFilePath, localFilePath, offset), nil
+	}
 	return fmt.Sprintf("Download completed: %d bytes transferred from %s to %s",
 		bytesCopied, remoteFilePath, localFilePath), nil
 }
 
 // uploadFile uploads a file from local machine to remote host
 func uploadFile(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+	return uploadFileWithOptions(ctx, client, TransferOptions{}, args...)
+}
+
+// uploadFileWithOptions uploads a file using defaults overridden by the leading command flags
+func uploadFileWithOptions(ctx context.Context, client *ssh.Client, defaults TransferOptions, args ...string) (string, error) {
+	opts, args, err := parseTransferFlags("upload", defaults, args)
+	if err != nil {
+		return "", err
+	}
 	if len(args) != 2 {
 		return "", errors.New("upload requires exactly 2 arguments: localFilePath remoteFilePath")
 	}
@@ -155,7 +446,7 @@ func uploadFile(ctx context.Context, client *ssh.Client, args ...string) (string
 	}
 	defer localFile.Close()
 
-	bytesCopied, err := uploadMemoryFile(ctx, client, localFile, remoteFilePath)
+	bytesCopied, err := uploadMemoryFile(ctx, client, localFile, remoteFilePath, opts)
 	if err != nil {
 		return "", err
 	}
@@ -167,7 +458,7 @@ func uploadFile(ctx context.Context, client *ssh.Client, args ...string) (string
 // UploadMemoryFileCommand uploads a file from memory to the remote server.
 func UploadMemoryFileCommand(data []byte, remoteFilePath string) remexCommand {
 	return func(ctx context.Context, client *ssh.Client, _ ...string) (string, error) {
-		bytesCopied, err := uploadMemoryFile(ctx, client, bytes.NewReader(data), remoteFilePath)
+		bytesCopied, err := uploadMemoryFile(ctx, client, bytes.NewReader(data), remoteFilePath, TransferOptions{})
 		if err != nil {
 			return "", err
 		}
@@ -179,48 +470,127 @@ func UploadMemoryFileCommand(data []byte, remoteFilePath string) remexCommand {
 
 // UploadMemoryFile uploads a file from memory to the remote server.
 func UploadMemoryFile(ctx context.Context, r RemoteClient, reader io.Reader, remoteFilePath string) (int64, error) {
+	return UploadMemoryFileWithOptions(ctx, r, reader, remoteFilePath, TransferOptions{})
+}
+
+// UploadMemoryFileWithOptions uploads a file from memory to the remote server using the given transfer options.
+func UploadMemoryFileWithOptions(ctx context.Context, r RemoteClient, reader io.Reader, remoteFilePath string, opts TransferOptions) (int64, error) {
 	if client, ok := r.(*SSHClient); ok {
-		return uploadMemoryFile(ctx, client.Client, reader, remoteFilePath)
+		return uploadMemoryFile(withSSHClient(ctx, client), client.Client, reader, remoteFilePath, opts)
 	}
 	return 0, errors.New("unsupported remote client type")
 }
 
-func uploadMemoryFile(ctx context.Context, client *ssh.Client, reader io.Reader, remoteFilePath string) (int64, error) {
+func uploadMemoryFile(ctx context.Context, client *ssh.Client, reader io.Reader, remoteFilePath string, opts TransferOptions) (int64, error) {
 	if client == nil {
-		return 0, errors.New("ssh client is nil")
+		return 0, ErrClientNil
 	}
 	if remoteFilePath == "" {
 		return 0, errors.New("remote file path cannot be empty")
 	}
 
-	sftpClient, err := sftp.NewClient(client)
+	sftpClient, release, err := newSFTPClient(ctx, client, opts.SFTPOptions...)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create SFTP client: %w", err)
 	}
-	defer sftpClient.Close()
+	defer release()
 
 	// Create remote directory if it doesn't exist
-	if err := sftpClient.MkdirAll(filepath.ToSlash(filepath.Dir(remoteFilePath))); err != nil {
+	remoteDir := filepath.ToSlash(filepath.Dir(remoteFilePath))
+	if err := sftpClient.MkdirAll(remoteDir); err != nil {
 		return 0, fmt.Errorf("failed to create remote directory: %w", err)
 	}
 
-	remoteFile, err := sftpClient.Create(remoteFilePath)
+	if size := uploadSize(reader); opts.CheckSpace && size >= 0 {
+		if err := checkRemoteSpace(ctx, client, sftpClient, remoteDir, size); err != nil {
+			return 0, err
+		}
+	}
+
+	// Write to a temporary file first so readers never see a partial file
+	target := remoteFilePath
+	if !opts.DirectWrite {
+		target = remoteFilePath + AtomicUploadSuffix
+	}
+
+	remoteFile, err := sftpClient.Create(target)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create remote file: %w", err)
 	}
 	defer remoteFile.Close()
 
-	bytesCopied, err := io.Copy(remoteFile, newInterruptibleReader(ctx, reader))
+	// Clean up partially uploaded file
+	cleanup := func() {
+		remoteFile.Close()
+		sftpClient.Remove(target)
+	}
+
+	// 读取前记录，reader 可能被替换为 TeeReader
+	var localInfo os.FileInfo
+	if file, ok := reader.(*os.File); ok && opts.PreserveTimes {
+		localInfo, _ = file.Stat()
+	}
+
+	hash := sha256.New()
+	if opts.VerifyChecksum {
+		reader = io.TeeReader(reader, hash)
+	}
+
+	bytesCopied, err := copyBuffer(remoteFile, newInterruptibleReader(ctx, reader), opts)
 	if err != nil {
-		// Clean up partially uploaded file
-		sftpClient.Remove(remoteFilePath)
+		cleanup()
 		return 0, fmt.Errorf("failed to copy file content: %w", err)
 	}
 
+	if !opts.DirectWrite {
+		// 服务器不支持 fsync 扩展时跳过
+		var statusErr *sftp.StatusError
+		if err := remoteFile.Sync(); err != nil && !(errors.As(err, &statusErr) && statusErr.FxCode() == sftp.ErrSSHFxOpUnsupported) {
+			cleanup()
+			return 0, fmt.Errorf("failed to sync remote file: %w", err)
+		}
+	}
+
+	if err := remoteFile.Close(); err != nil {
+		cleanup()
+		return 0, fmt.Errorf("failed to close remote file: %w", err)
+	}
+
+	// 在重命名前设置，rename 会保留临时文件的时间
+	if localInfo != nil {
+		atime, mtime := fileTimes(localInfo)
+		if err := sftpClient.Chtimes(target, atime, mtime); err != nil {
+			cleanup()
+			return 0, fmt.Errorf("failed to preserve modification time: %w", err)
+		}
+	}
+
+	if opts.VerifyChecksum {
+		if err := verifyRemoteChecksum(ctx, client, sftpClient, target, hash.Sum(nil)); err != nil {
+			cleanup()
+			return 0, err
+		}
+	}
+
+	if !opts.DirectWrite {
+		// 临时文件以默认权限创建，替换已有文件时沿用其权限
+		if info, err := sftpClient.Stat(remoteFilePath); err == nil {
+			if err := sftpClient.Chmod(target, info.Mode().Perm()); err != nil {
+				cleanup()
+				return 0, fmt.Errorf("failed to preserve remote file mode: %w", err)
+			}
+		}
+
+		if err := replaceFile(sftpClient, target, remoteFilePath); err != nil {
+			cleanup()
+			return 0, fmt.Errorf("failed to replace remote file: %w", err)
+		}
+	}
+
 	return bytesCopied, nil
 }
 
-// localCommand runs a local command on the local host
+// localCommand runs a shell command on the local host, use remex.rsh to run it on the remote host
 func localCommand(ctx context.Context, _ *ssh.Client, args ...string) (string, error) {
 	if len(args) == 0 {
 		return "", errors.New("command execution requires at least one argument")
@@ -238,6 +608,32 @@ func localCommand(ctx context.Context, _ *ssh.Client, args ...string) (string, e
 	return b.String(), nil
 }
 
+// remoteScript runs a shell script on the remote host by streaming it to the remote shell
+func remoteScript(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("remote script execution requires at least one argument")
+	}
+	if client == nil {
+		return "", ErrClientNil
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("failed to create session: %w", err)
+	}
+	defer session.Close()
+
+	var b lockedBuffer
+	session.Stdin = strings.NewReader(strings.Join(args, " ") + "\n")
+	session.Stdout = &b
+	session.Stderr = &b
+
+	if err := runSession(ctx, session, "sh -s"); err != nil {
+		return b.String(), err
+	}
+	return b.String(), nil
+}
+
 // createRemoteDirectory creates a directory on the remote host
 func createRemoteDirectory(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
 	if len(args) != 1 {
@@ -249,11 +645,11 @@ func createRemoteDirectory(ctx context.Context, client *ssh.Client, args ...stri
 		return "", errors.New("directory path cannot be empty")
 	}
 
-	sftpClient, err := sftp.NewClient(client)
+	sftpClient, release, err := newSFTPClient(ctx, client)
 	if err != nil {
 		return "", fmt.Errorf("failed to create SFTP client: %w", err)
 	}
-	defer sftpClient.Close()
+	defer release()
 
 	if err := sftpClient.MkdirAll(directoryPath); err != nil {
 		return "", fmt.Errorf("failed to create remote directory: %w", err)
@@ -262,6 +658,652 @@ func createRemoteDirectory(ctx context.Context, client *ssh.Client, args ...stri
 	return fmt.Sprintf("Directory created successfully: %s", directoryPath), nil
 }
 
+// touchRemoteFile creates an empty remote file, or updates the access and
+// modification times of an existing one to now
+func touchRemoteFile(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("touch requires exactly one argument: path")
+	}
+
+	remotePath := strings.TrimSpace(args[0])
+	if remotePath == "" {
+		return "", errors.New("path cannot be empty")
+	}
+
+	sftpClient, release, err := newSFTPClient(ctx, client)
+	if err != nil {
+		return "", fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	defer release()
+
+	if _, err := sftpClient.Stat(remotePath); err == nil {
+		now := time.Now()
+		if err := sftpClient.Chtimes(remotePath, now, now); err != nil {
+			return "", fmt.Errorf("failed to update remote file times: %w", err)
+		}
+		return fmt.Sprintf("File times updated: %s", remotePath), nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to stat remote file: %w", err)
+	}
+
+	if err := sftpClient.MkdirAll(path.Dir(remotePath)); err != nil {
+		return "", fmt.Errorf("failed to create remote directory: %w", err)
+	}
+
+	// 不截断并发创建的同名文件
+	file, err := sftpClient.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		return "", fmt.Errorf("failed to create remote file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("failed to close remote file: %w", err)
+	}
+
+	return fmt.Sprintf("File created: %s", remotePath), nil
+}
+
+// fileExists reports whether a path exists on the remote host, returning "true" or "false"
+func fileExists(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("exists requires exactly one argument: path")
+	}
+
+	path := strings.TrimSpace(args[0])
+	if path == "" {
+		return "", errors.New("path cannot be empty")
+	}
+
+	sftpClient, release, err := newSFTPClient(ctx, client)
+	if err != nil {
+		return "", fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	defer release()
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	if _, err := sftpClient.Stat(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "false", nil
+		}
+		return "", fmt.Errorf("failed to stat remote path: %w", err)
+	}
+
+	return "true", nil
+}
+
+// filesExist reports whether each of the paths exists on the remote host as a JSON
+// object mapping the paths to true or false, all paths are checked over one SFTP client
+func filesExist(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("existsAll requires at least one argument: path")
+	}
+
+	paths := make([]string, len(args))
+	for i, arg := range args {
+		if paths[i] = strings.TrimSpace(arg); paths[i] == "" {
+			return "", errors.New("path cannot be empty")
+		}
+	}
+
+	sftpClient, release, err := newSFTPClient(ctx, client)
+	if err != nil {
+		return "", fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	defer release()
+
+	exists := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		_, err := sftpClient.Stat(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to stat remote path %s: %w", path, err)
+		}
+		exists[path] = err == nil
+	}
+
+	data, err := json.Marshal(exists)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode result: %w", err)
+	}
+	return string(data), nil
+}
+
+// RemoteFileInfo describes a remote file in the JSON output of remex.ls and remex.stat
+type RemoteFileInfo struct {
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+	IsDir   bool        `json:"is_dir"`
+}
+
+func newRemoteFileInfo(info os.FileInfo) RemoteFileInfo {
+	return RemoteFileInfo{
+		Name:    info.Name(),
+		Size:    info.Size(),
+		Mode:    info.Mode(),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+	}
+}
+
+// listRemoteDirectory lists a remote directory as a table, or as a JSON array of RemoteFileInfo with -json
+func listRemoteDirectory(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+	var asJSON bool
+
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&asJSON, "json", false, "print the listing as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return "", fmt.Errorf("invalid ls flags: %w", err)
+	}
+	if args = fs.Args(); len(args) != 1 {
+		return "", errors.New("ls requires exactly one argument: directoryPath")
+	}
+
+	directoryPath := strings.TrimSpace(args[0])
+	if directoryPath == "" {
+		return "", errors.New("directory path cannot be empty")
+	}
+
+	sftpClient, release, err := newSFTPClient(ctx, client)
+	if err != nil {
+		return "", fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	defer release()
+
+	infos, err := sftpClient.ReadDirContext(ctx, directoryPath)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+		return "", fmt.Errorf("failed to read remote directory: %w", err)
+	}
+
+	entries := make([]RemoteFileInfo, len(infos))
+	for i, info := range infos {
+		entries[i] = newRemoteFileInfo(info)
+	}
+	slices.SortFunc(entries, func(a, b RemoteFileInfo) int { return strings.Compare(a.Name, b.Name) })
+
+	if asJSON {
+		data, err := json.Marshal(entries)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode listing: %w", err)
+		}
+		return string(data), nil
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", entry.Mode, entry.Size, entry.ModTime.Format(time.RFC3339), entry.Name)
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// statRemotePath prints the metadata of a remote path, or a RemoteFileInfo as JSON with -json.
+// A missing path is reported as an error wrapping os.ErrNotExist.
+func statRemotePath(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+	var asJSON bool
+
+	fs := flag.NewFlagSet("stat", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&asJSON, "json", false, "print the metadata as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return "", fmt.Errorf("invalid stat flags: %w", err)
+	}
+	if args = fs.Args(); len(args) != 1 {
+		return "", errors.New("stat requires exactly one argument: path")
+	}
+
+	path := strings.TrimSpace(args[0])
+	if path == "" {
+		return "", errors.New("path cannot be empty")
+	}
+
+	sftpClient, release, err := newSFTPClient(ctx, client)
+	if err != nil {
+		return "", fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	defer release()
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	info, err := sftpClient.Stat(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("remote path not found: %w", err)
+		}
+		return "", fmt.Errorf("failed to stat remote path: %w", err)
+	}
+
+	entry := newRemoteFileInfo(info)
+	if asJSON {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode metadata: %w", err)
+		}
+		return string(data), nil
+	}
+
+	return fmt.Sprintf("name: %s\nsize: %d\nmode: %s\nmod_time: %s\nis_dir: %t\n",
+		entry.Name, entry.Size, entry.Mode, entry.ModTime.Format(time.RFC3339), entry.IsDir), nil
+}
+
+// DefaultCatMaxBytes is the largest file remex.cat reads when -max-bytes is not given
+var DefaultCatMaxBytes int64 = 1 << 20
+
+// catRemoteFile returns the contents of a remote file, files larger than -max-bytes
+// are rejected. A missing file is reported as an error wrapping os.ErrNotExist.
+func catRemoteFile(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+	maxBytes := DefaultCatMaxBytes
+
+	fs := flag.NewFlagSet("cat", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Int64Var(&maxBytes, "max-bytes", maxBytes, "maximum size of the file in bytes")
+
+	if err := fs.Parse(args); err != nil {
+		return "", fmt.Errorf("invalid cat flags: %w", err)
+	}
+	if args = fs.Args(); len(args) != 1 {
+		return "", errors.New("cat requires exactly one argument: path")
+	}
+
+	path := strings.TrimSpace(args[0])
+	if path == "" {
+		return "", errors.New("path cannot be empty")
+	}
+
+	sftpClient, release, err := newSFTPClient(ctx, client)
+	if err != nil {
+		return "", fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	defer release()
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	remoteFile, err := sftpClient.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("remote file not found: %w", err)
+		}
+		return "", fmt.Errorf("failed to open remote file: %w", err)
+	}
+	defer remoteFile.Close()
+
+	// 不依赖 Stat 的大小，文件可能在读取时增长
+	data, err := io.ReadAll(io.LimitReader(newInterruptibleReader(ctx, remoteFile), maxBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read remote file: %w", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return "", fmt.Errorf("remote file %s is larger than %d bytes", path, maxBytes)
+	}
+
+	return string(data), nil
+}
+
+// diskFree prints the usage of the filesystem holding a remote path, or a DiskUsage as JSON with -json
+func diskFree(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+	var asJSON bool
+
+	fs := flag.NewFlagSet("df", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&asJSON, "json", false, "print the usage as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return "", fmt.Errorf("invalid df flags: %w", err)
+	}
+	if args = fs.Args(); len(args) != 1 {
+		return "", errors.New("df requires exactly one argument: path")
+	}
+
+	path := strings.TrimSpace(args[0])
+	if path == "" {
+		return "", errors.New("path cannot be empty")
+	}
+
+	sftpClient, release, err := newSFTPClient(ctx, client)
+	if err != nil {
+		return "", fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	defer release()
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	usage, err := remoteDiskUsage(ctx, client, sftpClient, path)
+	if err != nil {
+		return "", fmt.Errorf("failed to get remote disk usage: %w", err)
+	}
+
+	if asJSON {
+		data, err := json.Marshal(usage)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode disk usage: %w", err)
+		}
+		return string(data), nil
+	}
+
+	return fmt.Sprintf("path: %s\ntotal: %d\nused: %d\nfree: %d\n", usage.Path, usage.Total, usage.Used, usage.Free), nil
+}
+
+// PathSize is the total size of a remote directory tree in the JSON output of remex.du
+type PathSize struct {
+	Path string `json:"path"`
+	// Size is the apparent size in bytes, like du -sb
+	Size int64 `json:"size"`
+}
+
+// diskUsage prints the total size of a remote directory tree, or a PathSize as JSON
+// with -json. It runs du -sb on the remote host and falls back to walking the tree over SFTP.
+func diskUsage(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+	var asJSON bool
+
+	fs := flag.NewFlagSet("du", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&asJSON, "json", false, "print the size as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return "", fmt.Errorf("invalid du flags: %w", err)
+	}
+	if args = fs.Args(); len(args) != 1 {
+		return "", errors.New("du requires exactly one argument: path")
+	}
+
+	path := strings.TrimSpace(args[0])
+	if path == "" {
+		return "", errors.New("path cannot be empty")
+	}
+
+	size, err := remoteTreeSize(ctx, client, path)
+	if err != nil {
+		return "", err
+	}
+
+	if asJSON {
+		data, err := json.Marshal(PathSize{Path: path, Size: size})
+		if err != nil {
+			return "", fmt.Errorf("failed to encode size: %w", err)
+		}
+		return string(data), nil
+	}
+
+	return fmt.Sprintf("path: %s\nsize: %d\n", path, size), nil
+}
+
+// remoteTreeSize returns the apparent size of a remote directory tree
+func remoteTreeSize(ctx context.Context, client *ssh.Client, remotePath string) (int64, error) {
+	if quoted, err := QuoteArg(remotePath); err == nil {
+		output, err := ExecRemoteCommandWithOptions(ctx, client, "du -sb -- "+quoted, ExecOptions{})
+		if err == nil {
+			if fields := strings.Fields(output.Stdout); len(fields) > 0 {
+				if size, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+					return size, nil
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	// 远程主机没有 GNU du 时通过 SFTP 遍历目录树累加
+	sftpClient, release, err := newSFTPClient(ctx, client)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	defer release()
+
+	if _, err := sftpClient.Lstat(remotePath); err != nil {
+		return 0, fmt.Errorf("remote path not found: %w", err)
+	}
+
+	var size int64
+	walker := sftpClient.Walk(remotePath)
+	for walker.Step() {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		if err := walker.Err(); err != nil {
+			return 0, fmt.Errorf("failed to walk remote directory: %w", err)
+		}
+		size += walker.Stat().Size()
+	}
+	return size, nil
+}
+
+// removeRemotePath removes a remote file or empty directory, with -r it removes a directory tree
+func removeRemotePath(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+	return removeRemote(ctx, client, "rm", false, args...)
+}
+
+// removeRemoteTree removes a remote directory and everything below it
+func removeRemoteTree(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+	return removeRemote(ctx, client, "rmdir", true, args...)
+}
+
+func removeRemote(ctx context.Context, client *ssh.Client, name string, recursive bool, args ...string) (string, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&recursive, "r", recursive, "remove directories and their contents recursively")
+
+	if err := fs.Parse(args); err != nil {
+		return "", fmt.Errorf("invalid %s flags: %w", name, err)
+	}
+	if args = fs.Args(); len(args) != 1 {
+		return "", fmt.Errorf("%s requires exactly one argument: path", name)
+	}
+
+	remotePath := strings.TrimSpace(args[0])
+	if remotePath == "" {
+		return "", errors.New("path cannot be empty")
+	}
+	// 拒绝删除根目录和登录目录
+	switch path.Clean(remotePath) {
+	case "/", ".", "..":
+		return "", fmt.Errorf("refusing to remove %s", remotePath)
+	}
+
+	sftpClient, release, err := newSFTPClient(ctx, client)
+	if err != nil {
+		return "", fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	defer release()
+
+	info, err := sftpClient.Lstat(remotePath)
+	if err != nil {
+		return "", fmt.Errorf("remote path not found: %w", err)
+	}
+
+	paths := []string{remotePath}
+	if recursive && info.IsDir() {
+		paths = paths[:0]
+		// Walk 使用 Lstat，不会进入符号链接指向的目录
+		walker := sftpClient.Walk(remotePath)
+		for walker.Step() {
+			if err := ctx.Err(); err != nil {
+				return "", err
+			}
+			if err := walker.Err(); err != nil {
+				return "", fmt.Errorf("failed to walk remote directory: %w", err)
+			}
+			paths = append(paths, walker.Path())
+		}
+	}
+
+	// 先删除子项再删除父目录
+	var removed int
+	for _, p := range slices.Backward(paths) {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if err := sftpClient.Remove(p); err != nil {
+			return "", fmt.Errorf("failed to remove %s after removing %d entries: %w", p, removed, err)
+		}
+		removed++
+	}
+
+	return fmt.Sprintf("Removed %d entries: %s", removed, remotePath), nil
+}
+
+// renameRemotePath renames a remote file or directory, replacing the target when the server supports it
+func renameRemotePath(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+	if len(args) != 2 {
+		return "", errors.New("rename requires exactly 2 arguments: oldPath newPath")
+	}
+
+	oldPath := strings.TrimSpace(args[0])
+	newPath := strings.TrimSpace(args[1])
+
+	if oldPath == "" {
+		return "", errors.New("old path cannot be empty")
+	}
+	if newPath == "" {
+		return "", errors.New("new path cannot be empty")
+	}
+
+	sftpClient, release, err := newSFTPClient(ctx, client)
+	if err != nil {
+		return "", fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	defer release()
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	if err := posixRename(sftpClient, oldPath, newPath); err != nil {
+		return "", fmt.Errorf("failed to rename remote path: %w", err)
+	}
+
+	return fmt.Sprintf("Rename completed: %s to %s", oldPath, newPath), nil
+}
+
+// createRemoteSymlink creates a remote symbolic link, with -f an existing link is
+// replaced atomically by renaming a temporary link over it
+func createRemoteSymlink(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+	var force bool
+	fs := flag.NewFlagSet("symlink", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&force, "f", false, "replace an existing link")
+
+	if err := fs.Parse(args); err != nil {
+		return "", fmt.Errorf("invalid symlink flags: %w", err)
+	}
+	if args = fs.Args(); len(args) != 2 {
+		return "", errors.New("symlink requires exactly 2 arguments: target linkPath")
+	}
+
+	target := strings.TrimSpace(args[0])
+	linkPath := strings.TrimSpace(args[1])
+
+	if target == "" {
+		return "", errors.New("target cannot be empty")
+	}
+	if linkPath == "" {
+		return "", errors.New("link path cannot be empty")
+	}
+
+	sftpClient, release, err := newSFTPClient(ctx, client)
+	if err != nil {
+		return "", fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	defer release()
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	if !force {
+		if err := sftpClient.Symlink(target, linkPath); err != nil {
+			return "", fmt.Errorf("failed to create remote symlink: %w", err)
+		}
+		return fmt.Sprintf("Symlink created: %s -> %s", linkPath, target), nil
+	}
+
+	// SFTP 不能覆盖已存在的链接，先创建临时链接再重命名，服务器不支持 posix-rename 时替换不是原子的
+	tmpPath := linkPath + AtomicUploadSuffix
+	sftpClient.Remove(tmpPath)
+	if err := sftpClient.Symlink(target, tmpPath); err != nil {
+		return "", fmt.Errorf("failed to create remote symlink: %w", err)
+	}
+	if err := replaceFile(sftpClient, tmpPath, linkPath); err != nil {
+		sftpClient.Remove(tmpPath)
+		return "", fmt.Errorf("failed to replace remote symlink: %w", err)
+	}
+
+	return fmt.Sprintf("Symlink replaced: %s -> %s", linkPath, target), nil
+}
+
+// readRemoteLink returns the target of a remote symbolic link
+func readRemoteLink(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("readlink requires exactly one argument: path")
+	}
+
+	linkPath := strings.TrimSpace(args[0])
+	if linkPath == "" {
+		return "", errors.New("path cannot be empty")
+	}
+
+	sftpClient, release, err := newSFTPClient(ctx, client)
+	if err != nil {
+		return "", fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	defer release()
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	target, err := sftpClient.ReadLink(linkPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read remote symlink: %w", err)
+	}
+
+	return target, nil
+}
+
+// replaceFile moves oldPath over newPath like posixRename. Servers without the
+// posix-rename extension refuse to rename over an existing file, there newPath is
+// removed first, so the replacement is not atomic and readers may briefly find
+// no file at newPath.
+func replaceFile(sftpClient *sftp.Client, oldPath, newPath string) error {
+	if _, ok := sftpClient.HasExtension("posix-rename@openssh.com"); ok {
+		return sftpClient.PosixRename(oldPath, newPath)
+	}
+	if err := sftpClient.Remove(newPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove the file being replaced: %w", err)
+	}
+	return sftpClient.Rename(oldPath, newPath)
+}
+
+// posixRename atomically replaces newPath with oldPath using the posix-rename
+// extension, falling back to a plain SFTP rename that fails if newPath exists
+func posixRename(sftpClient *sftp.Client, oldPath, newPath string) error {
+	if _, ok := sftpClient.HasExtension("posix-rename@openssh.com"); ok {
+		return sftpClient.PosixRename(oldPath, newPath)
+	}
+	return sftpClient.Rename(oldPath, newPath)
+}
+
 type interruptibleReader func(p []byte) (n int, err error)
 
 func (r interruptibleReader) Read(p []byte) (n int, err error) {
@@ -278,3 +1320,23 @@ func newInterruptibleReader(ctx context.Context, r io.Reader) io.Reader {
 		}
 	})
 }
+
+type interruptibleWriter func(p []byte) (n int, err error)
+
+func (w interruptibleWriter) Write(p []byte) (n int, err error) {
+	return w(p)
+}
+
+// NewInterruptibleWriter returns a writer that fails with the error of ctx once it
+// is done instead of writing to w, e.g. to stop an io.Copy to a slow local disk.
+// A write already blocked in w is not interrupted.
+func NewInterruptibleWriter(ctx context.Context, w io.Writer) io.Writer {
+	return interruptibleWriter(func(p []byte) (n int, err error) {
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		default:
+			return w.Write(p)
+		}
+	})
+}
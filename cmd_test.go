@@ -1,25 +1,33 @@
 package remex
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"maps"
+	"math"
 	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
 	"slices"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
 // TestRegisterCommand 测试 RegisterCommand 函数
 func TestRegisterCommand(t *testing.T) {
-	// 保存原始命令以便测试后恢复
-	originalCommands := make(map[string]remexCommand)
-	maps.Copy(originalCommands, registry.commands)
-
-	defer func() {
-		registry.commands = originalCommands
-	}()
+	defer ResetCommands()
 
 	testCases := []struct {
 		name          string
@@ -95,6 +103,152 @@ func TestRegisterCommand(t *testing.T) {
 	}
 }
 
+// TestUnregisterCommand 测试 UnregisterCommand 和 ResetCommands 函数
+func TestUnregisterCommand(t *testing.T) {
+	defer ResetCommands()
+
+	custom := func(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+		return "custom", nil
+	}
+	if err := RegisterCommand("custom", custom); err != nil {
+		t.Fatalf("RegisterCommand() error = %v", err)
+	}
+
+	if !UnregisterCommand("custom") {
+		t.Error("UnregisterCommand(custom) = false, want true")
+	}
+	if _, exists := GetCommand("remex.custom"); exists {
+		t.Error("GetCommand() found remex.custom after unregistration")
+	}
+	if UnregisterCommand("remex.custom") {
+		t.Error("UnregisterCommand(remex.custom) = true for removed command")
+	}
+
+	if !UnregisterCommand("remex.rm") {
+		t.Error("UnregisterCommand(remex.rm) = false, want true")
+	}
+	if err := RegisterCommand("custom", custom); err != nil {
+		t.Fatalf("RegisterCommand() error = %v", err)
+	}
+
+	ResetCommands()
+	if _, exists := GetCommand("remex.rm"); !exists {
+		t.Error("GetCommand() did not find remex.rm after ResetCommands")
+	}
+	if _, exists := GetCommand("remex.custom"); exists {
+		t.Error("GetCommand() found remex.custom after ResetCommands")
+	}
+	if got, want := len(ListCommands()), len(builtinCommands()); got != want {
+		t.Errorf("len(ListCommands()) = %d, want %d", got, want)
+	}
+}
+
+// TestDescribeCommand 测试命令元数据的注册与查询
+func TestDescribeCommand(t *testing.T) {
+	defer ResetCommands()
+
+	infos := ListCommandsWithMeta()
+	if !slices.IsSortedFunc(infos, func(a, b CommandInfo) int { return strings.Compare(a.Name, b.Name) }) {
+		t.Errorf("ListCommandsWithMeta() is not sorted by name")
+	}
+	if len(infos) != len(builtinCommands()) {
+		t.Errorf("len(ListCommandsWithMeta()) = %d, want %d", len(infos), len(builtinCommands()))
+	}
+	for _, info := range infos {
+		if !strings.HasPrefix("remex."+info.Usage, info.Name) || info.Description == "" {
+			t.Errorf("built-in command %s has usage %q and description %q", info.Name, info.Usage, info.Description)
+		}
+	}
+
+	info, ok := DescribeCommand("upload")
+	if want := (CommandInfo{Name: "remex.upload", Usage: "upload [flags] <local> <remote>", Description: "upload a local file to the remote host"}); !ok || info != want {
+		t.Errorf("DescribeCommand(upload) = %+v, %v, want %+v", info, ok, want)
+	}
+	if _, ok := DescribeCommand("remex.nonexistent"); ok {
+		t.Error("DescribeCommand(remex.nonexistent) ok = true")
+	}
+
+	greet := func(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+		return "hello", nil
+	}
+	if err := RegisterCommandWithMeta("greet", "greet <name>", "print a greeting", greet); err != nil {
+		t.Fatalf("RegisterCommandWithMeta() error = %v", err)
+	}
+	info, ok = DescribeCommand("remex.greet")
+	if want := (CommandInfo{Name: "remex.greet", Usage: "greet <name>", Description: "print a greeting"}); !ok || info != want {
+		t.Errorf("DescribeCommand(remex.greet) = %+v, %v, want %+v", info, ok, want)
+	}
+	if err := RegisterCommandWithMeta("", "", "", greet); err == nil {
+		t.Error("RegisterCommandWithMeta() with empty name error = nil")
+	}
+
+	// 不带元数据重新注册会清除之前的描述
+	if err := RegisterCommand("greet", greet); err != nil {
+		t.Fatalf("RegisterCommand() error = %v", err)
+	}
+	if info, _ := DescribeCommand("greet"); info != (CommandInfo{Name: "remex.greet"}) {
+		t.Errorf("DescribeCommand(greet) = %+v after RegisterCommand", info)
+	}
+}
+
+// TestRangeArgs 测试 ExactArgs、MinArgs 和 RangeArgs 的参数校验
+func TestRangeArgs(t *testing.T) {
+	echo := func(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+		return strings.Join(args, ","), nil
+	}
+
+	testCases := []struct {
+		name    string
+		command remexCommand
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{name: "精确数量", command: ExactArgs(2, echo), args: []string{"a", "b"}, want: "a,b"},
+		{name: "精确数量不足", command: ExactArgs(2, echo), args: []string{"a"}, wantErr: "requires exactly 2 argument(s), got 1"},
+		{name: "精确数量过多", command: ExactArgs(2, echo), args: []string{"a", "b", "c"}, wantErr: "requires exactly 2 argument(s), got 3"},
+		{name: "空参数", command: ExactArgs(2, echo), args: []string{"a", " "}, wantErr: "argument 2 cannot be empty"},
+		{name: "最少数量", command: MinArgs(1, echo), args: []string{"a", "b", "c"}, want: "a,b,c"},
+		{name: "最少数量不足", command: MinArgs(1, echo), wantErr: "requires at least 1 argument(s), got 0"},
+		{name: "范围内", command: RangeArgs(1, 2, echo), args: []string{"a"}, want: "a"},
+		{name: "超出范围", command: RangeArgs(1, 2, echo), args: []string{"a", "b", "c"}, wantErr: "accepts at most 2 argument(s), got 3"},
+		{name: "无参数", command: ExactArgs(0, echo), want: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.command(context.Background(), nil, tc.args...)
+			if tc.wantErr != "" {
+				if !errors.Is(err, ErrInvalidArgs) || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("command(%q) error = %v, want %q", tc.args, err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Errorf("command(%q) = %q, %v, want %q", tc.args, got, err, tc.want)
+			}
+		})
+	}
+
+	t.Run("通过 ExecRemexCommand 调用", func(t *testing.T) {
+		defer ResetCommands()
+
+		server := newTestSSHServer(t)
+		client := server.dial(t)
+
+		if err := RegisterCommand("pair", ExactArgs(2, echo)); err != nil {
+			t.Fatalf("RegisterCommand() error = %v", err)
+		}
+		// 连续的空格会拆分出空参数
+		if _, err := ExecRemexCommand(context.Background(), client, "remex.pair a  b"); !errors.Is(err, ErrInvalidArgs) {
+			t.Errorf("ExecRemexCommand() error = %v, want %v", err, ErrInvalidArgs)
+		}
+		if output, err := ExecRemexCommand(context.Background(), client, "remex.pair a b"); err != nil || output != "a,b" {
+			t.Errorf("ExecRemexCommand() = %q, %v, want %q", output, err, "a,b")
+		}
+	})
+}
+
 // TestGetCommand 测试 GetCommand 函数
 func TestGetCommand(t *testing.T) {
 	testCases := []struct {
@@ -152,7 +306,25 @@ func TestListCommands(t *testing.T) {
 		"remex.upload",
 		"remex.download",
 		"remex.exec",
+		"remex.rsh",
 		"remex.mkdir",
+		"remex.touch",
+		"remex.exists",
+		"remex.existsAll",
+		"remex.sha256",
+		"remex.md5",
+		"remex.rm",
+		"remex.rmdir",
+		"remex.rename",
+		"remex.mv",
+		"remex.symlink",
+		"remex.readlink",
+		"remex.ls",
+		"remex.stat",
+		"remex.cat",
+		"remex.tail",
+		"remex.df",
+		"remex.du",
 	}
 
 	for _, expected := range expectedCommands {
@@ -200,6 +372,54 @@ func TestNewInterruptibleReader(t *testing.T) {
 	}
 }
 
+// TestNewInterruptibleWriter 测试 NewInterruptibleWriter 函数
+func TestNewInterruptibleWriter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var buf bytes.Buffer
+	interruptibleWriter := NewInterruptibleWriter(ctx, &buf)
+
+	// 测试正常写入
+	n, err := interruptibleWriter.Write([]byte("test"))
+	if err != nil {
+		t.Errorf("NewInterruptibleWriter() Write error = %v", err)
+	}
+	if n != 4 {
+		t.Errorf("NewInterruptibleWriter() Write bytes = %v, want %v", n, 4)
+	}
+
+	// 测试上下文取消时的写入
+	cancel()
+	if _, err = interruptibleWriter.Write([]byte("data")); err != context.Canceled {
+		t.Errorf("NewInterruptibleWriter() Write after cancel error = %v, want %v", err, context.Canceled)
+	}
+	if buf.String() != "test" {
+		t.Errorf("NewInterruptibleWriter() written content = %v, want %v", buf.String(), "test")
+	}
+}
+
+// TestNewCountingReader 测试计数读取器和写入器
+func TestNewCountingReader(t *testing.T) {
+	reader, read := NewCountingReader(strings.NewReader("test data"))
+	var buf bytes.Buffer
+	writer, written := NewCountingWriter(&buf)
+
+	// 与 newInterruptibleReader 组合使用
+	if _, err := io.Copy(writer, newInterruptibleReader(context.Background(), reader)); err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if got := atomic.LoadInt64(read); got != 9 {
+		t.Errorf("NewCountingReader() count = %d, want 9", got)
+	}
+	if got := atomic.LoadInt64(written); got != 9 {
+		t.Errorf("NewCountingWriter() count = %d, want 9", got)
+	}
+	if buf.String() != "test data" {
+		t.Errorf("written content = %q, want %q", buf.String(), "test data")
+	}
+}
+
 // TestSSHClient_ID 测试 SSHClient 的 ID 方法
 func TestSSHClient_ID(t *testing.T) {
 	client := &SSHClient{
@@ -257,3 +477,1434 @@ func TestSSHClient_RemoteAddr(t *testing.T) {
 		})
 	}
 }
+
+// TestRemoteScript 测试 remex.rsh 在远程主机执行脚本
+func TestRemoteScript(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	output, err := ExecRemexCommand(context.Background(), client, "remex.rsh x=remote; echo $x; echo done >&2")
+	if err != nil {
+		t.Fatalf("remex.rsh error = %v", err)
+	}
+	// 标准输出和标准错误是两个独立的流，顺序不固定
+	if lines := strings.Split(strings.TrimSpace(output), "\n"); !slices.Equal(slices.Sorted(slices.Values(lines)), []string{"done", "remote"}) {
+		t.Errorf("remex.rsh output = %q, want remote and done", output)
+	}
+	if executed := server.executed(); !slices.Equal(executed, []string{"sh -s"}) {
+		t.Errorf("server executed %v, want [sh -s]", executed)
+	}
+
+	if _, err := ExecRemexCommand(context.Background(), client, "remex.rsh exit 3"); err == nil {
+		t.Error("remex.rsh expected error for failing script")
+	}
+}
+
+// TestFileExists 测试 remex.exists 判断远程文件是否存在
+func TestFileExists(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "exists.txt")
+	if err := os.WriteFile(existing, []byte("data"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	testCases := []struct {
+		name        string
+		args        []string
+		expected    string
+		shouldError bool
+	}{
+		{name: "文件存在", args: []string{filepath.ToSlash(existing)}, expected: "true"},
+		{name: "目录存在", args: []string{filepath.ToSlash(dir)}, expected: "true"},
+		{name: "文件不存在", args: []string{filepath.ToSlash(filepath.Join(dir, "missing.txt"))}, expected: "false"},
+		{name: "缺少参数", args: nil, shouldError: true},
+		{name: "空路径", args: []string{" "}, shouldError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := fileExists(context.Background(), client, tc.args...)
+			if (err != nil) != tc.shouldError {
+				t.Fatalf("fileExists() error = %v, shouldError %v", err, tc.shouldError)
+			}
+			if output != tc.expected {
+				t.Errorf("fileExists() = %q, want %q", output, tc.expected)
+			}
+		})
+	}
+
+	t.Run("上下文已取消", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := fileExists(ctx, client, filepath.ToSlash(existing)); !errors.Is(err, context.Canceled) {
+			t.Errorf("fileExists() error = %v, want %v", err, context.Canceled)
+		}
+	})
+}
+
+// TestFilesExist 测试通过一个 SFTP 客户端批量检查路径是否存在
+func TestFilesExist(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	dir := t.TempDir()
+	existing := filepath.ToSlash(filepath.Join(dir, "exists.txt"))
+	missing := filepath.ToSlash(filepath.Join(dir, "missing.txt"))
+	if err := os.WriteFile(existing, []byte("data"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	output, err := filesExist(context.Background(), client, existing, filepath.ToSlash(dir), missing)
+	if err != nil {
+		t.Fatalf("filesExist() error = %v", err)
+	}
+	var got map[string]bool
+	if err := json.Unmarshal([]byte(output), &got); err != nil {
+		t.Fatalf("Unmarshal(%q) error = %v", output, err)
+	}
+	if want := map[string]bool{existing: true, filepath.ToSlash(dir): true, missing: false}; !maps.Equal(got, want) {
+		t.Errorf("filesExist() = %v, want %v", got, want)
+	}
+
+	for _, args := range [][]string{nil, {existing, " "}} {
+		if _, err := filesExist(context.Background(), client, args...); err == nil {
+			t.Errorf("filesExist(%q) error = nil", args)
+		}
+	}
+
+	t.Run("上下文已取消", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := filesExist(ctx, client, existing, missing); !errors.Is(err, context.Canceled) {
+			t.Errorf("filesExist() error = %v, want %v", err, context.Canceled)
+		}
+	})
+}
+
+// TestRemoveRemote 测试删除远程文件和目录
+func TestRemoveRemote(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	newTree := func(t *testing.T) string {
+		dir := t.TempDir()
+		for _, name := range []string{"a.txt", "sub/b.txt", "sub/deep/c.txt"} {
+			name = filepath.Join(dir, "tree", name)
+			if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				t.Fatalf("MkdirAll() error = %v", err)
+			}
+			if err := os.WriteFile(name, []byte("data"), 0644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+		}
+		return filepath.Join(dir, "tree")
+	}
+
+	testCases := []struct {
+		name        string
+		command     remexCommand
+		args        func(tree string) []string
+		expected    string
+		removed     bool
+		shouldError bool
+	}{
+		{
+			name:     "删除文件",
+			command:  removeRemotePath,
+			args:     func(tree string) []string { return []string{filepath.ToSlash(filepath.Join(tree, "a.txt"))} },
+			expected: "Removed 1 entries",
+		},
+		{
+			name:        "非空目录需要 -r",
+			command:     removeRemotePath,
+			args:        func(tree string) []string { return []string{filepath.ToSlash(tree)} },
+			shouldError: true,
+		},
+		{
+			name:     "rm -r 删除目录树",
+			command:  removeRemotePath,
+			args:     func(tree string) []string { return []string{"-r", filepath.ToSlash(tree)} },
+			expected: "Removed 6 entries",
+			removed:  true,
+		},
+		{
+			name:     "rmdir 删除目录树",
+			command:  removeRemoteTree,
+			args:     func(tree string) []string { return []string{filepath.ToSlash(tree)} },
+			expected: "Removed 6 entries",
+			removed:  true,
+		},
+		{
+			name:        "路径不存在",
+			command:     removeRemotePath,
+			args:        func(tree string) []string { return []string{filepath.ToSlash(filepath.Join(tree, "missing"))} },
+			shouldError: true,
+		},
+		{name: "缺少参数", command: removeRemotePath, args: func(string) []string { return nil }, shouldError: true},
+		{name: "空路径", command: removeRemotePath, args: func(string) []string { return []string{" "} }, shouldError: true},
+		{name: "拒绝删除根目录", command: removeRemoteTree, args: func(string) []string { return []string{"/"} }, shouldError: true},
+		{name: "拒绝删除当前目录", command: removeRemotePath, args: func(string) []string { return []string{"-r", "./"} }, shouldError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tree := newTree(t)
+
+			output, err := tc.command(context.Background(), client, tc.args(tree)...)
+			if (err != nil) != tc.shouldError {
+				t.Fatalf("remove error = %v, shouldError %v", err, tc.shouldError)
+			}
+			if !strings.HasPrefix(output, tc.expected) {
+				t.Errorf("remove output = %q, want prefix %q", output, tc.expected)
+			}
+			if _, err := os.Stat(tree); errors.Is(err, os.ErrNotExist) != tc.removed {
+				t.Errorf("tree removed = %v, want %v", err != nil, tc.removed)
+			}
+		})
+	}
+}
+
+// TestRenameRemotePath 测试重命名远程文件
+func TestRenameRemotePath(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "app.remex.tmp")
+	dst := filepath.Join(dir, "app")
+	for name, data := range map[string]string{src: "new", dst: "old"} {
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	testCases := []struct {
+		name        string
+		args        []string
+		shouldError bool
+	}{
+		{name: "缺少参数", args: []string{filepath.ToSlash(src)}, shouldError: true},
+		{name: "空路径", args: []string{filepath.ToSlash(src), " "}, shouldError: true},
+		{name: "源文件不存在", args: []string{filepath.ToSlash(filepath.Join(dir, "missing")), filepath.ToSlash(dst)}, shouldError: true},
+		{name: "替换目标文件", args: []string{filepath.ToSlash(src), filepath.ToSlash(dst)}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := renameRemotePath(context.Background(), client, tc.args...)
+			if (err != nil) != tc.shouldError {
+				t.Fatalf("renameRemotePath() error = %v, shouldError %v", err, tc.shouldError)
+			}
+			if !tc.shouldError && !strings.HasPrefix(output, "Rename completed") {
+				t.Errorf("renameRemotePath() output = %q", output)
+			}
+		})
+	}
+
+	if data, err := os.ReadFile(dst); err != nil || string(data) != "new" {
+		t.Errorf("ReadFile(%s) = %q, %v, want %q", dst, data, err, "new")
+	}
+	if _, err := os.Stat(src); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat(%s) error = %v, want not exist", src, err)
+	}
+}
+
+// TestTouchRemoteFile 测试创建空文件或更新文件时间
+func TestTouchRemoteFile(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	dir := t.TempDir()
+	remotePath := filepath.Join(dir, "locks", "deploy.lock")
+
+	output, err := touchRemoteFile(context.Background(), client, filepath.ToSlash(remotePath))
+	if err != nil {
+		t.Fatalf("touchRemoteFile() error = %v", err)
+	}
+	if !strings.HasPrefix(output, "File created") {
+		t.Errorf("touchRemoteFile() output = %q", output)
+	}
+	if info, err := os.Stat(remotePath); err != nil || info.Size() != 0 {
+		t.Fatalf("Stat(%s) = %v, %v, want empty file", remotePath, info, err)
+	}
+
+	// 已存在的文件只更新时间，不清空内容
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.WriteFile(remotePath, []byte("pid 42"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.Chtimes(remotePath, old, old); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+
+	output, err = touchRemoteFile(context.Background(), client, filepath.ToSlash(remotePath))
+	if err != nil {
+		t.Fatalf("touchRemoteFile() error = %v", err)
+	}
+	if !strings.HasPrefix(output, "File times updated") {
+		t.Errorf("touchRemoteFile() output = %q", output)
+	}
+	info, err := os.Stat(remotePath)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if !info.ModTime().After(old) {
+		t.Errorf("mtime = %v, want after %v", info.ModTime(), old)
+	}
+	if data, _ := os.ReadFile(remotePath); string(data) != "pid 42" {
+		t.Errorf("content = %q, want %q", data, "pid 42")
+	}
+
+	for _, args := range [][]string{nil, {" "}, {"a", "b"}} {
+		if _, err := touchRemoteFile(context.Background(), client, args...); err == nil {
+			t.Errorf("touchRemoteFile(%q) error = nil", args)
+		}
+	}
+}
+
+// TestRemoteSymlink 测试创建和读取远程符号链接
+func TestRemoteSymlink(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	dir := t.TempDir()
+	for _, release := range []string{"releases/1", "releases/2"} {
+		if err := os.MkdirAll(filepath.Join(dir, release), 0755); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+	}
+	current := filepath.ToSlash(filepath.Join(dir, "current"))
+
+	if _, err := createRemoteSymlink(context.Background(), client, "releases/1", current); err != nil {
+		t.Fatalf("createRemoteSymlink() error = %v", err)
+	}
+	if _, err := createRemoteSymlink(context.Background(), client, "releases/2", current); err == nil {
+		t.Error("createRemoteSymlink() over existing link without -f error = nil")
+	}
+
+	output, err := createRemoteSymlink(context.Background(), client, "-f", "releases/2", current)
+	if err != nil {
+		t.Fatalf("createRemoteSymlink(-f) error = %v", err)
+	}
+	if output != "Symlink replaced: "+current+" -> releases/2" {
+		t.Errorf("createRemoteSymlink(-f) output = %q", output)
+	}
+	if _, err := os.Lstat(current + AtomicUploadSuffix); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary link should be removed, stat error = %v", err)
+	}
+
+	target, err := readRemoteLink(context.Background(), client, current)
+	if err != nil {
+		t.Fatalf("readRemoteLink() error = %v", err)
+	}
+	if target != "releases/2" {
+		t.Errorf("readRemoteLink() = %q, want %q", target, "releases/2")
+	}
+	if got, _ := os.Readlink(filepath.FromSlash(current)); got != "releases/2" {
+		t.Errorf("os.Readlink() = %q, want %q", got, "releases/2")
+	}
+
+	t.Run("参数校验", func(t *testing.T) {
+		for _, args := range [][]string{nil, {"target"}, {" ", current}, {"target", " "}, {"-x", "target", current}} {
+			if _, err := createRemoteSymlink(context.Background(), client, args...); err == nil {
+				t.Errorf("createRemoteSymlink(%q) error = nil", args)
+			}
+		}
+		for _, args := range [][]string{nil, {" "}, {filepath.ToSlash(filepath.Join(dir, "releases"))}} {
+			if _, err := readRemoteLink(context.Background(), client, args...); err == nil {
+				t.Errorf("readRemoteLink(%q) error = nil", args)
+			}
+		}
+	})
+
+	t.Run("服务器不支持 posix-rename 时替换已有链接", func(t *testing.T) {
+		legacy := newTestSSHServer(t)
+		legacy.legacySFTP = true
+		client := legacy.dial(t)
+
+		link := filepath.ToSlash(filepath.Join(dir, "legacy"))
+		if _, err := createRemoteSymlink(context.Background(), client, "releases/1", link); err != nil {
+			t.Fatalf("createRemoteSymlink() error = %v", err)
+		}
+		if _, err := createRemoteSymlink(context.Background(), client, "-f", "releases/2", link); err != nil {
+			t.Fatalf("createRemoteSymlink(-f) error = %v", err)
+		}
+		if got, _ := os.Readlink(filepath.FromSlash(link)); got != "releases/2" {
+			t.Errorf("os.Readlink() = %q, want %q", got, "releases/2")
+		}
+		if _, err := os.Lstat(link + AtomicUploadSuffix); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("temporary link should be removed, stat error = %v", err)
+		}
+	})
+}
+
+// TestListRemoteDirectory 测试列出远程目录
+func TestListRemoteDirectory(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "a"), 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+
+	t.Run("表格输出", func(t *testing.T) {
+		output, err := listRemoteDirectory(context.Background(), client, filepath.ToSlash(dir))
+		if err != nil {
+			t.Fatalf("listRemoteDirectory() error = %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(output), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("listRemoteDirectory() = %q, want 2 lines", output)
+		}
+		if fields := strings.Fields(lines[0]); fields[len(fields)-1] != "a" || !strings.HasPrefix(fields[0], "d") {
+			t.Errorf("first line = %q, want directory a", lines[0])
+		}
+		if fields := strings.Fields(lines[1]); fields[len(fields)-1] != "b.txt" || fields[1] != "5" {
+			t.Errorf("second line = %q, want b.txt with size 5", lines[1])
+		}
+	})
+
+	t.Run("JSON 输出", func(t *testing.T) {
+		output, err := listRemoteDirectory(context.Background(), client, "-json", filepath.ToSlash(dir))
+		if err != nil {
+			t.Fatalf("listRemoteDirectory() error = %v", err)
+		}
+		var entries []RemoteFileInfo
+		if err := json.Unmarshal([]byte(output), &entries); err != nil {
+			t.Fatalf("json.Unmarshal() error = %v", err)
+		}
+		if len(entries) != 2 || entries[0].Name != "a" || !entries[0].IsDir || entries[1].Size != 5 || entries[1].Mode.Perm() != 0644 {
+			t.Errorf("listRemoteDirectory() entries = %+v", entries)
+		}
+	})
+
+	testCases := []struct {
+		name string
+		args []string
+	}{
+		{name: "缺少参数"},
+		{name: "空路径", args: []string{" "}},
+		{name: "目录不存在", args: []string{filepath.ToSlash(filepath.Join(dir, "missing"))}},
+		{name: "未知参数", args: []string{"-l", filepath.ToSlash(dir)}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := listRemoteDirectory(context.Background(), client, tc.args...); err == nil {
+				t.Error("listRemoteDirectory() error = nil, want error")
+			}
+		})
+	}
+
+	t.Run("上下文已取消", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := listRemoteDirectory(ctx, client, filepath.ToSlash(dir)); !errors.Is(err, context.Canceled) {
+			t.Errorf("listRemoteDirectory() error = %v, want %v", err, context.Canceled)
+		}
+	})
+}
+
+// TestStatRemotePath 测试获取远程文件元数据
+func TestStatRemotePath(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.conf")
+	if err := os.WriteFile(file, []byte("key=value"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	t.Run("文本输出", func(t *testing.T) {
+		output, err := statRemotePath(context.Background(), client, filepath.ToSlash(file))
+		if err != nil {
+			t.Fatalf("statRemotePath() error = %v", err)
+		}
+		for _, want := range []string{"name: app.conf\n", "size: 9\n", "mode: -rw-------\n", "is_dir: false\n"} {
+			if !strings.Contains(output, want) {
+				t.Errorf("statRemotePath() = %q, want it to contain %q", output, want)
+			}
+		}
+	})
+
+	t.Run("JSON 输出", func(t *testing.T) {
+		output, err := statRemotePath(context.Background(), client, "-json", filepath.ToSlash(dir))
+		if err != nil {
+			t.Fatalf("statRemotePath() error = %v", err)
+		}
+		var info RemoteFileInfo
+		if err := json.Unmarshal([]byte(output), &info); err != nil {
+			t.Fatalf("json.Unmarshal() error = %v", err)
+		}
+		if !info.IsDir || !info.Mode.IsDir() || info.ModTime.IsZero() {
+			t.Errorf("statRemotePath() info = %+v, want directory", info)
+		}
+	})
+
+	t.Run("路径不存在", func(t *testing.T) {
+		_, err := statRemotePath(context.Background(), client, filepath.ToSlash(filepath.Join(dir, "missing")))
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("statRemotePath() error = %v, want %v", err, os.ErrNotExist)
+		}
+	})
+
+	for name, args := range map[string][]string{"缺少参数": nil, "空路径": {" "}, "未知参数": {"-x", "a"}} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := statRemotePath(context.Background(), client, args...); err == nil {
+				t.Error("statRemotePath() error = nil, want error")
+			}
+		})
+	}
+}
+
+// TestTransfer_VerifyChecksum 测试上传下载后的校验和验证
+func TestTransfer_VerifyChecksum(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	dir := t.TempDir()
+	localPath := filepath.Join(dir, "local.txt")
+	remotePath := filepath.ToSlash(filepath.Join(dir, "remote", "file.txt"))
+	downloadPath := filepath.Join(dir, "download.txt")
+
+	data := []byte("checksum test data")
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	if _, err := uploadFile(context.Background(), client, "-verify", localPath, remotePath); err != nil {
+		t.Fatalf("uploadFile() error = %v", err)
+	}
+	if _, err := downloadFile(context.Background(), client, "-verify", remotePath, downloadPath); err != nil {
+		t.Fatalf("downloadFile() error = %v", err)
+	}
+	if got, _ := os.ReadFile(downloadPath); string(got) != string(data) {
+		t.Errorf("downloaded content = %q, want %q", got, data)
+	}
+
+	t.Run("SFTP 回退计算", func(t *testing.T) {
+		output, err := remoteChecksum(context.Background(), client, remotePath)
+		if err != nil {
+			t.Fatalf("remoteChecksum() error = %v", err)
+		}
+		if output != digest {
+			t.Errorf("remoteChecksum() = %q, want %q", output, digest)
+		}
+	})
+
+	t.Run("使用远程 sha256sum", func(t *testing.T) {
+		server.handler = func(ctx context.Context, command string, env map[string]string, stdin io.Reader, stdout, stderr io.Writer) uint32 {
+			fmt.Fprintf(stdout, "%s  %s\n", strings.Repeat("0", 64), remotePath)
+			return 0
+		}
+		defer func() { server.handler = runTestCommand }()
+
+		output, err := remoteChecksum(context.Background(), client, remotePath)
+		if err != nil {
+			t.Fatalf("remoteChecksum() error = %v", err)
+		}
+		if output != strings.Repeat("0", 64) {
+			t.Errorf("remoteChecksum() = %q, want remote sha256sum output", output)
+		}
+
+		_, err = uploadFile(context.Background(), client, "-verify", localPath, remotePath)
+		if !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("uploadFile() error = %v, want %v", err, ErrChecksumMismatch)
+		}
+		// 原子上传只删除临时文件，保留之前上传的文件
+		if got, _ := os.ReadFile(remotePath); string(got) != string(data) {
+			t.Errorf("remote content = %q, want previous upload %q", got, data)
+		}
+		if _, err := os.Stat(remotePath + AtomicUploadSuffix); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("temporary file should be removed after checksum mismatch, stat error = %v", err)
+		}
+
+		_, err = uploadFile(context.Background(), client, "-verify", "-direct", localPath, remotePath)
+		if !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("uploadFile() error = %v, want %v", err, ErrChecksumMismatch)
+		}
+		if _, err := os.Stat(remotePath); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("remote file should be removed after checksum mismatch, stat error = %v", err)
+		}
+	})
+}
+
+// TestRemoteMD5 测试计算远程文件的 MD5
+func TestRemoteMD5(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	remotePath := filepath.ToSlash(filepath.Join(t.TempDir(), "artifact.bin"))
+	if err := os.WriteFile(remotePath, []byte("artifact data"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	sum := md5.Sum([]byte("artifact data"))
+
+	t.Run("SFTP 回退计算", func(t *testing.T) {
+		output, err := remoteMD5(context.Background(), client, remotePath)
+		if err != nil {
+			t.Fatalf("remoteMD5() error = %v", err)
+		}
+		if want := hex.EncodeToString(sum[:]); output != want {
+			t.Errorf("remoteMD5() = %q, want %q", output, want)
+		}
+	})
+
+	t.Run("使用远程 md5sum", func(t *testing.T) {
+		var executed string
+		server.handler = func(ctx context.Context, command string, env map[string]string, stdin io.Reader, stdout, stderr io.Writer) uint32 {
+			executed = command
+			fmt.Fprintf(stdout, "%s  %s\n", strings.Repeat("A", 32), remotePath)
+			return 0
+		}
+		defer func() { server.handler = runTestCommand }()
+
+		output, err := remoteMD5(context.Background(), client, remotePath)
+		if err != nil {
+			t.Fatalf("remoteMD5() error = %v", err)
+		}
+		if output != strings.Repeat("a", 32) {
+			t.Errorf("remoteMD5() = %q, want remote md5sum output", output)
+		}
+		if !strings.HasPrefix(executed, "md5sum -- ") {
+			t.Errorf("executed command = %q, want md5sum", executed)
+		}
+	})
+
+	for _, args := range [][]string{nil, {" "}, {"a", "b"}} {
+		if _, err := remoteMD5(context.Background(), client, args...); err == nil {
+			t.Errorf("remoteMD5(%q) error = nil", args)
+		}
+	}
+}
+
+// TestUploadFile_Atomic 测试上传通过临时文件原子替换目标文件
+func TestUploadFile_Atomic(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	dir := t.TempDir()
+	localPath := filepath.Join(dir, "local.txt")
+	remotePath := filepath.Join(dir, "remote", "app")
+	if err := os.WriteFile(localPath, []byte("new release"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	for _, args := range [][]string{{localPath, remotePath}, {"-direct", localPath, remotePath}} {
+		if err := os.MkdirAll(filepath.Dir(remotePath), 0755); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+		if err := os.WriteFile(remotePath, []byte("old"), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+
+		if _, err := uploadFile(context.Background(), client, args...); err != nil {
+			t.Fatalf("uploadFile(%q) error = %v", args, err)
+		}
+		if got, _ := os.ReadFile(remotePath); string(got) != "new release" {
+			t.Errorf("uploadFile(%q) remote content = %q, want %q", args, got, "new release")
+		}
+		if _, err := os.Stat(remotePath + AtomicUploadSuffix); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("uploadFile(%q) left temporary file, stat error = %v", args, err)
+		}
+	}
+
+	t.Run("上传失败时保留原文件", func(t *testing.T) {
+		if err := os.WriteFile(remotePath, []byte("old"), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := UploadMemoryFileCommand([]byte("partial"), remotePath)(ctx, client); err == nil {
+			t.Fatal("UploadMemoryFileCommand() error = nil, want context error")
+		}
+		if got, _ := os.ReadFile(remotePath); string(got) != "old" {
+			t.Errorf("remote content = %q, want %q", got, "old")
+		}
+		if _, err := os.Stat(remotePath + AtomicUploadSuffix); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("temporary file should be removed, stat error = %v", err)
+		}
+	})
+
+	t.Run("保留已有文件的权限", func(t *testing.T) {
+		if err := os.WriteFile(remotePath, []byte("#!/bin/sh\n"), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		// 显式设置，不受 umask 影响
+		if err := os.Chmod(remotePath, 0755); err != nil {
+			t.Fatalf("Chmod() error = %v", err)
+		}
+
+		if _, err := uploadFile(context.Background(), client, localPath, remotePath); err != nil {
+			t.Fatalf("uploadFile() error = %v", err)
+		}
+		info, err := os.Stat(remotePath)
+		if err != nil {
+			t.Fatalf("Stat() error = %v", err)
+		}
+		if got := info.Mode().Perm(); got != 0755 {
+			t.Errorf("remote mode = %v, want %v", got, os.FileMode(0755))
+		}
+	})
+
+	t.Run("服务器不支持 posix-rename 时覆盖已有文件", func(t *testing.T) {
+		server := newTestSSHServer(t)
+		server.legacySFTP = true
+		client := server.dial(t)
+
+		for _, content := range []string{"release 1", "release 2"} {
+			if _, err := UploadMemoryFileCommand([]byte(content), remotePath)(context.Background(), client); err != nil {
+				t.Fatalf("UploadMemoryFileCommand(%q) error = %v", content, err)
+			}
+			if got, _ := os.ReadFile(remotePath); string(got) != content {
+				t.Errorf("remote content = %q, want %q", got, content)
+			}
+		}
+		if _, err := os.Stat(remotePath + AtomicUploadSuffix); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("temporary file should be removed, stat error = %v", err)
+		}
+	})
+}
+
+// TestDownloadFile_Resume 测试断点续传下载
+func TestDownloadFile_Resume(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	dir := t.TempDir()
+	remotePath := filepath.Join(dir, "remote.bin")
+	localPath := filepath.Join(dir, "local.bin")
+	if err := os.WriteFile(remotePath, []byte("0123456789"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		local    string
+		expected string
+	}{
+		// 已下载部分使用不同内容，以确认只传输了剩余数据
+		{name: "续传剩余部分", local: "abcd", expected: "abcd456789"},
+		{name: "本地文件已完整", local: "0123456789", expected: "0123456789"},
+		{name: "本地文件更大时重新下载", local: "0123456789extra", expected: "0123456789"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := os.WriteFile(localPath, []byte(tc.local), 0644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+
+			if _, err := downloadFile(context.Background(), client, "-resume", filepath.ToSlash(remotePath), localPath); err != nil {
+				t.Fatalf("downloadFile() error = %v", err)
+			}
+
+			got, _ := os.ReadFile(localPath)
+			if string(got) != tc.expected {
+				t.Errorf("local content = %q, want %q", got, tc.expected)
+			}
+		})
+	}
+
+	t.Run("续传并校验", func(t *testing.T) {
+		if err := os.WriteFile(localPath, []byte("0123"), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		if _, err := downloadFile(context.Background(), client, "-resume", "-verify", filepath.ToSlash(remotePath), localPath); err != nil {
+			t.Fatalf("downloadFile() error = %v", err)
+		}
+	})
+}
+
+// TestTransfer_Transferred 测试传输过程中统计已传输的字节数
+func TestTransfer_Transferred(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	dir := t.TempDir()
+	remotePath := filepath.ToSlash(filepath.Join(dir, "remote.bin"))
+	localPath := filepath.Join(dir, "local.bin")
+
+	var transferred int64
+	opts := TransferOptions{Transferred: &transferred, BufferSize: 4}
+
+	if _, err := uploadMemoryFile(context.Background(), client, strings.NewReader("0123456789"), remotePath, opts); err != nil {
+		t.Fatalf("uploadMemoryFile() error = %v", err)
+	}
+	if got := atomic.LoadInt64(&transferred); got != 10 {
+		t.Errorf("Transferred after upload = %d, want 10", got)
+	}
+
+	// 续传只统计本次传输的字节
+	if err := os.WriteFile(localPath, []byte("0123"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := downloadFileWithOptions(context.Background(), client, opts, "-resume", remotePath, localPath); err != nil {
+		t.Fatalf("downloadFileWithOptions() error = %v", err)
+	}
+	if got := atomic.LoadInt64(&transferred); got != 16 {
+		t.Errorf("Transferred after download = %d, want 16", got)
+	}
+}
+
+// TestUploadFile_CheckSpace 测试上传前检查远程磁盘空间
+func TestUploadFile_CheckSpace(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	dir := t.TempDir()
+	localPath := filepath.Join(dir, "local.txt")
+	remotePath := filepath.ToSlash(filepath.Join(dir, "remote", "file.txt"))
+	if err := os.WriteFile(localPath, []byte("small file"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	if _, err := uploadFile(context.Background(), client, "-check-space", localPath, remotePath); err != nil {
+		t.Fatalf("uploadFile() error = %v", err)
+	}
+
+	sftpClient, release, err := newSFTPClient(context.Background(), client)
+	if err != nil {
+		t.Fatalf("newSFTPClient() error = %v", err)
+	}
+	defer release()
+
+	err = checkRemoteSpace(context.Background(), client, sftpClient, filepath.ToSlash(dir), math.MaxInt64)
+	if !errors.Is(err, ErrInsufficientSpace) {
+		t.Errorf("checkRemoteSpace() error = %v, want %v", err, ErrInsufficientSpace)
+	}
+
+	t.Run("上传大小", func(t *testing.T) {
+		file, err := os.Open(localPath)
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		defer file.Close()
+		file.Seek(6, io.SeekStart)
+
+		for _, tc := range []struct {
+			reader io.Reader
+			size   int64
+		}{
+			{bytes.NewReader([]byte("data")), 4},
+			{strings.NewReader("data"), 4},
+			{file, 4},
+			{io.MultiReader(strings.NewReader("data")), -1},
+		} {
+			if got := uploadSize(tc.reader); got != tc.size {
+				t.Errorf("uploadSize(%T) = %d, want %d", tc.reader, got, tc.size)
+			}
+		}
+	})
+}
+
+// TestTransfer_PreserveTimes 测试传输时保留修改时间
+func TestTransfer_PreserveTimes(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	dir := t.TempDir()
+	localPath := filepath.Join(dir, "local.log")
+	remotePath := filepath.ToSlash(filepath.Join(dir, "remote", "app.log"))
+	downloadPath := filepath.Join(dir, "download.log")
+	if err := os.WriteFile(localPath, []byte("log line"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(localPath, mtime, mtime); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+
+	assertModTime := func(t *testing.T, path string, want time.Time) {
+		t.Helper()
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat() error = %v", err)
+		}
+		if !info.ModTime().Equal(want) {
+			t.Errorf("%s mtime = %v, want %v", filepath.Base(path), info.ModTime(), want)
+		}
+	}
+
+	for _, args := range [][]string{{"-preserve"}, {"-preserve", "-direct", "-verify"}} {
+		os.Remove(remotePath)
+		if _, err := uploadFile(context.Background(), client, append(args, localPath, remotePath)...); err != nil {
+			t.Fatalf("uploadFile(%q) error = %v", args, err)
+		}
+		assertModTime(t, remotePath, mtime)
+	}
+
+	if _, err := downloadFile(context.Background(), client, "-preserve", remotePath, downloadPath); err != nil {
+		t.Fatalf("downloadFile() error = %v", err)
+	}
+	assertModTime(t, downloadPath, mtime)
+
+	t.Run("默认不保留", func(t *testing.T) {
+		if _, err := downloadFile(context.Background(), client, remotePath, downloadPath); err != nil {
+			t.Fatalf("downloadFile() error = %v", err)
+		}
+		if info, _ := os.Stat(downloadPath); info.ModTime().Equal(mtime) {
+			t.Error("downloaded file kept the remote mtime without -preserve")
+		}
+	})
+}
+
+// TestCatRemoteFile 测试读取远程文件内容
+func TestCatRemoteFile(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	dir := t.TempDir()
+	remotePath := filepath.ToSlash(filepath.Join(dir, "status"))
+	if err := os.WriteFile(remotePath, []byte("ready\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	output, err := catRemoteFile(context.Background(), client, remotePath)
+	if err != nil {
+		t.Fatalf("catRemoteFile() error = %v", err)
+	}
+	if output != "ready\n" {
+		t.Errorf("catRemoteFile() = %q, want %q", output, "ready\n")
+	}
+
+	if _, err := catRemoteFile(context.Background(), client, "-max-bytes", "6", remotePath); err != nil {
+		t.Errorf("catRemoteFile(-max-bytes 6) error = %v", err)
+	}
+	if _, err := catRemoteFile(context.Background(), client, "-max-bytes", "5", remotePath); err == nil || !strings.Contains(err.Error(), "larger than 5 bytes") {
+		t.Errorf("catRemoteFile(-max-bytes 5) error = %v, want size error", err)
+	}
+
+	_, err = catRemoteFile(context.Background(), client, filepath.ToSlash(filepath.Join(dir, "missing")))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("catRemoteFile() error = %v, want %v", err, os.ErrNotExist)
+	}
+
+	for _, args := range [][]string{nil, {" "}, {"a", "b"}, {"-max-bytes", "x", remotePath}} {
+		if _, err := catRemoteFile(context.Background(), client, args...); err == nil {
+			t.Errorf("catRemoteFile(%q) error = nil", args)
+		}
+	}
+
+	t.Run("上下文已取消", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := catRemoteFile(ctx, client, remotePath); !errors.Is(err, context.Canceled) {
+			t.Errorf("catRemoteFile() error = %v, want %v", err, context.Canceled)
+		}
+	})
+}
+
+// TestTailRemoteFile 测试读取远程文件的最后几行
+func TestTailRemoteFile(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	dir := t.TempDir()
+	logPath := filepath.ToSlash(filepath.Join(dir, "app.log"))
+
+	// 超过一个读取块，覆盖跨块查找换行
+	var b strings.Builder
+	for i := 1; i <= 10000; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	if err := os.WriteFile(logPath, []byte(b.String()), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		content  string
+		lines    string
+		expected string
+	}{
+		{name: "最后三行", lines: "3", expected: "line 9998\nline 9999\nline 10000\n"},
+		{name: "零行", lines: "0", expected: ""},
+		{name: "末行无换行", content: "a\nb\nc", lines: "2", expected: "b\nc"},
+		{name: "行数超过文件", content: "a\nb\n", lines: "5", expected: "a\nb\n"},
+		{name: "空文件", content: "", lines: "5", expected: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := logPath
+			if tc.name != "最后三行" && tc.name != "零行" {
+				path = filepath.ToSlash(filepath.Join(t.TempDir(), "small.log"))
+				if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+					t.Fatalf("WriteFile() error = %v", err)
+				}
+			}
+
+			output, err := tailRemoteFile(context.Background(), client, path, tc.lines)
+			if err != nil {
+				t.Fatalf("tailRemoteFile() error = %v", err)
+			}
+			if output != tc.expected {
+				t.Errorf("tailRemoteFile() = %q, want %q", output, tc.expected)
+			}
+		})
+	}
+
+	t.Run("参数校验", func(t *testing.T) {
+		for _, args := range [][]string{nil, {logPath}, {" ", "3"}, {logPath, "-1"}, {logPath, "x"}} {
+			if _, err := tailRemoteFile(context.Background(), client, args...); err == nil {
+				t.Errorf("tailRemoteFile(%q) error = nil", args)
+			}
+		}
+		if _, err := tailRemoteFile(context.Background(), client, filepath.ToSlash(filepath.Join(dir, "missing")), "3"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("tailRemoteFile() error = %v, want %v", err, os.ErrNotExist)
+		}
+	})
+
+	t.Run("持续输出新行", func(t *testing.T) {
+		defer func(interval time.Duration) { tailFollowInterval = interval }(tailFollowInterval)
+		tailFollowInterval = 10 * time.Millisecond
+
+		path := filepath.ToSlash(filepath.Join(t.TempDir(), "follow.log"))
+		if err := os.WriteFile(path, []byte("old 1\nold 2\npart"), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+
+		lines := make(chan string, 10)
+		ctx, cancel := context.WithCancel(WithLineHandler(context.Background(), func(stream Stream, line string) {
+			lines <- line
+		}))
+		defer cancel()
+
+		done := make(chan string)
+		go func() {
+			output, err := tailRemoteFile(ctx, client, "-f", path, "2")
+			if err != nil {
+				t.Errorf("tailRemoteFile(-f) error = %v", err)
+			}
+			done <- output
+		}()
+
+		// 等待首次读取完成后再追加
+		select {
+		case got := <-lines:
+			if got != "old 2" {
+				t.Errorf("first line = %q, want %q", got, "old 2")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("existing lines were not streamed")
+		}
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatalf("OpenFile() error = %v", err)
+		}
+		file.WriteString("ial\nnew\n")
+		file.Close()
+
+		for _, want := range []string{"partial", "new"} {
+			select {
+			case got := <-lines:
+				if got != want {
+					t.Errorf("line = %q, want %q", got, want)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("line %q was not streamed", want)
+			}
+		}
+
+		cancel()
+		if output := <-done; output != "" {
+			t.Errorf("tailRemoteFile(-f) = %q, want the lines only streamed", output)
+		}
+	})
+
+	t.Run("使用远程 tail", func(t *testing.T) {
+		var executed string
+		server.handler = func(ctx context.Context, command string, env map[string]string, stdin io.Reader, stdout, stderr io.Writer) uint32 {
+			executed = command
+			fmt.Fprint(stdout, "line 10000\n")
+			return 0
+		}
+		defer func() { server.handler = runTestCommand }()
+
+		output, err := tailCommand(context.Background(), client, logPath, 1, false)
+		if err != nil {
+			t.Fatalf("tailCommand() error = %v", err)
+		}
+		if output != "line 10000\n" {
+			t.Errorf("tailCommand() = %q", output)
+		}
+		if want := "tail -n 1 -- " + logPath; executed != want {
+			t.Errorf("executed command = %q, want %q", executed, want)
+		}
+
+		server.handler = func(ctx context.Context, command string, env map[string]string, stdin io.Reader, stdout, stderr io.Writer) uint32 {
+			fmt.Fprint(stdout, "line 10000\n")
+			<-ctx.Done()
+			return 0
+		}
+		var (
+			lines       = make(chan string, 1)
+			ctx, cancel = context.WithCancel(context.Background())
+		)
+		defer cancel()
+		ctx = WithLineHandler(ctx, func(stream Stream, line string) {
+			lines <- line
+			cancel()
+		})
+
+		output, err = tailCommand(ctx, client, logPath, 1, true)
+		if err != nil || output != "" {
+			t.Errorf("tailCommand(follow) = %q, %v, want the lines only streamed", output, err)
+		}
+		if got := <-lines; got != "line 10000" {
+			t.Errorf("streamed line = %q, want %q", got, "line 10000")
+		}
+	})
+}
+
+// TestDiskFree 测试查询远程文件系统用量
+func TestDiskFree(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+	dir := filepath.ToSlash(t.TempDir())
+
+	output, err := diskFree(context.Background(), client, "-json", dir)
+	if err != nil {
+		t.Fatalf("diskFree() error = %v", err)
+	}
+	var usage DiskUsage
+	if err := json.Unmarshal([]byte(output), &usage); err != nil {
+		t.Fatalf("Unmarshal(%q) error = %v", output, err)
+	}
+	if usage.Path != dir || usage.Total == 0 || usage.Used > usage.Total || usage.Free > usage.Total {
+		t.Errorf("diskFree() = %+v", usage)
+	}
+
+	output, err = diskFree(context.Background(), client, dir)
+	if err != nil {
+		t.Fatalf("diskFree() error = %v", err)
+	}
+	if !strings.HasPrefix(output, "path: "+dir+"\ntotal: ") {
+		t.Errorf("diskFree() output = %q", output)
+	}
+
+	for _, args := range [][]string{nil, {" "}, {"-x", dir}} {
+		if _, err := diskFree(context.Background(), client, args...); err == nil {
+			t.Errorf("diskFree(%q) error = nil", args)
+		}
+	}
+}
+
+// TestDiskUsage 测试统计远程目录树大小
+func TestDiskUsage(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	dir := t.TempDir()
+	for name, data := range map[string]string{"a.txt": "hello", "sub/b.txt": "world!"} {
+		name = filepath.Join(dir, "tree", name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	tree := filepath.ToSlash(filepath.Join(dir, "tree"))
+
+	var want int64
+	filepath.Walk(filepath.Join(dir, "tree"), func(path string, info os.FileInfo, err error) error {
+		want += info.Size()
+		return nil
+	})
+
+	t.Run("SFTP 回退计算", func(t *testing.T) {
+		output, err := diskUsage(context.Background(), client, "-json", tree)
+		if err != nil {
+			t.Fatalf("diskUsage() error = %v", err)
+		}
+		var size PathSize
+		if err := json.Unmarshal([]byte(output), &size); err != nil {
+			t.Fatalf("Unmarshal(%q) error = %v", output, err)
+		}
+		if size != (PathSize{Path: tree, Size: want}) {
+			t.Errorf("diskUsage() = %+v, want size %d", size, want)
+		}
+	})
+
+	t.Run("使用远程 du", func(t *testing.T) {
+		server.handler = func(ctx context.Context, command string, env map[string]string, stdin io.Reader, stdout, stderr io.Writer) uint32 {
+			fmt.Fprintf(stdout, "123456\t%s\n", tree)
+			return 0
+		}
+		defer func() { server.handler = runTestCommand }()
+
+		output, err := diskUsage(context.Background(), client, tree)
+		if err != nil {
+			t.Fatalf("diskUsage() error = %v", err)
+		}
+		if want := "path: " + tree + "\nsize: 123456\n"; output != want {
+			t.Errorf("diskUsage() = %q, want %q", output, want)
+		}
+	})
+
+	for _, args := range [][]string{nil, {" "}, {filepath.ToSlash(filepath.Join(dir, "missing"))}} {
+		if _, err := diskUsage(context.Background(), client, args...); err == nil {
+			t.Errorf("diskUsage(%q) error = nil", args)
+		}
+	}
+}
+
+// TestParseDF 测试解析 df -Pk 的输出
+func TestParseDF(t *testing.T) {
+	output := "Filesystem     1024-blocks     Used Available Capacity Mounted on\n/dev/sda1        102400000 46080000  51200000      48% /\n"
+	want := DiskUsage{Total: 102400000 * 1024, Used: 46080000 * 1024, Free: 51200000 * 1024}
+	if got, err := parseDF(output); err != nil || got != want {
+		t.Errorf("parseDF() = %+v, %v, want %+v", got, err, want)
+	}
+
+	for _, output := range []string{"", "Filesystem 1024-blocks Used Available Capacity Mounted on", "header\n/dev/sda1 100 50 n/a 50% /"} {
+		if _, err := parseDF(output); err == nil {
+			t.Errorf("parseDF(%q) error = nil", output)
+		}
+	}
+}
+
+// TestParseTransferFlags 测试传输命令的参数解析
+func TestParseTransferFlags(t *testing.T) {
+	testCases := []struct {
+		name         string
+		defaults     TransferOptions
+		args         []string
+		expected     TransferOptions
+		expectedArgs []string
+		sftpOptions  int
+		shouldError  bool
+	}{
+		{
+			name:         "无参数",
+			args:         []string{"a", "b"},
+			expectedArgs: []string{"a", "b"},
+		},
+		{
+			name:         "全部参数",
+			args:         []string{"-verify", "-resume", "-direct", "-check-space", "-preserve", "-buffer-size", "1048576", "a", "b"},
+			expected:     TransferOptions{VerifyChecksum: true, Resume: true, DirectWrite: true, CheckSpace: true, PreserveTimes: true, BufferSize: 1 << 20},
+			expectedArgs: []string{"a", "b"},
+		},
+		{
+			name:         "覆盖默认值",
+			defaults:     TransferOptions{VerifyChecksum: true, BufferSize: 1024},
+			args:         []string{"-verify=false", "a", "b"},
+			expected:     TransferOptions{BufferSize: 1024},
+			expectedArgs: []string{"a", "b"},
+		},
+		{
+			name:         "SFTP 参数",
+			args:         []string{"-concurrent", "-max-packet", "65536", "-max-requests", "128", "a", "b"},
+			expectedArgs: []string{"a", "b"},
+			sftpOptions:  4,
+		},
+		{
+			name:        "未知参数",
+			args:        []string{"-unknown", "a", "b"},
+			shouldError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts, args, err := parseTransferFlags("upload", tc.defaults, tc.args)
+			if (err != nil) != tc.shouldError {
+				t.Fatalf("parseTransferFlags() error = %v, shouldError %v", err, tc.shouldError)
+			}
+			if tc.shouldError {
+				return
+			}
+			if len(opts.SFTPOptions) != tc.sftpOptions {
+				t.Errorf("parseTransferFlags() SFTPOptions = %d, want %d", len(opts.SFTPOptions), tc.sftpOptions)
+			}
+			opts.SFTPOptions = nil
+			if !reflect.DeepEqual(opts, tc.expected) {
+				t.Errorf("parseTransferFlags() opts = %+v, want %+v", opts, tc.expected)
+			}
+			if !slices.Equal(args, tc.expectedArgs) {
+				t.Errorf("parseTransferFlags() args = %v, want %v", args, tc.expectedArgs)
+			}
+		})
+	}
+}
+
+// TestUploadFile_BufferSize 测试使用自定义缓冲区大小上传
+func TestUploadFile_BufferSize(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	dir := t.TempDir()
+	localPath := filepath.Join(dir, "local.txt")
+	remotePath := filepath.Join(dir, "remote.txt")
+	data := strings.Repeat("buffered upload ", 100)
+	if err := os.WriteFile(localPath, []byte(data), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	if _, err := uploadFile(context.Background(), client, "-buffer-size", "7", localPath, filepath.ToSlash(remotePath)); err != nil {
+		t.Fatalf("uploadFile() error = %v", err)
+	}
+	if got, _ := os.ReadFile(remotePath); string(got) != data {
+		t.Errorf("remote content length = %d, want %d", len(got), len(data))
+	}
+
+	t.Run("并发 SFTP 传输", func(t *testing.T) {
+		download := DownloadCommand(TransferOptions{BufferSize: 256})
+		downloadPath := filepath.Join(dir, "download.txt")
+
+		if _, err := download(context.Background(), client, "-concurrent", "-max-packet", "64", filepath.ToSlash(remotePath), downloadPath); err != nil {
+			t.Fatalf("download error = %v", err)
+		}
+		if got, _ := os.ReadFile(downloadPath); string(got) != data {
+			t.Errorf("downloaded content length = %d, want %d", len(got), len(data))
+		}
+	})
+}
+
+// TestSSHClient_SFTPClient 测试同一连接上的命令复用 SFTP 客户端
+func TestSSHClient_SFTPClient(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := &SSHClient{id: "host1", config: server.sshConfig(), Client: server.dial(t)}
+
+	dir := filepath.ToSlash(t.TempDir())
+	commands := []string{
+		"remex.mkdir " + dir + "/app",
+		"remex.exists " + dir + "/app",
+		"remex.sha256 " + dir + "/missing",
+	}
+	for _, command := range commands[:2] {
+		if _, err := client.ExecuteCommand(context.Background(), command); err != nil {
+			t.Fatalf("ExecuteCommand(%q) error = %v", command, err)
+		}
+	}
+	client.ExecuteCommand(context.Background(), commands[2])
+
+	if got := server.sftpSessions(); got != 1 {
+		t.Errorf("sftp sessions = %d, want 1", got)
+	}
+
+	// SFTP 会话结束后应重新创建
+	sftpClient, err := client.SFTPClient()
+	if err != nil {
+		t.Fatalf("SFTPClient() error = %v", err)
+	}
+	sftpClient.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		next, err := client.SFTPClient()
+		if err != nil {
+			t.Fatalf("SFTPClient() error = %v", err)
+		}
+		if next != sftpClient {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("SFTPClient() keeps returning the closed client")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+// TestSSHClient_Underlying 测试获取底层 SSH 客户端
+func TestSSHClient_Underlying(t *testing.T) {
+	server := newTestSSHServer(t)
+	conn := server.dial(t)
+	client := &SSHClient{id: "host1", config: server.sshConfig(), Client: conn}
+
+	underlying, err := client.Underlying()
+	if err != nil || underlying != conn {
+		t.Fatalf("Underlying() = %p, %v, want %p", underlying, err, conn)
+	}
+
+	client.deadErr = errors.New("keepalive timeout")
+	if _, err := client.Underlying(); err == nil || !strings.Contains(err.Error(), "keepalive timeout") {
+		t.Errorf("Underlying() of a dead client error = %v", err)
+	}
+	client.deadErr = nil
+
+	client.Close()
+	if _, err := client.Underlying(); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("Underlying() after Close error = %v, want %v", err, ErrClientClosed)
+	}
+
+	if _, err := (&SSHClient{}).Underlying(); !errors.Is(err, ErrClientNil) {
+		t.Errorf("Underlying() of an unconnected client error = %v, want %v", err, ErrClientNil)
+	}
+}
+
+// TestSSHClient_Ping 测试通过 keepalive 请求探测连接
+func TestSSHClient_Ping(t *testing.T) {
+	server := newTestSSHServer(t)
+	conn, err := server.sshConfig().Connect()
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	client := &SSHClient{id: "host1", config: server.sshConfig(), Client: conn}
+
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+
+	conn.Close()
+	if err := client.Ping(context.Background()); err == nil {
+		t.Error("Ping() error = nil after the connection was closed")
+	}
+
+	if err := (&SSHClient{}).Ping(context.Background()); err == nil {
+		t.Error("Ping() error = nil for an unconnected client")
+	}
+}
+
+// TestSSHClient_KeepAlive 测试 keepalive 失败后标记连接失效
+func TestSSHClient_KeepAlive(t *testing.T) {
+	server := newTestSSHServer(t)
+	config := server.sshConfig()
+	config.KeepAliveInterval = 10 * time.Millisecond
+
+	remoteClient, err := NewSSHClient("host1", config)
+	if err != nil {
+		t.Fatalf("NewSSHClient() error = %v", err)
+	}
+	client := remoteClient.(*SSHClient)
+
+	time.Sleep(50 * time.Millisecond)
+	if err := client.Err(); err != nil {
+		t.Fatalf("Err() = %v while the connection is alive", err)
+	}
+
+	// 模拟连接中断
+	client.Client.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for client.Err() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("client is not marked dead after the connection dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := client.ExecuteCommand(context.Background(), "true"); err == nil || !strings.Contains(err.Error(), "dead") {
+		t.Errorf("ExecuteCommand() error = %v, want dead connection error", err)
+	}
+
+	client.Close()
+	client.Close()
+}
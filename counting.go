@@ -0,0 +1,42 @@
+package remex
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// NewCountingReader returns a reader counting the bytes read from r, the count may
+// be loaded with atomic.LoadInt64 while another goroutine reads, e.g. to draw a
+// progress bar for UploadMemoryFile
+func NewCountingReader(r io.Reader) (io.Reader, *int64) {
+	n := new(int64)
+	return &countingReader{r: r, n: n}, n
+}
+
+// NewCountingWriter returns a writer counting the bytes written to w, see NewCountingReader
+func NewCountingWriter(w io.Writer) (io.Writer, *int64) {
+	n := new(int64)
+	return &countingWriter{w: w, n: n}, n
+}
+
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	atomic.AddInt64(w.n, int64(n))
+	return n, err
+}
@@ -0,0 +1,107 @@
+package remex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"slices"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrHostDisconnected is wrapped by the error of a host whose connection died
+// during an execution, see WithSkipDisconnected
+var ErrHostDisconnected = errors.New("host disconnected")
+
+// isDisconnectError reports whether err means the connection to the host was
+// lost, as opposed to a command failing or timing out on a live connection
+func isDisconnectError(client RemoteClient, err error) bool {
+	if err == nil {
+		return false
+	}
+	if conn, ok := client.(interface{ Err() error }); ok && conn.Err() != nil {
+		return true
+	}
+
+	var (
+		exitErr *ssh.ExitError
+		netErr  net.Error
+	)
+	if errors.As(err, &exitErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, ErrClientClosed) || errors.As(err, &netErr)
+}
+
+// markDisconnected closes the dead client of a host and moves the host to the
+// disconnected set, it is skipped until it reconnects
+func (r *Remex) markDisconnected(client RemoteClient, err error) {
+	id := client.ID()
+
+	r.mutex.Lock()
+	if current, ok := r.clients[id]; ok && current == client {
+		delete(r.clients, id)
+	}
+	if _, exists := r.configs[id]; exists {
+		r.disconnected[id] = err
+	}
+	r.mutex.Unlock()
+
+	client.Close()
+
+	r.logger.Warn("SSH connection lost, skipping host", "id", id, "remote", client.RemoteAddr(), "error", err)
+	r.notifyHandlers(ExecResult{ID: id, Stage: StageDisconnected, RemoteAddr: client.RemoteAddr(), Error: err})
+}
+
+// keepDisconnected removes the hosts of the disconnected set failing to
+// reconnect from connectErrs, so they are skipped instead of failing the batch.
+// It returns the errors of the removed hosts wrapping ErrHostDisconnected.
+func (r *Remex) keepDisconnected(connectErrs map[string]error) map[string]error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	skipped := make(map[string]error)
+	for id, err := range connectErrs {
+		if _, disconnected := r.disconnected[id]; disconnected {
+			r.disconnected[id] = err
+			skipped[id] = fmt.Errorf("%w: %w", ErrHostDisconnected, err)
+			delete(connectErrs, id)
+		}
+	}
+	return skipped
+}
+
+// DisconnectedHosts returns the sorted IDs of the hosts skipped because their
+// connection died during an execution, see WithSkipDisconnected
+func (r *Remex) DisconnectedHosts() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return slices.Sorted(maps.Keys(r.disconnected))
+}
+
+// checkDisconnected moves the host of client to the disconnected set when err
+// means its connection died, it reports whether the host was moved
+func (r *Remex) checkDisconnected(ctx context.Context, client RemoteClient, err error) bool {
+	if !r.skipDisconnected || ctx.Err() != nil || !isDisconnectError(client, err) {
+		return false
+	}
+	r.markDisconnected(client, err)
+	return true
+}
+
+// reconnectable reports whether the host is disconnected and connected again on the next execution
+func (r *Remex) reconnectable(id string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.reconnectableLocked(id)
+}
+
+// reconnectableLocked is reconnectable for callers holding r.mutex
+func (r *Remex) reconnectableLocked(id string) bool {
+	_, disconnected := r.disconnected[id]
+	return r.reconnectDisconnected && disconnected
+}
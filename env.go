@@ -0,0 +1,101 @@
+package remex
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+)
+
+// LoadEnvFile reads environment variables from a dotenv style file for SSHConfig.Env.
+// Every line holds a NAME=value pair, optionally prefixed by export. Blank lines and
+// lines starting with # are skipped. Values may be wrapped in single quotes, kept
+// literally, or double quotes, where \n, \t, \", \\ and \$ are unescaped.
+func LoadEnvFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open env file: %w", err)
+	}
+	defer file.Close()
+
+	env := make(map[string]string)
+
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		name, value, ok := strings.Cut(line, "=")
+		if name = strings.TrimSpace(name); !ok || !isEnvAssignment(name+"=") {
+			return nil, fmt.Errorf("%s:%d: invalid env assignment %q", path, lineNo, line)
+		}
+
+		if value, err = parseEnvValue(strings.TrimSpace(value)); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %w", path, lineNo, name, err)
+		}
+		env[name] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+
+	return env, nil
+}
+
+// parseEnvValue parses the value of a dotenv assignment
+func parseEnvValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	switch quote := value[0]; quote {
+	case '\'', '"':
+		end := strings.LastIndexByte(value, quote)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated %c quote", quote)
+		}
+		if rest := strings.TrimSpace(value[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected text after closing quote: %q", rest)
+		}
+		if quote == '\'' {
+			return value[1:end], nil
+		}
+		return strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`, `\$`, "$").Replace(value[1:end]), nil
+	default:
+		// 未加引号的值以 " #" 开始行尾注释
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = value[:i]
+		}
+		return strings.TrimSpace(value), nil
+	}
+}
+
+// mergeEnv returns the union of the environments, later ones take precedence
+func mergeEnv(envs ...map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for _, env := range envs {
+		maps.Copy(merged, env)
+	}
+	return merged
+}
+
+// exportEnv returns shell statements exporting the named variables of env, it is
+// used for the variables the server refused to set, see ExecOptions.Env
+func exportEnv(env map[string]string, names []string) (string, error) {
+	var b strings.Builder
+	for _, name := range names {
+		if !isEnvAssignment(name + "=") {
+			return "", fmt.Errorf("invalid environment variable name %q", name)
+		}
+		value, err := QuoteArg(env[name])
+		if err != nil {
+			return "", fmt.Errorf("failed to quote environment variable %s: %w", name, err)
+		}
+		fmt.Fprintf(&b, "export %s=%s; ", name, value)
+	}
+	return b.String(), nil
+}
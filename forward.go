@@ -0,0 +1,196 @@
+package remex
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// Forward is a running port forward created by SSHClient.LocalForward or
+// SSHClient.RemoteForward, it stops when closed or when the client is closed
+type Forward struct {
+	listener net.Listener
+	// dial opens the connection to the other end of the tunnel
+	dial func() (net.Conn, error)
+
+	mutex sync.Mutex
+	conns map[net.Conn]struct{}
+	done  bool
+
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	closeErr  error
+	onClose   func(*Forward)
+}
+
+// LocalForward listens on localAddr and forwards every connection through the SSH
+// connection to remoteAddr as seen from the remote host, like ssh -L. Use
+// Forward.Addr to get the port when localAddr has port 0.
+func (sc *SSHClient) LocalForward(localAddr, remoteAddr string) (*Forward, error) {
+	client, err := sc.Underlying()
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", localAddr, err)
+	}
+
+	return sc.startForward(listener, func() (net.Conn, error) {
+		return client.Dial("tcp", remoteAddr)
+	})
+}
+
+// RemoteForward asks the remote host to listen on remoteAddr and forwards every
+// connection it accepts to localAddr as seen from this host, like ssh -R
+func (sc *SSHClient) RemoteForward(remoteAddr, localAddr string) (*Forward, error) {
+	client, err := sc.Underlying()
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := client.Listen("tcp", remoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on remote %s: %w", remoteAddr, err)
+	}
+
+	return sc.startForward(listener, func() (net.Conn, error) {
+		return net.Dial("tcp", localAddr)
+	})
+}
+
+// startForward serves listener until the forward or the client is closed
+func (sc *SSHClient) startForward(listener net.Listener, dial func() (net.Conn, error)) (*Forward, error) {
+	f := &Forward{listener: listener, dial: dial, conns: make(map[net.Conn]struct{}), onClose: sc.removeForward}
+
+	sc.forwardMutex.Lock()
+	if sc.closed.Load() {
+		sc.forwardMutex.Unlock()
+		listener.Close()
+		return nil, ErrClientClosed
+	}
+	if sc.forwards == nil {
+		sc.forwards = make(map[*Forward]struct{})
+	}
+	sc.forwards[f] = struct{}{}
+	sc.forwardMutex.Unlock()
+
+	f.wg.Go(f.serve)
+	return f, nil
+}
+
+// removeForward forgets a closed forward
+func (sc *SSHClient) removeForward(f *Forward) {
+	sc.forwardMutex.Lock()
+	defer sc.forwardMutex.Unlock()
+	delete(sc.forwards, f)
+}
+
+// closeForwards closes all forwards of the client
+func (sc *SSHClient) closeForwards() {
+	sc.forwardMutex.Lock()
+	forwards := make([]*Forward, 0, len(sc.forwards))
+	for f := range sc.forwards {
+		forwards = append(forwards, f)
+	}
+	sc.forwardMutex.Unlock()
+
+	for _, f := range forwards {
+		f.Close()
+	}
+}
+
+// Addr returns the address the forward listens on
+func (f *Forward) Addr() net.Addr {
+	return f.listener.Addr()
+}
+
+// Close stops listening, closes the forwarded connections and waits for them to finish
+func (f *Forward) Close() error {
+	f.closeOnce.Do(func() {
+		f.closeErr = f.listener.Close()
+
+		f.mutex.Lock()
+		f.done = true
+		for conn := range f.conns {
+			conn.Close()
+		}
+		f.mutex.Unlock()
+
+		f.wg.Wait()
+		if f.onClose != nil {
+			f.onClose(f)
+		}
+	})
+	return f.closeErr
+}
+
+// serve accepts connections until the listener is closed
+func (f *Forward) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.wg.Go(func() {
+			f.forward(conn)
+		})
+	}
+}
+
+// forward connects conn to the other end of the tunnel and copies in both directions
+func (f *Forward) forward(conn net.Conn) {
+	if !f.track(conn) {
+		return
+	}
+	defer f.untrack(conn)
+
+	target, err := f.dial()
+	if err != nil {
+		return
+	}
+	if !f.track(target) {
+		return
+	}
+	defer f.untrack(target)
+
+	var wg sync.WaitGroup
+	wg.Go(func() { copyAndCloseWrite(target, conn) })
+	wg.Go(func() { copyAndCloseWrite(conn, target) })
+	wg.Wait()
+}
+
+// track registers conn so Close can interrupt it, it closes conn and returns
+// false when the forward is already closed
+func (f *Forward) track(conn net.Conn) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.done {
+		conn.Close()
+		return false
+	}
+	f.conns[conn] = struct{}{}
+	return true
+}
+
+// untrack closes conn and forgets it
+func (f *Forward) untrack(conn net.Conn) {
+	conn.Close()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.conns, conn)
+}
+
+// copyAndCloseWrite copies src to dst and then half-closes dst, so the peer sees
+// EOF while the other direction keeps flowing
+func copyAndCloseWrite(dst, src net.Conn) {
+	io.Copy(dst, src)
+
+	if cw, ok := dst.(interface{ CloseWrite() error }); !ok || cw.CloseWrite() != nil {
+		dst.Close()
+	}
+}
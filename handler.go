@@ -0,0 +1,61 @@
+package remex
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// NewJSONLinesHandler returns a ResultHandler writing every result to w as one JSON
+// line, see ExecResult.MarshalJSON. It is safe for concurrent use, write errors
+// are ignored like for any handler, so w should report its own failures if needed.
+func NewJSONLinesHandler(w io.Writer) ResultHandler {
+	var (
+		mutex   sync.Mutex
+		encoder = json.NewEncoder(w)
+	)
+
+	return func(result ExecResult) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		_ = encoder.Encode(result)
+	}
+}
+
+// NewConsoleHandler returns a ResultHandler printing a status line to w for every
+// finished command, with the host ID, the command, ok or FAIL, the duration and
+// the error of failed commands. The host and command columns are padded to the
+// widest value printed so far. It is safe for concurrent use.
+func NewConsoleHandler(w io.Writer) ResultHandler {
+	var (
+		mutex        sync.Mutex
+		hostWidth    int
+		commandWidth int
+	)
+
+	return func(result ExecResult) {
+		if result.Stage != StageFinish {
+			return
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		hostWidth = max(hostWidth, len(result.ID))
+		commandWidth = max(commandWidth, len(result.Command))
+
+		status := "ok"
+		if result.Error != nil {
+			status = "FAIL"
+		}
+
+		line := fmt.Sprintf("%-*s  %-*s  %-4s  %8s", hostWidth, result.ID, commandWidth, result.Command, status, result.Duration.Round(time.Millisecond))
+		if result.Error != nil {
+			line += "  " + result.Error.Error()
+		}
+		_, _ = fmt.Fprintln(w, line)
+	}
+}
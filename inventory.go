@@ -0,0 +1,179 @@
+package remex
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Inventory is the content of a host inventory file read by LoadConfigs
+//
+//	hosts:
+//	  - id: web1
+//	    host: 192.168.1.10
+//	    user: deploy
+//	    key_path: ~/.ssh/id_ed25519
+//	    tags: [role=web, env=prod]
+type Inventory struct {
+	Hosts []HostEntry `json:"hosts" yaml:"hosts"`
+}
+
+// HostEntry describes one host of an inventory file
+type HostEntry struct {
+	ID string `json:"id" yaml:"id"`
+	// Host is the IP address or DNS name of the host
+	Host string `json:"host" yaml:"host"`
+	// Port defaults to DefaultSSHPort when zero
+	Port     uint16 `json:"port" yaml:"port"`
+	User     string `json:"user" yaml:"user"`
+	Password string `json:"password" yaml:"password"`
+	// KeyPath is the private key file, a relative path is resolved against
+	// the directory of the inventory file and ~ against the home directory
+	KeyPath    string `json:"key_path" yaml:"key_path"`
+	Passphrase string `json:"passphrase" yaml:"passphrase"`
+
+	KnownHostsPath        string `json:"known_hosts_path" yaml:"known_hosts_path"`
+	InsecureIgnoreHostKey bool   `json:"insecure_ignore_host_key" yaml:"insecure_ignore_host_key"`
+	// ForwardAgent forwards the agent at SSH_AUTH_SOCK, see SSHConfig.ForwardAgent
+	ForwardAgent bool `json:"forward_agent" yaml:"forward_agent"`
+
+	// Env is set for every command run on the host, it takes precedence over
+	// the variables read from EnvFile, a dotenv file resolved like KeyPath
+	Env     map[string]string `json:"env" yaml:"env"`
+	EnvFile string            `json:"env_file" yaml:"env_file"`
+
+	Tags []string `json:"tags" yaml:"tags"`
+}
+
+// LoadConfigs reads a YAML or JSON inventory file, chosen by its extension,
+// and returns the SSH configurations keyed by host ID
+func LoadConfigs(path string) (map[string]*SSHConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory: %w", err)
+	}
+
+	var inventory Inventory
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&inventory)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(&inventory); errors.Is(err, io.EOF) {
+			err = nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported inventory format %q, want .json, .yaml or .yml", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse inventory %s: %w", path, err)
+	}
+
+	return inventory.Configs(filepath.Dir(path))
+}
+
+// Configs validates the entries and builds their SSH configurations keyed by host ID,
+// relative key paths are resolved against baseDir
+func (inv Inventory) Configs(baseDir string) (map[string]*SSHConfig, error) {
+	configs := make(map[string]*SSHConfig, len(inv.Hosts))
+	for i, entry := range inv.Hosts {
+		if strings.TrimSpace(entry.ID) == "" {
+			return nil, fmt.Errorf("host #%d: id is required", i+1)
+		}
+		if _, ok := configs[entry.ID]; ok {
+			return nil, fmt.Errorf("host %q: duplicate id", entry.ID)
+		}
+
+		config, err := entry.config(baseDir)
+		if err != nil {
+			return nil, fmt.Errorf("host %q: %w", entry.ID, err)
+		}
+		configs[entry.ID] = config
+	}
+	return configs, nil
+}
+
+// config validates the entry and builds its SSH configuration
+func (e HostEntry) config(baseDir string) (*SSHConfig, error) {
+	if e.Host == "" {
+		return nil, errors.New("host is required")
+	}
+	if e.User == "" {
+		return nil, errors.New("user is required")
+	}
+	if e.Password == "" && e.KeyPath == "" {
+		return nil, errors.New("password or key_path is required")
+	}
+
+	addr, err := netip.ParseAddr(e.Host)
+	config := NewSSHConfig(addr, e.User, e.Password)
+	if err != nil {
+		config.Host = e.Host
+	}
+	if e.Port != 0 {
+		config.Port = e.Port
+	}
+
+	if e.KeyPath != "" {
+		keyPath, err := expandPath(e.KeyPath, baseDir)
+		if err != nil {
+			return nil, err
+		}
+		if config.PrivateKey, err = os.ReadFile(keyPath); err != nil {
+			return nil, fmt.Errorf("failed to read private key: %w", err)
+		}
+		if e.Passphrase != "" {
+			config.Passphrase = []byte(e.Passphrase)
+		}
+	}
+
+	if e.KnownHostsPath != "" {
+		if config.KnownHostsPath, err = expandPath(e.KnownHostsPath, baseDir); err != nil {
+			return nil, err
+		}
+	}
+	config.InsecureIgnoreHostKey = e.InsecureIgnoreHostKey
+	config.ForwardAgent = e.ForwardAgent
+	config.Tags = e.Tags
+
+	config.Env = e.Env
+	if e.EnvFile != "" {
+		envFile, err := expandPath(e.EnvFile, baseDir)
+		if err != nil {
+			return nil, err
+		}
+		env, err := LoadEnvFile(envFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Env = mergeEnv(env, e.Env)
+	}
+
+	return config, nil
+}
+
+// expandPath expands a leading ~ to the home directory and resolves relative paths against baseDir
+func expandPath(path, baseDir string) (string, error) {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to expand %s: %w", path, err)
+		}
+		return filepath.Join(home, path[1:]), nil
+	}
+	if !filepath.IsAbs(path) {
+		return filepath.Join(baseDir, path), nil
+	}
+	return path, nil
+}
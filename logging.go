@@ -0,0 +1,139 @@
+package remex
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// redacted replaces secret values in log records
+const redacted = "[REDACTED]"
+
+// minSecretLength is the length of the shortest password scrubbed from log text,
+// shorter ones would hide every occurrence of common letters and words. Secret
+// attributes are redacted whatever the length of their value.
+const minSecretLength = 6
+
+// secretKeys are the attribute keys whose values are always redacted
+var secretKeys = []string{"password", "passphrase", "secret", "token", "private_key", "privatekey"}
+
+// LogValue implements slog.LogValuer so configurations never log their credentials
+func (config *SSHConfig) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("username", config.Username),
+		slog.String("addr", config.Addr.String()),
+		slog.Int("port", int(config.Port)),
+	}
+	if config.Host != "" {
+		attrs = append(attrs, slog.String("host", config.Host))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// String formats the configuration without its credentials
+func (config *SSHConfig) String() string {
+	return config.Username + "@" + config.dialAddr()
+}
+
+// secretSet holds the passwords scrubbed from log values
+type secretSet struct {
+	mutex sync.RWMutex
+	// secrets are sorted longest first, so a secret containing another one is
+	// scrubbed whole
+	secrets []string
+}
+
+// addConfig adds the password and the key passphrase of config
+func (s *secretSet) addConfig(config *SSHConfig) {
+	s.add(config.Password)
+	s.add(string(config.Passphrase))
+}
+
+func (s *secretSet) add(secret string) {
+	if len(secret) < minSecretLength {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if slices.Contains(s.secrets, secret) {
+		return
+	}
+	s.secrets = append(s.secrets, secret)
+	slices.SortFunc(s.secrets, func(a, b string) int { return len(b) - len(a) })
+}
+
+func (s *secretSet) scrub(v string) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, secret := range s.secrets {
+		v = strings.ReplaceAll(v, secret, redacted)
+	}
+	return v
+}
+
+// redactHandler is a slog.Handler that redacts secret attributes and known
+// passwords before passing records to the wrapped handler
+type redactHandler struct {
+	handler slog.Handler
+	secrets *secretSet
+}
+
+func (h *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *redactHandler) Handle(ctx context.Context, record slog.Record) error {
+	redactedRecord := slog.NewRecord(record.Time, record.Level, h.secrets.scrub(record.Message), record.PC)
+	record.Attrs(func(a slog.Attr) bool {
+		redactedRecord.AddAttrs(h.redact(a))
+		return true
+	})
+	return h.handler.Handle(ctx, redactedRecord)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redactedAttrs := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redactedAttrs[i] = h.redact(a)
+	}
+	return &redactHandler{handler: h.handler.WithAttrs(redactedAttrs), secrets: h.secrets}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{handler: h.handler.WithGroup(name), secrets: h.secrets}
+}
+
+func (h *redactHandler) redact(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+
+	key := strings.ToLower(a.Key)
+	for _, secretKey := range secretKeys {
+		if strings.Contains(key, secretKey) {
+			return slog.String(a.Key, redacted)
+		}
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, h.secrets.scrub(a.Value.String()))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		redactedGroup := make([]slog.Attr, len(group))
+		for i, ga := range group {
+			redactedGroup[i] = h.redact(ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(redactedGroup...)}
+	case slog.KindAny:
+		// 只在包含密码时转换为字符串，避免改变其他值的类型
+		if s := fmt.Sprint(a.Value.Any()); h.secrets.scrub(s) != s {
+			return slog.String(a.Key, h.secrets.scrub(s))
+		}
+	}
+	return a
+}
@@ -0,0 +1,162 @@
+package remex
+
+import (
+	"maps"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures a Remex instance
+type Option func(*Remex)
+
+// WithConnectConcurrency limits the number of hosts connected in parallel, zero means unlimited
+func WithConnectConcurrency(n int) Option {
+	return func(r *Remex) {
+		r.connectConcurrency = n
+	}
+}
+
+// WithMaxConcurrency limits the number of hosts executing commands in parallel, zero means unlimited
+func WithMaxConcurrency(n int) Option {
+	return func(r *Remex) {
+		if n > 0 {
+			r.executeSlots = make(chan struct{}, n)
+		} else {
+			r.executeSlots = nil
+		}
+	}
+}
+
+// WithCommandTimeout limits how long each command may run, a command running
+// longer is killed and reported as a *CommandTimeoutError, zero means no limit
+func WithCommandTimeout(d time.Duration) Option {
+	return func(r *Remex) {
+		r.commandTimeout = d
+	}
+}
+
+// WithStreamOutput makes the handlers receive every line of command output as it arrives
+// as an ExecResult with StageOutput, before the StageFinish result with the full output
+func WithStreamOutput() Option {
+	return func(r *Remex) {
+		r.streamOutput = true
+	}
+}
+
+// WithResultBuffer delivers the results to the handlers from a dedicated goroutine
+// through a buffer of size results, so slow handlers do not stall the commands.
+// The handlers then receive the results one at a time in the order they were
+// produced, possibly after the execution returned. When the buffer is full the
+// policy either blocks the execution until there is room or drops the result.
+// Close stops the delivery goroutine after the queued results, Shutdown also
+// waits for them. Zero size keeps calling the handlers inline.
+func WithResultBuffer(size int, policy OverflowPolicy) Option {
+	return func(r *Remex) {
+		if size > 0 {
+			r.results = make(chan ExecResult, size)
+		} else {
+			r.results = nil
+		}
+		r.overflowPolicy = policy
+	}
+}
+
+// WithTracer creates OpenTelemetry spans with tracer: one for every Execute,
+// ExecuteWithID and their variants and a child span for every command, with the
+// host ID, address, command and exit code as attributes. No spans are created
+// without it.
+//
+// The spans of ExecuteContext and ExecuteWithIDContext nest under the span of
+// their ctx. The other methods only see the context given to NewWithContext, so
+// their spans nest under the span of that context, not under the span of the caller.
+func WithTracer(tracer trace.Tracer) Option {
+	return func(r *Remex) {
+		if tracer != nil {
+			r.tracer = tracer
+		}
+	}
+}
+
+// WithMetrics reports every finished command to metrics, see the promremex
+// package for a Prometheus implementation
+func WithMetrics(metrics Metrics) Option {
+	return func(r *Remex) {
+		r.metrics = metrics
+	}
+}
+
+// WithLazyConnect connects the hosts without a client, e.g. hosts that were down
+// during Connect, on their first execution and keeps their clients. Connect is
+// then optional. A host failing to connect is reported with a StageDisconnected
+// result and fails the execution like a failing command.
+func WithLazyConnect() Option {
+	return func(r *Remex) {
+		r.lazyConnect = true
+	}
+}
+
+// WithLogAttrs adds attributes, e.g. a run ID, to every log line of the Remex instance
+func WithLogAttrs(args ...any) Option {
+	return func(r *Remex) {
+		r.logAttrs = append(r.logAttrs, args...)
+	}
+}
+
+// WithRedactSecrets scrubs the configured passwords and key passphrases and the
+// attributes with secret keys such as "password" or "token" from everything the
+// Remex instance logs. Passwords shorter than 6 characters are only redacted in
+// secret attributes, scrubbing them from the log text would hide common words.
+func WithRedactSecrets() Option {
+	return func(r *Remex) {
+		r.secrets = &secretSet{}
+	}
+}
+
+// WithTemplateVars adds template variables for the host with the given ID, e.g.
+// {"ROLE": "primary"} expands {{ROLE}} in its commands. Values other than strings
+// are formatted with fmt.Sprint, the built-in REMEX_* variables cannot be overridden.
+func WithTemplateVars(id string, vars map[string]any) Option {
+	return func(r *Remex) {
+		if r.templateVars == nil {
+			r.templateVars = make(map[string]map[string]any)
+		}
+		if r.templateVars[id] == nil {
+			r.templateVars[id] = make(map[string]any, len(vars))
+		}
+		maps.Copy(r.templateVars[id], vars)
+	}
+}
+
+// WithCommandPolicy rejects the commands not permitted by policy before they
+// run, the error of a rejected command wraps ErrCommandBlocked
+func WithCommandPolicy(policy CommandPolicy) Option {
+	return func(r *Remex) {
+		r.policy = &policy
+	}
+}
+
+// WithCircuitBreaker skips a host for cooldown once threshold commands failed on
+// it in a row, the executions on a skipped host fail with ErrCircuitOpen. Failed
+// connects of WithLazyConnect and of reconnected hosts count as failures too, a
+// skipped host is not dialed. After
+// the cooldown a single trial execution runs: its success closes the circuit,
+// its failure opens it for another cooldown. See Remex.HostState.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(r *Remex) {
+		if threshold > 0 {
+			r.breaker = newCircuitBreaker(threshold, cooldown)
+		}
+	}
+}
+
+// WithSkipDisconnected moves a host whose connection dies during an execution,
+// e.g. when no session can be opened, to DisconnectedHosts instead of failing the
+// batch with it, commands failing on a live connection still fail the batch. The
+// later executions skip the host, with reconnect they first try to connect it again.
+func WithSkipDisconnected(reconnect bool) Option {
+	return func(r *Remex) {
+		r.skipDisconnected = true
+		r.reconnectDisconnected = reconnect
+	}
+}
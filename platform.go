@@ -0,0 +1,92 @@
+package remex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// unameCommands are tried in order to detect the remote platform, hosts without
+// uname such as Windows OpenSSH with cmd.exe expand the environment variables
+var unameCommands = []string{"uname -s -m", "echo %OS% %PROCESSOR_ARCHITECTURE%"}
+
+// RemoteUname returns the OS and architecture of the remote host with the names
+// used by GOOS and GOARCH, e.g. "linux" and "amd64", names it does not know are
+// returned in lower case. The result is cached for an *SSHClient, see SSHClient.Uname.
+func RemoteUname(ctx context.Context, client RemoteClient) (os, arch string, err error) {
+	if sc, ok := client.(*SSHClient); ok {
+		return sc.Uname(ctx)
+	}
+	return detectPlatform(ctx, client)
+}
+
+// Uname returns the OS and architecture of the remote host like RemoteUname, the
+// first successful result is cached for the lifetime of the connection
+func (sc *SSHClient) Uname(ctx context.Context) (os, arch string, err error) {
+	sc.platformMutex.Lock()
+	defer sc.platformMutex.Unlock()
+
+	if sc.platformOS != "" {
+		return sc.platformOS, sc.platformArch, nil
+	}
+
+	if os, arch, err = detectPlatform(ctx, sc); err != nil {
+		return "", "", err
+	}
+	sc.platformOS, sc.platformArch = os, arch
+	return os, arch, nil
+}
+
+// detectPlatform runs unameCommands on client until one prints an OS and architecture
+func detectPlatform(ctx context.Context, client RemoteClient) (os, arch string, err error) {
+	var errs []error
+	for _, command := range unameCommands {
+		output, err := client.ExecuteCommand(ctx, command)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", "", err
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", command, err))
+			continue
+		}
+
+		if os, arch, ok := parseUname(output); ok {
+			return os, arch, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: unexpected output %q", command, strings.TrimSpace(output)))
+	}
+
+	return "", "", fmt.Errorf("failed to detect remote platform: %w", errors.Join(errs...))
+}
+
+// parseUname parses the "OS machine" output of the unameCommands
+func parseUname(output string) (os, arch string, ok bool) {
+	fields := strings.Fields(output)
+	if len(fields) != 2 || strings.HasPrefix(fields[0], "%") || strings.HasPrefix(fields[1], "%") {
+		return "", "", false
+	}
+
+	os = strings.ToLower(fields[0])
+	switch {
+	case os == "windows_nt", strings.HasPrefix(os, "mingw"), strings.HasPrefix(os, "msys"), strings.HasPrefix(os, "cygwin"):
+		os = "windows"
+	case os == "sunos":
+		os = "solaris"
+	}
+
+	arch = strings.ToLower(fields[1])
+	switch {
+	case arch == "x86_64", arch == "x64":
+		arch = "amd64"
+	case arch == "aarch64":
+		arch = "arm64"
+	case arch == "x86", arch == "i86pc", len(arch) == 4 && arch[0] == 'i' && strings.HasSuffix(arch, "86"):
+		arch = "386"
+	case strings.HasPrefix(arch, "armv"):
+		// armv8l 是 64 位 CPU 上的 32 位 ARM 用户态，与 armv7l、armv6l 一样对应 GOARCH arm
+		arch = "arm"
+	}
+
+	return os, arch, true
+}
@@ -0,0 +1,44 @@
+package remex
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrCommandBlocked is returned when a command is rejected by the CommandPolicy
+var ErrCommandBlocked = errors.New("command blocked by policy")
+
+// CommandPolicy restricts the commands a Remex instance executes, see WithCommandPolicy
+type CommandPolicy struct {
+	// Allow lists the permitted command prefixes, a prefix matches the whole
+	// command or the command followed by its arguments, so "systemctl status"
+	// allows "systemctl status nginx" but "ls" does not allow "lsblk". An empty
+	// list permits every command not denied. Prefixes do not look inside shell
+	// operators, deny patterns like `[;&|]` to reject chained commands.
+	Allow []string
+	// Deny rejects the commands matching any of the patterns, it is checked before Allow
+	Deny []*regexp.Regexp
+}
+
+// check returns an error wrapping ErrCommandBlocked when the policy rejects command
+func (p *CommandPolicy) check(command string) error {
+	command = strings.TrimSpace(command)
+
+	for _, pattern := range p.Deny {
+		if pattern.MatchString(command) {
+			return fmt.Errorf("%w: %q matches denied pattern %q", ErrCommandBlocked, command, pattern)
+		}
+	}
+
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	for _, prefix := range p.Allow {
+		if rest, ok := strings.CutPrefix(command, prefix); ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t') {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q is not allowed", ErrCommandBlocked, command)
+}
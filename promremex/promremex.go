@@ -0,0 +1,58 @@
+// Package promremex exposes the command metrics of remex to Prometheus
+package promremex
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/leijux/remex"
+)
+
+// Metrics implements remex.Metrics with Prometheus collectors labeled by host ID
+type Metrics struct {
+	commands *prometheus.CounterVec
+	failed   *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+var _ remex.Metrics = (*Metrics)(nil)
+
+// New creates the remex_commands_total and remex_commands_failed_total counters
+// and the remex_command_duration_seconds histogram and registers them with reg,
+// use remex.WithMetrics to pass the result to a Remex instance
+func New(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		commands: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "remex_commands_total",
+			Help: "Number of commands executed by remex.",
+		}, []string{"host"}),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "remex_commands_failed_total",
+			Help: "Number of commands executed by remex that failed.",
+		}, []string{"host"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "remex_command_duration_seconds",
+			Help:    "Duration of the commands executed by remex.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 8),
+		}, []string{"host"}),
+	}
+
+	for _, collector := range []prometheus.Collector{m.commands, m.failed, m.duration} {
+		if err := reg.Register(collector); err != nil {
+			return nil, fmt.Errorf("failed to register remex metrics: %w", err)
+		}
+	}
+
+	return m, nil
+}
+
+// OnCommandComplete implements remex.Metrics
+func (m *Metrics) OnCommandComplete(hostID string, success bool, d time.Duration) {
+	m.commands.WithLabelValues(hostID).Inc()
+	if !success {
+		m.failed.WithLabelValues(hostID).Inc()
+	}
+	m.duration.WithLabelValues(hostID).Observe(d.Seconds())
+}
@@ -0,0 +1,44 @@
+package promremex
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestMetrics 测试命令结果计入计数器和直方图
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(reg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	m.OnCommandComplete("host1", true, 20*time.Millisecond)
+	m.OnCommandComplete("host1", false, 2*time.Second)
+	m.OnCommandComplete("host2", true, 50*time.Millisecond)
+
+	want := `
+# HELP remex_commands_failed_total Number of commands executed by remex that failed.
+# TYPE remex_commands_failed_total counter
+remex_commands_failed_total{host="host1"} 1
+# HELP remex_commands_total Number of commands executed by remex.
+# TYPE remex_commands_total counter
+remex_commands_total{host="host1"} 2
+remex_commands_total{host="host2"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "remex_commands_total", "remex_commands_failed_total"); err != nil {
+		t.Error(err)
+	}
+
+	if got := testutil.CollectAndCount(m.duration); got != 2 {
+		t.Errorf("duration histograms = %d, want 2", got)
+	}
+
+	if _, err := New(reg); err == nil {
+		t.Error("New() registering twice error = nil")
+	}
+}
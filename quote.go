@@ -0,0 +1,37 @@
+package remex
+
+import (
+	"fmt"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// QuoteArg quotes s as a single word for a POSIX shell, so user supplied pieces can
+// be embedded in commands without being interpreted. Strings that need no quoting
+// are returned unchanged, an error is returned only when s contains a null byte.
+func QuoteArg(s string) (string, error) {
+	quoted, err := syntax.Quote(s, syntax.LangPOSIX)
+	if err == nil {
+		return quoted, nil
+	}
+	if strings.ContainsRune(s, 0) {
+		return "", fmt.Errorf("cannot quote %q: %w", s, err)
+	}
+
+	// POSIX shell 没有转义序列，换行等控制字符放在单引号中原样保留
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'", nil
+}
+
+// QuoteCommand returns a command line running name with args, quoting every word with QuoteArg
+func QuoteCommand(name string, args ...string) (string, error) {
+	words := make([]string, 0, len(args)+1)
+	for _, word := range append([]string{name}, args...) {
+		quoted, err := QuoteArg(word)
+		if err != nil {
+			return "", err
+		}
+		words = append(words, quoted)
+	}
+	return strings.Join(words, " "), nil
+}
@@ -2,19 +2,41 @@ package remex
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/netip"
+	"runtime/debug"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/valyala/fasttemplate"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 )
 
-const remexID = "REMEX_ID"
+// Template variables available in the commands passed to Execute and its variants
+const (
+	remexID    = "REMEX_ID"
+	remexAddr  = "REMEX_ADDR"
+	remexPort  = "REMEX_PORT"
+	remexUser  = "REMEX_USER"
+	remexIndex = "REMEX_INDEX"
+)
+
+var (
+	// ErrNoClientForID is returned when no connected client has the requested host ID
+	ErrNoClientForID = errors.New("no client found for id")
+	// ErrClosed is returned for executions started after Close or Shutdown
+	ErrClosed = errors.New("remex is closed")
+	// ErrAborted is the cause of the context canceled by an AbortHandler
+	ErrAborted = errors.New("execution aborted by handler")
+)
 
 type Stage uint8
 
@@ -26,6 +48,8 @@ const (
 
 	StageStart
 	StageFinish
+	// StageOutput carries a single line of output of a running command, see WithStreamOutput
+	StageOutput
 )
 
 // ExecResult represents the result of command execution
@@ -36,7 +60,18 @@ type ExecResult struct {
 	RemoteAddr fmt.Stringer `json:"remote_addr"`
 	Stage      Stage        `json:"stage"`
 	Error      error        `json:"error,omitempty"`
-	Output     string       `json:"output,omitempty"`
+	// Output holds stdout and stderr combined
+	Output string `json:"output,omitempty"`
+	Stdout string `json:"stdout,omitempty"`
+	Stderr string `json:"stderr,omitempty"`
+
+	// ExitCode is the exit status of a finished command, -1 when it is unknown
+	ExitCode int    `json:"exit_code"`
+	Signal   string `json:"signal,omitempty"`
+	// Truncated reports that the output was cut off at SSHConfig.MaxOutputBytes
+	Truncated bool `json:"truncated,omitempty"`
+	// Duration is the time the command took, set on StageFinish results
+	Duration time.Duration `json:"duration,omitempty"`
 
 	Time time.Time `json:"time"`
 }
@@ -46,9 +81,152 @@ func (er ExecResult) String() string {
 		er.Command, er.ID, er.RemoteAddr, er.Error, er.Output, er.Time)
 }
 
+// execResultJSON is the JSON representation of ExecResult
+type execResultJSON struct {
+	ID string `json:"id"`
+
+	Command    string  `json:"command"`
+	RemoteAddr string  `json:"remote_addr"`
+	Stage      Stage   `json:"stage"`
+	Error      *string `json:"error"`
+	Output     string  `json:"output,omitempty"`
+	Stdout     string  `json:"stdout,omitempty"`
+	Stderr     string  `json:"stderr,omitempty"`
+	ExitCode   int     `json:"exit_code"`
+	Signal     string  `json:"signal,omitempty"`
+	Truncated  bool    `json:"truncated,omitempty"`
+	// Duration is encoded in nanoseconds
+	Duration time.Duration `json:"duration,omitempty"`
+
+	Time time.Time `json:"time"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (er ExecResult) MarshalJSON() ([]byte, error) {
+	v := execResultJSON{
+		ID:        er.ID,
+		Command:   er.Command,
+		Stage:     er.Stage,
+		Output:    er.Output,
+		Stdout:    er.Stdout,
+		Stderr:    er.Stderr,
+		ExitCode:  er.ExitCode,
+		Signal:    er.Signal,
+		Truncated: er.Truncated,
+		Duration:  er.Duration,
+		Time:      er.Time,
+	}
+	if er.RemoteAddr != nil {
+		v.RemoteAddr = er.RemoteAddr.String()
+	}
+	if er.Error != nil {
+		msg := er.Error.Error()
+		v.Error = &msg
+	}
+
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, RemoteAddr is decoded as a
+// netip.AddrPort or netip.Addr and Error as a plain error carrying the message
+func (er *ExecResult) UnmarshalJSON(data []byte) error {
+	var v execResultJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*er = ExecResult{
+		ID:        v.ID,
+		Command:   v.Command,
+		Stage:     v.Stage,
+		Output:    v.Output,
+		Stdout:    v.Stdout,
+		Stderr:    v.Stderr,
+		ExitCode:  v.ExitCode,
+		Signal:    v.Signal,
+		Truncated: v.Truncated,
+		Duration:  v.Duration,
+		Time:      v.Time,
+	}
+	if addrPort, err := netip.ParseAddrPort(v.RemoteAddr); err == nil {
+		er.RemoteAddr = addrPort
+	} else if addr, err := netip.ParseAddr(v.RemoteAddr); err == nil {
+		er.RemoteAddr = addr
+	}
+	if v.Error != nil {
+		er.Error = errors.New(*v.Error)
+	}
+
+	return nil
+}
+
+// CommandTimeoutError is returned when a command runs longer than the per-command timeout
+type CommandTimeoutError struct {
+	Command string
+	Timeout time.Duration
+	// Err is the error returned by the interrupted command
+	Err error
+}
+
+func (e *CommandTimeoutError) Error() string {
+	return fmt.Sprintf("command %q timed out after %v", e.Command, e.Timeout)
+}
+
+// Unwrap makes the error match context.DeadlineExceeded and the command error
+func (e *CommandTimeoutError) Unwrap() []error {
+	return []error{context.DeadlineExceeded, e.Err}
+}
+
+// CommandError is returned when a command fails on a remote host
+type CommandError struct {
+	HostID     string
+	RemoteAddr netip.AddrPort
+	Command    string
+	// ExitCode is the exit status of the command, -1 when it is unknown
+	ExitCode int
+	Err      error
+}
+
+func newCommandError(client RemoteClient, command string, output CommandOutput, err error) *CommandError {
+	return &CommandError{
+		HostID:     client.ID(),
+		RemoteAddr: client.RemoteAddr(),
+		Command:    command,
+		ExitCode:   output.ExitCode,
+		Err:        err,
+	}
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("host %s (%s): failed to execute command %q: %v", e.HostID, e.RemoteAddr, e.Command, e.Err)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
 // ResultHandler is a function type for handling execution results
 type ResultHandler func(ExecResult)
 
+// OverflowPolicy decides what happens to a result when the buffer of WithResultBuffer is full
+type OverflowPolicy uint8
+
+const (
+	// OverflowBlock makes the execution wait until the handlers catch up
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop discards the result and logs a warning, the execution never waits
+	OverflowDrop
+)
+
+// Metrics receives the outcome of every finished command, see WithMetrics.
+// It is called concurrently from the hosts executing commands.
+type Metrics interface {
+	OnCommandComplete(hostID string, success bool, d time.Duration)
+}
+
+// AbortHandler is a result handler that stops the execution by returning an error, see RegisterAbortHandler
+type AbortHandler func(ExecResult) error
+
 // Remex represents a distributed command execution engine
 type Remex struct {
 	clients map[string]RemoteClient
@@ -56,38 +234,123 @@ type Remex struct {
 
 	logger *slog.Logger
 
-	handlers []ResultHandler
+	handlers []AbortHandler
 
 	ctx context.Context
-
-	errGroup *errgroup.Group
-	mutex    sync.RWMutex
-
-	newSSHClient func(string, *SSHConfig) (RemoteClient, error)
+	// cancel aborts the context when a handler returns an error
+	cancel context.CancelCauseFunc
+
+	// results queues the results for the delivery goroutine, nil means the
+	// handlers are called inline, see WithResultBuffer
+	results        chan ExecResult
+	overflowPolicy OverflowPolicy
+	// resultsMutex guards closing results against concurrent sends
+	resultsMutex  sync.RWMutex
+	resultsClosed bool
+	// resultsDone is closed once the delivery goroutine has delivered every queued result
+	resultsDone chan struct{}
+
+	mutex sync.RWMutex
+	// closing rejects new executions once Close or Shutdown is called
+	closing bool
+	// executions tracks the running executions Shutdown waits for
+	executions sync.WaitGroup
+
+	closeOnce sync.Once
+	closeErr  error
+
+	// lastStats summarizes the last finished batch, see Stats
+	lastStats *ExecStats
+	// connectResult holds the outcome of every host of the last Connect, see ConnectResult
+	connectResult map[string]error
+
+	connectConcurrency int
+	// commandTimeout limits how long a single command may run, zero means no limit
+	commandTimeout time.Duration
+	// streamOutput notifies the handlers of every output line with StageOutput
+	streamOutput bool
+
+	logAttrs []any
+	// secrets are scrubbed from the logs when set, see WithRedactSecrets
+	secrets *secretSet
+	// executeSlots limits the hosts executing commands at the same time, nil means unlimited
+	executeSlots chan struct{}
+	// templateVars holds the caller supplied template variables keyed by host ID
+	templateVars map[string]map[string]any
+	// policy rejects commands before they run when set, see WithCommandPolicy
+	policy *CommandPolicy
+	// tracer creates the execution spans, see WithTracer
+	tracer trace.Tracer
+	// metrics is notified of every finished command when set, see WithMetrics
+	metrics Metrics
+	// lazyConnect connects the hosts missing a client on their first execution, see WithLazyConnect
+	lazyConnect bool
+	// skipDisconnected moves the hosts whose connection died to disconnected,
+	// reconnectDisconnected connects them again on the next execution, see WithSkipDisconnected
+	skipDisconnected      bool
+	reconnectDisconnected bool
+	// disconnected holds the error of every host skipped because its connection died
+	disconnected map[string]error
+	// commands holds the remex commands of this instance, falling back to the global registry
+	commands *remexRegistry
+	// breaker skips the hosts failing repeatedly when set, see WithCircuitBreaker
+	breaker *circuitBreaker
+
+	newSSHClient func(context.Context, string, *SSHConfig) (RemoteClient, error)
 }
 
 // NewWithContext creates a new DistExec instance with the given context and configuration
-func NewWithContext(ctx context.Context, logger *slog.Logger, configs map[string]*SSHConfig) *Remex {
+func NewWithContext(ctx context.Context, logger *slog.Logger, configs map[string]*SSHConfig, opts ...Option) *Remex {
 	if logger == nil {
 		logger = slog.Default()
 	}
 
-	g, _ := errgroup.WithContext(ctx)
-	return &Remex{
-		clients:  make(map[string]RemoteClient),
-		configs:  configs,
-		logger:   logger,
-		ctx:      ctx,
-		errGroup: g,
+	// Copy the configs so AddHost and RemoveHost never modify the caller's map
+	if configs = maps.Clone(configs); configs == nil {
+		configs = make(map[string]*SSHConfig)
+	}
+
+	r := &Remex{
+		clients:      make(map[string]RemoteClient),
+		configs:      configs,
+		disconnected: make(map[string]error),
+		logger:       logger,
+
+		commands: &remexRegistry{commands: make(map[string]registeredCommand), parent: registry},
 
-		newSSHClient: NewSSHClient,
+		tracer:       noopTracer,
+		newSSHClient: NewSSHClientContext,
 	}
+	r.ctx, r.cancel = context.WithCancelCause(ctx)
+	r.ctx = withRegistry(r.ctx, r.commands)
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if r.secrets != nil {
+		for _, config := range configs {
+			r.secrets.addConfig(config)
+		}
+		r.logger = slog.New(&redactHandler{handler: r.logger.Handler(), secrets: r.secrets})
+	}
+	if len(r.logAttrs) > 0 {
+		r.logger = r.logger.With(r.logAttrs...)
+	}
+	if r.results != nil {
+		r.resultsDone = make(chan struct{})
+		go r.deliverResults()
+	}
+
+	return r
 }
 
 // setNewSSHClient sets a custom function for creating SSH clients
 // test using custom SSH client
 func (r *Remex) setNewSSHClient(newF func(string, *SSHConfig) (RemoteClient, error)) {
-	r.newSSHClient = newF
+	r.newSSHClient = func(_ context.Context, id string, config *SSHConfig) (RemoteClient, error) {
+		return newF(id, config)
+	}
 }
 
 // RegisterHandler registers handler functions for receiving execution results
@@ -95,40 +358,142 @@ func (r *Remex) RegisterHandler(handlers ...ResultHandler) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
+	for _, h := range handlers {
+		r.handlers = append(r.handlers, func(result ExecResult) error {
+			h(result)
+			return nil
+		})
+	}
+}
+
+// RegisterAbortHandler registers handler functions for receiving execution results
+// that may stop everything. When a handler returns an error the Remex context is
+// canceled with a cause wrapping ErrAborted and the error: running commands are
+// killed and no further commands start, the Remex then behaves like its context
+// was canceled. It is meant for fail-fast policies driven by the result content.
+func (r *Remex) RegisterAbortHandler(handlers ...AbortHandler) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
 	r.handlers = append(r.handlers, handlers...)
 }
 
-// notifyHandlers sends execution results to all registered handlers
+// notifyHandlers sends execution results to all registered handlers, through the
+// result buffer when one is configured
 func (r *Remex) notifyHandlers(result ExecResult) {
-	result.Time = time.Now()
+	if result.Time.IsZero() {
+		result.Time = time.Now()
+	}
 
+	if r.results == nil {
+		r.deliverResult(result)
+		return
+	}
+
+	r.resultsMutex.RLock()
+	defer r.resultsMutex.RUnlock()
+
+	if r.resultsClosed {
+		r.logger.Debug("remex is closed, discarding result", "ID", result.ID, "command", result.Command)
+		return
+	}
+
+	if r.overflowPolicy == OverflowDrop {
+		select {
+		case r.results <- result:
+		default:
+			r.logger.Warn("result buffer full, dropping result", "ID", result.ID, "stage", result.Stage, "command", result.Command)
+		}
+		return
+	}
+	r.results <- result
+}
+
+// deliverResults calls the handlers for every queued result until the buffer is closed
+func (r *Remex) deliverResults() {
+	defer close(r.resultsDone)
+
+	for result := range r.results {
+		r.deliverResult(result)
+	}
+}
+
+// deliverResult calls every registered handler with result
+func (r *Remex) deliverResult(result ExecResult) {
 	r.mutex.RLock()
-	defer r.mutex.RUnlock()
+	handlers := slices.Clone(r.handlers)
+	r.mutex.RUnlock()
 
-	for _, h := range r.handlers {
+	for _, h := range handlers {
 		r.logger.Debug("notifying handler", "ID", result.ID, "remote", result.RemoteAddr, "command", result.Command)
-		h(result)
+		if err := r.callHandler(h, result); err != nil {
+			r.logger.Warn("handler aborted execution", "ID", result.ID, "command", result.Command, "error", err)
+			r.cancel(fmt.Errorf("%w: %w", ErrAborted, err))
+		}
 	}
 }
 
-// Connect establishes SSH connections to all remote hosts
+// callHandler calls h with result, a panic in h is logged and recovered so the
+// remaining handlers still receive the result
+func (r *Remex) callHandler(h AbortHandler, result ExecResult) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			r.logger.Error("handler panicked", "ID", result.ID, "command", result.Command,
+				"panic", p, "stack", string(debug.Stack()))
+		}
+	}()
+
+	return h(result)
+}
+
+// Connect establishes SSH connections to all remote hosts in parallel
 func (r *Remex) Connect() error {
-	var connectionErrors []error
+	var (
+		connectionErrors []error
+		errMutex         sync.Mutex
+		result           = make(map[string]error)
 
-	for id, config := range r.configs {
-		select {
-		case <-r.ctx.Done():
-			return r.ctx.Err()
-		default:
-			client, err := r.newSSHClient(id, config)
+		g errgroup.Group
+	)
+
+	if r.connectConcurrency > 0 {
+		g.SetLimit(r.connectConcurrency)
+	}
+
+	r.mutex.RLock()
+	configs := maps.Clone(r.configs)
+	r.mutex.RUnlock()
+
+	for id, config := range configs {
+		if r.ctx.Err() != nil {
+			break
+		}
+
+		g.Go(func() error {
+			select {
+			case <-r.ctx.Done():
+				return r.ctx.Err()
+			default:
+			}
+
+			client, err := r.connectClient(id, config)
+			if err == nil && r.ctx.Err() != nil {
+				// 取消后才完成的连接不再保留，Connect 返回后不会多出连接
+				client.Close()
+				client, err = nil, context.Cause(r.ctx)
+			}
 			if err != nil {
 				r.logger.Error("failed to establish SSH connection",
-					"remote", config.Addr, "error", err)
+					"remote", config.remote(), "error", err)
+
+				errMutex.Lock()
+				connectionErrors = append(connectionErrors, fmt.Errorf("host %s (%s): %w", id, config.remote(), err))
+				result[id] = err
+				errMutex.Unlock()
 
-				connectionErrors = append(connectionErrors, fmt.Errorf("host %s (%s): %w", id, config.Addr, err))
-				r.notifyHandlers(ExecResult{ID: id, Stage: StageDisconnected, RemoteAddr: config.Addr, Error: err})
+				r.notifyHandlers(ExecResult{ID: id, Stage: StageDisconnected, RemoteAddr: config.remote(), Error: err})
 
-				continue
+				return nil
 			}
 
 			r.mutex.Lock()
@@ -138,86 +503,780 @@ func (r *Remex) Connect() error {
 			}
 
 			r.clients[id] = client
+			delete(r.disconnected, id)
 
 			r.mutex.Unlock()
 
-			r.notifyHandlers(ExecResult{ID: id, Stage: StageConnected, RemoteAddr: config.Addr})
-			r.logger.Info("SSH connection established", "remote", config.Addr)
+			errMutex.Lock()
+			result[id] = nil
+			errMutex.Unlock()
+
+			r.notifyHandlers(ExecResult{ID: id, Stage: StageConnected, RemoteAddr: config.remote()})
+			r.logger.Info("SSH connection established", "remote", config.remote())
+
+			return nil
+		})
+	}
+
+	err := g.Wait()
+
+	// 因取消而未尝试连接的主机记录为 context 错误
+	for id := range configs {
+		if _, ok := result[id]; !ok {
+			result[id] = context.Cause(r.ctx)
 		}
 	}
 
-	if len(r.clients) == 0 {
+	r.mutex.Lock()
+	r.connectResult = result
+	connected := len(r.clients)
+	r.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+
+	if connected == 0 {
 		return fmt.Errorf("no successful connections: %w", errors.Join(connectionErrors...))
 	}
 
 	r.logger.Info("connections established",
-		"successful", len(r.clients),
-		"total", len(r.configs))
+		"successful", connected,
+		"total", len(configs))
+
+	return nil
+}
+
+// ConnectResult returns the outcome of every host of the last Connect keyed by ID,
+// the error is nil for the hosts that connected. It returns nil before Connect.
+func (r *Remex) ConnectResult() map[string]error {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return maps.Clone(r.connectResult)
+}
+
+// connectClient connects to a remote host, retrying network errors as configured by
+// SSHConfig.MaxRetries and SSHConfig.RetryBackoff until the Remex context is done
+func (r *Remex) connectClient(id string, config *SSHConfig) (RemoteClient, error) {
+	for attempt := 0; ; attempt++ {
+		client, err := r.newSSHClient(r.ctx, id, config)
+		if err == nil || attempt >= config.MaxRetries || !isRetryableConnectError(err) {
+			return client, err
+		}
+
+		delay := config.retryDelay(attempt)
+		r.logger.Warn("SSH connection failed, retrying",
+			"remote", config.remote(), "attempt", attempt+1, "delay", delay, "error", err)
+
+		if waitErr := r.wait(delay); waitErr != nil {
+			return nil, fmt.Errorf("%w (last error: %w)", waitErr, err)
+		}
+	}
+}
+
+// wait sleeps for delay, it returns the context error when the Remex context is done first
+func (r *Remex) wait(delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// AddHost connects to a new remote host and registers it under id
+func (r *Remex) AddHost(id string, config *SSHConfig) error {
+	if id == "" {
+		return errors.New("host id cannot be empty")
+	}
+	if config == nil {
+		return errors.New("ssh config cannot be nil")
+	}
+
+	r.mutex.RLock()
+	_, exists := r.configs[id]
+	r.mutex.RUnlock()
+	if exists {
+		return fmt.Errorf("host %s already exists", id)
+	}
+
+	if r.secrets != nil {
+		r.secrets.addConfig(config)
+	}
+
+	client, err := r.connectClient(id, config)
+	if err != nil {
+		r.notifyHandlers(ExecResult{ID: id, Stage: StageDisconnected, RemoteAddr: config.remote(), Error: err})
+		return fmt.Errorf("host %s (%s): %w", id, config.remote(), err)
+	}
+
+	r.mutex.Lock()
+	// 连接期间可能已被并发添加
+	if _, exists := r.configs[id]; exists {
+		r.mutex.Unlock()
+		client.Close()
+		return fmt.Errorf("host %s already exists", id)
+	}
+	r.configs[id] = config
+	r.clients[id] = client
+	r.mutex.Unlock()
+
+	r.notifyHandlers(ExecResult{ID: id, Stage: StageConnected, RemoteAddr: config.remote()})
+	r.logger.Info("SSH connection established", "remote", config.remote())
+
+	return nil
+}
+
+// GetConfig returns a copy of the configuration stored for the host, use its String
+// or LogValue methods to display it without the credentials
+func (r *Remex) GetConfig(id string) (*SSHConfig, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	config, ok := r.configs[id]
+	if !ok {
+		return nil, false
+	}
+	return config.Clone(), true
+}
+
+// UpdateConfig replaces the configuration of an existing host with a copy of config.
+// The current connection is kept, the update takes effect on the next Connect or Reconnect.
+func (r *Remex) UpdateConfig(id string, config *SSHConfig) error {
+	if config == nil {
+		return errors.New("ssh config cannot be nil")
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.configs[id]; !ok {
+		return fmt.Errorf("no config found for id %s", id)
+	}
+	if r.secrets != nil {
+		r.secrets.addConfig(config)
+	}
+	r.configs[id] = config.Clone()
+
+	return nil
+}
+
+// Reconnect closes the current connection to a remote host, if any, and dials it again using its stored configuration
+func (r *Remex) Reconnect(id string) error {
+	r.mutex.Lock()
+	config, ok := r.configs[id]
+	stale, connected := r.clients[id]
+	delete(r.clients, id)
+	r.mutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("no config found for id %s", id)
+	}
+	if connected {
+		if err := stale.Close(); err != nil {
+			r.logger.Warn("failed to close stale client", "id", id, "error", err)
+		}
+	}
+
+	client, err := r.connectClient(id, config)
+	if err != nil {
+		r.logger.Error("failed to establish SSH connection",
+			"remote", config.remote(), "error", err)
+		r.notifyHandlers(ExecResult{ID: id, Stage: StageDisconnected, RemoteAddr: config.remote(), Error: err})
+		return fmt.Errorf("host %s (%s): %w", id, config.remote(), err)
+	}
+
+	r.mutex.Lock()
+	previous, replaced := r.clients[id]
+	_, exists := r.configs[id]
+	if exists {
+		r.clients[id] = client
+		delete(r.disconnected, id)
+	}
+	r.mutex.Unlock()
+
+	// 重连期间主机被移除或被并发重连
+	if !exists {
+		client.Close()
+		return fmt.Errorf("host %s was removed while reconnecting", id)
+	}
+	if replaced {
+		previous.Close()
+	}
+
+	r.notifyHandlers(ExecResult{ID: id, Stage: StageConnected, RemoteAddr: config.remote()})
+	r.logger.Info("SSH connection re-established", "remote", config.remote())
+
+	return nil
+}
+
+// RemoveHost closes the connection to a remote host and forgets its configuration
+func (r *Remex) RemoveHost(id string) error {
+	r.mutex.Lock()
+	_, exists := r.configs[id]
+	client, connected := r.clients[id]
+	delete(r.configs, id)
+	delete(r.clients, id)
+	delete(r.disconnected, id)
+	r.mutex.Unlock()
+
+	if !exists && !connected {
+		return fmt.Errorf("no config found for id %s", id)
+	}
+	if connected {
+		if err := client.Close(); err != nil {
+			return fmt.Errorf("failed to close client %s: %w", id, err)
+		}
+	}
 
 	return nil
 }
 
 // ExecuteWithID executes commands on a specific remote host identified by its ID
 func (r *Remex) ExecuteWithID(id string, command string) (string, error) {
-	client, ok := r.clients[id]
-	if !ok {
-		return "", fmt.Errorf("no client found for id %s", id)
+	return r.ExecuteWithIDContext(r.ctx, id, command)
+}
+
+// ExecuteWithIDContext executes a command on the host with the given ID like
+// ExecuteWithID. It stops when ctx or the Remex context is done and its span is
+// a child of the span in ctx, see WithTracer.
+func (r *Remex) ExecuteWithIDContext(ctx context.Context, id string, command string) (string, error) {
+	done, err := r.startExecution()
+	if err != nil {
+		return "", err
+	}
+	defer done()
+
+	client, ok := r.GetClientByID(id)
+	if !ok && (r.lazyConnect || r.reconnectable(id)) {
+		if client, err = r.connectGuarded(id); err != nil {
+			return "", err
+		}
+	} else if !ok {
+		return "", fmt.Errorf("%w %s", ErrNoClientForID, id)
 	}
 
 	r.logger.Debug("executing commands", "id", id, "remote", client.RemoteAddr())
 
-	return client.ExecuteCommand(r.ctx, command)
+	ctx, cancel := r.withEngineContext(ctx)
+	defer cancel()
+
+	ctx, span := r.startSpan(ctx, "remex.ExecuteWithID", append(hostAttributes(client), attrCommand.String(command))...)
+
+	if err := r.checkPolicy(client, []string{command}); err != nil {
+		endSpan(span, err)
+		return "", err
+	}
+	if ctx.Err() != nil {
+		err := context.Cause(ctx)
+		endSpan(span, err)
+		return "", err
+	}
+	if err := r.breaker.allow(id); err != nil {
+		endSpan(span, err)
+		return "", err
+	}
+
+	output, err := executeCommandOutput(ctx, client, command)
+	r.recordBreaker(ctx, id, err)
+	span.SetAttributes(attrExitCode.Int(output.ExitCode))
+	if err != nil {
+		disconnected := r.checkDisconnected(ctx, client, err)
+		err = newCommandError(client, command, output, err)
+		if disconnected {
+			err = fmt.Errorf("%w: %w", ErrHostDisconnected, err)
+		}
+	}
+	endSpan(span, err)
+	return output.Combined, err
 }
 
 // Execute executes commands on all connected remote hosts
 func (r *Remex) Execute(commands []string) error {
-	for id, client := range r.clients {
-		r.logger.Debug("executing commands", "id", id, "remote", client.RemoteAddr())
+	return r.execute(r.ctx, r.targetClients(), commands, nil)
+}
+
+// ExecuteContext executes commands on all connected remote hosts like Execute.
+// It stops when ctx or the Remex context is done and its span is a child of the
+// span in ctx, see WithTracer.
+func (r *Remex) ExecuteContext(ctx context.Context, commands []string) error {
+	return r.execute(ctx, r.targetClients(), commands, nil)
+}
 
-		commands = strings.Split(fasttemplate.ExecuteString(strings.Join(commands, "\n"), "{{", "}}", map[string]any{
-			remexID: id,
-		}), "\n")
+// targetClients returns the clients of all hosts, in lazy mode the hosts not
+// connected yet are included with a nil client, see connectLazy
+func (r *Remex) targetClients() map[string]RemoteClient {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
 
-		r.errGroup.Go(func() error {
-			return r.execCommands(client, commands)
+	clients := maps.Clone(r.clients)
+	if clients == nil {
+		clients = make(map[string]RemoteClient)
+	}
+	for id := range r.configs {
+		if _, ok := clients[id]; !ok && r.executableLocked(id) {
+			clients[id] = nil
+		}
+	}
+	return clients
+}
+
+// executableLocked reports whether the host with the given ID is connected or
+// connected by the next execution, for callers holding r.mutex
+func (r *Remex) executableLocked(id string) bool {
+	if _, ok := r.clients[id]; ok {
+		return true
+	}
+	_, ok := r.configs[id]
+	return ok && (r.lazyConnect || r.reconnectableLocked(id))
+}
+
+// connectLazy connects the hosts of clients with a nil client in parallel and
+// stores their clients. The hosts failing to connect or skipped by the circuit
+// breaker are removed from clients and their errors are returned keyed by ID.
+func (r *Remex) connectLazy(clients map[string]RemoteClient) map[string]error {
+	var (
+		failures = make(map[string]error)
+		mutex    sync.Mutex
+
+		g errgroup.Group
+	)
+
+	if r.connectConcurrency > 0 {
+		g.SetLimit(r.connectConcurrency)
+	}
+
+	var missing []string
+	for id, client := range clients {
+		if client == nil {
+			missing = append(missing, id)
+		}
+	}
+
+	for _, id := range missing {
+		g.Go(func() error {
+			client, err := r.connectGuarded(id)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				failures[id] = err
+				delete(clients, id)
+			} else {
+				clients[id] = client
+			}
+			return nil
 		})
 	}
 
-	if err := r.errGroup.Wait(); err != nil {
+	g.Wait()
+	return failures
+}
+
+// sortedErrors returns the errors keyed by host ID in ID order
+func sortedErrors(errs map[string]error) []error {
+	sorted := make([]error, 0, len(errs))
+	for _, id := range slices.Sorted(maps.Keys(errs)) {
+		sorted = append(sorted, errs[id])
+	}
+	return sorted
+}
+
+// connectHost returns the client of the host with the given ID, connecting and
+// caching it first when it is not connected yet
+func (r *Remex) connectHost(id string) (RemoteClient, error) {
+	r.mutex.RLock()
+	config, ok := r.configs[id]
+	client, connected := r.clients[id]
+	r.mutex.RUnlock()
+
+	if connected {
+		return client, nil
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrNoClientForID, id)
+	}
+
+	client, err := r.connectClient(id, config)
+	if err != nil {
+		r.logger.Error("failed to establish SSH connection",
+			"remote", config.remote(), "error", err)
+		r.notifyHandlers(ExecResult{ID: id, Stage: StageDisconnected, RemoteAddr: config.remote(), Error: err})
+		return nil, fmt.Errorf("host %s (%s): %w", id, config.remote(), err)
+	}
+
+	r.mutex.Lock()
+	existing, connected := r.clients[id]
+	_, exists := r.configs[id]
+	if exists && !connected {
+		r.clients[id] = client
+		delete(r.disconnected, id)
+	}
+	r.mutex.Unlock()
+
+	// 连接期间主机被移除或被并发连接
+	if !exists {
+		client.Close()
+		return nil, fmt.Errorf("host %s was removed while connecting", id)
+	}
+	if connected {
+		client.Close()
+		return existing, nil
+	}
+
+	r.notifyHandlers(ExecResult{ID: id, Stage: StageConnected, RemoteAddr: config.remote()})
+	r.logger.Info("SSH connection established", "remote", config.remote())
+
+	return client, nil
+}
+
+// ExecuteOn executes commands on the connected remote hosts with the given IDs.
+// Nothing is executed when any ID has no connected client.
+func (r *Remex) ExecuteOn(ids []string, commands []string) error {
+	r.mutex.RLock()
+	clients := make(map[string]RemoteClient, len(ids))
+	var unknown []string
+	for _, id := range ids {
+		if client, ok := r.clients[id]; ok {
+			clients[id] = client
+		} else if r.executableLocked(id) {
+			clients[id] = nil
+		} else if !slices.Contains(unknown, id) {
+			unknown = append(unknown, id)
+		}
+	}
+	r.mutex.RUnlock()
+
+	if len(unknown) > 0 {
+		return fmt.Errorf("%w: %s", ErrNoClientForID, strings.Join(unknown, ", "))
+	}
+
+	return r.execute(r.ctx, clients, commands, nil)
+}
+
+// RetryFailed executes commands again on the hosts that failed in the last batch
+// reported by Stats, or on the given IDs when any are passed. Before executing it
+// waits for the first retry delay of the hosts, see SSHConfig.RetryBackoff.
+// Nothing is executed and nil is returned when there is no failed host.
+func (r *Remex) RetryFailed(commands []string, ids ...string) error {
+	if len(ids) == 0 {
+		stats, _ := r.Stats()
+		ids = stats.Failed
+	}
+	if len(ids) == 0 {
+		r.logger.Debug("no failed hosts to retry")
+		return nil
+	}
+
+	var delay time.Duration
+	r.mutex.RLock()
+	for _, id := range ids {
+		if config, ok := r.configs[id]; ok {
+			delay = max(delay, config.retryDelay(0))
+		}
+	}
+	r.mutex.RUnlock()
+
+	r.logger.Info("retrying failed hosts", "ids", ids, "delay", delay)
+
+	if err := r.wait(delay); err != nil {
 		return err
 	}
+	return r.ExecuteOn(ids, commands)
+}
 
-	return nil
+// ExecuteOnTag executes commands on the connected remote hosts whose SSHConfig has the given tag
+func (r *Remex) ExecuteOnTag(tag string, commands []string) error {
+	var ids []string
+
+	r.mutex.RLock()
+	for id, config := range r.configs {
+		if r.executableLocked(id) && config.HasTag(tag) {
+			ids = append(ids, id)
+		}
+	}
+	r.mutex.RUnlock()
+
+	if len(ids) == 0 {
+		return fmt.Errorf("no connected hosts with tag %q", tag)
+	}
+
+	slices.Sort(ids)
+	return r.ExecuteOn(ids, commands)
+}
+
+// ExecuteCollect executes commands on all connected remote hosts like Execute and
+// returns the finished results of every host keyed by ID, in command order.
+// On failure the results collected so far are returned together with the error.
+func (r *Remex) ExecuteCollect(commands []string) (map[string][]ExecResult, error) {
+	clients := r.targetClients()
+
+	var (
+		results     = make(map[string][]ExecResult, len(clients))
+		resultMutex sync.Mutex
+	)
+
+	err := r.execute(r.ctx, clients, commands, func(result ExecResult) {
+		resultMutex.Lock()
+		defer resultMutex.Unlock()
+		results[result.ID] = append(results[result.ID], result)
+	})
+
+	return results, err
+}
+
+// execute runs commands on the given clients in parallel until ctx is done, passing
+// every finished result to collect when it is not nil
+func (r *Remex) execute(ctx context.Context, clients map[string]RemoteClient, commands []string, collect ResultHandler) error {
+	done, err := r.startExecution()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ctx, cancel := r.withEngineContext(ctx)
+	defer cancel()
+
+	ctx, span := r.startSpan(ctx, "remex.Execute", attrHosts.Int(len(clients)), attrCommands.Int(len(commands)))
+
+	stats := newStatsRecorder()
+	connectErrs := r.connectLazy(clients)
+	r.keepDisconnected(connectErrs)
+	for id, err := range connectErrs {
+		stats.done(id, err)
+	}
+	hostCommands := r.renderHostCommands(clients, commands)
+
+	var g errgroup.Group
+	for id, client := range clients {
+		r.logger.Debug("executing commands", "id", id, "remote", client.RemoteAddr())
+
+		g.Go(func() error {
+			err := r.execCommands(ctx, client, hostCommands[id], stats.collect(collect))
+			stats.done(id, err)
+			if errors.Is(err, ErrHostDisconnected) {
+				return nil
+			}
+			return err
+		})
+	}
+
+	err = g.Wait()
+	if len(connectErrs) > 0 {
+		err = errors.Join(append(sortedErrors(connectErrs), err)...)
+	}
+	r.recordStats(stats)
+	endSpan(span, err)
+	return err
+}
+
+// startExecution registers a running execution for Shutdown to wait for, the
+// returned function marks it as done. It fails with ErrClosed once closing.
+func (r *Remex) startExecution() (func(), error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closing {
+		return nil, ErrClosed
+	}
+	r.executions.Add(1)
+	return r.executions.Done, nil
+}
+
+// ExecuteBestEffort executes commands on all connected remote hosts without
+// stopping at the first failing host. It returns the error of every failed host
+// keyed by ID, so the failures can be retried, together with their errors.Join.
+func (r *Remex) ExecuteBestEffort(commands []string) (map[string]error, error) {
+	done, err := r.startExecution()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	clients := r.targetClients()
+
+	var (
+		failures = make(map[string]error)
+		errMutex sync.Mutex
+
+		g errgroup.Group
+	)
+
+	ctx, span := r.startSpan(r.ctx, "remex.ExecuteBestEffort", attrHosts.Int(len(clients)), attrCommands.Int(len(commands)))
+
+	stats := newStatsRecorder()
+	connectErrs := r.connectLazy(clients)
+	r.keepDisconnected(connectErrs)
+	for id, err := range connectErrs {
+		stats.done(id, err)
+		failures[id] = err
+	}
+	hostCommands := r.renderHostCommands(clients, commands)
+
+	for id, client := range clients {
+		r.logger.Debug("executing commands", "id", id, "remote", client.RemoteAddr())
+
+		g.Go(func() error {
+			err := r.execCommands(ctx, client, hostCommands[id], stats.collect(nil))
+			stats.done(id, err)
+			if err != nil && !errors.Is(err, ErrHostDisconnected) {
+				errMutex.Lock()
+				failures[id] = err
+				errMutex.Unlock()
+			}
+			return nil
+		})
+	}
+
+	g.Wait()
+	r.recordStats(stats)
+
+	if len(failures) == 0 {
+		endSpan(span, nil)
+		return failures, nil
+	}
+
+	errs := make([]error, 0, len(failures))
+	for _, id := range slices.Sorted(maps.Keys(failures)) {
+		err := failures[id]
+		var cmdErr *CommandError
+		if _, connectErr := connectErrs[id]; !connectErr && !errors.As(err, &cmdErr) {
+			err = fmt.Errorf("host %s: %w", id, err)
+		}
+		errs = append(errs, err)
+	}
+
+	r.logger.Warn("command execution failed on some hosts",
+		"failed", len(failures),
+		"total", len(clients)+len(connectErrs))
+
+	err = errors.Join(errs...)
+	endSpan(span, err)
+	return failures, err
+}
+
+// renderHostCommands renders commands for every client, REMEX_INDEX numbers
+// the hosts from zero in ID order
+func (r *Remex) renderHostCommands(clients map[string]RemoteClient, commands []string) map[string][]string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	rendered := make(map[string][]string, len(clients))
+	for index, id := range slices.Sorted(maps.Keys(clients)) {
+		addr := clients[id].RemoteAddr()
+
+		// 内置变量优先于调用方提供的变量
+		data := make(map[string]any, len(r.templateVars[id])+5)
+		for k, v := range r.templateVars[id] {
+			switch v.(type) {
+			case string, []byte, fasttemplate.TagFunc:
+				data[k] = v
+			default:
+				data[k] = fmt.Sprint(v)
+			}
+		}
+		data[remexID] = id
+		data[remexAddr] = addr.Addr().String()
+		data[remexPort] = strconv.Itoa(int(addr.Port()))
+		data[remexIndex] = strconv.Itoa(index)
+		if config, ok := r.configs[id]; ok {
+			data[remexUser] = config.Username
+		}
+
+		rendered[id] = renderCommands(commands, data)
+	}
+	return rendered
+}
+
+// renderCommands returns a copy of commands with the template placeholders replaced
+func renderCommands(commands []string, data map[string]any) []string {
+	rendered := make([]string, len(commands))
+	for i, command := range commands {
+		rendered[i] = fasttemplate.ExecuteString(command, "{{", "}}", data)
+	}
+	return rendered
+}
+
+// execCommands executes all commands on a single remote host, passing every finished result to collect when it is not nil
+func (r *Remex) execCommands(ctx context.Context, client RemoteClient, commands []string, collect ResultHandler) error {
+	return r.execSteps(ctx, client, commands, r.executeCommand, collect)
 }
 
-// executeCommands executes all commands on a single remote host
-func (r *Remex) execCommands(client RemoteClient, commands []string) error {
+// stepFunc runs a single command of execSteps on client
+type stepFunc func(ctx context.Context, client RemoteClient, command string) (CommandOutput, error)
+
+// execSteps runs commands on a single remote host with run like execCommands: the
+// commands are checked by the policy and the circuit breaker, run in an execution
+// slot and reported to the handlers, spans and metrics
+func (r *Remex) execSteps(ctx context.Context, client RemoteClient, commands []string, run stepFunc, collect ResultHandler) (err error) {
 	var (
 		remoteAddr = client.RemoteAddr()
 		logger     = r.logger.With("id", client.ID(), "remote", remoteAddr)
 	)
 
+	// 执行前检查全部命令，避免只执行了一部分
+	if err := r.checkPolicy(client, commands); err != nil {
+		logger.Warn("command blocked by policy", "error", err)
+		return err
+	}
+	if err := r.breaker.allow(client.ID()); err != nil {
+		logger.Warn("host skipped by circuit breaker", "error", err)
+		return err
+	}
+	defer func() { r.recordBreaker(ctx, client.ID(), err) }()
+
+	release, err := r.acquireSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	for _, command := range commands {
 		select {
-		case <-r.ctx.Done():
-			return r.ctx.Err()
+		case <-ctx.Done():
+			return context.Cause(ctx)
 		default:
 			logger.Info("executing command", "command", command)
 
-			r.notifyHandlers(ExecResult{Command: command, ID: client.ID(), Stage: StageStart, RemoteAddr: remoteAddr})
+			start := time.Now()
+			r.notifyHandlers(ExecResult{Command: command, ID: client.ID(), Stage: StageStart, RemoteAddr: remoteAddr, Time: start})
 
-			output, err := client.ExecuteCommand(r.ctx, command)
+			cmdCtx, span := r.startSpan(ctx, "remex.command", append(hostAttributes(client), attrCommand.String(command))...)
+			output, err := run(cmdCtx, client, command)
+			finish := time.Now()
+			span.SetAttributes(attrExitCode.Int(output.ExitCode))
+			endSpan(span, err)
+			if r.metrics != nil {
+				r.metrics.OnCommandComplete(client.ID(), err == nil, finish.Sub(start))
+			}
 
-			r.notifyHandlers(ExecResult{Command: command, ID: client.ID(), Stage: StageFinish, RemoteAddr: remoteAddr,
-				Output: output, Error: err})
+			result := ExecResult{Command: command, ID: client.ID(), Stage: StageFinish, RemoteAddr: remoteAddr,
+				Output: output.Combined, Stdout: output.Stdout, Stderr: output.Stderr,
+				ExitCode: output.ExitCode, Signal: output.Signal, Truncated: output.Truncated, Error: err,
+				Duration: finish.Sub(start), Time: finish}
+
+			r.notifyHandlers(result)
+			if collect != nil {
+				collect(result)
+			}
 
 			if err != nil {
-				logger.Error("failed to execute command", "command", command, "error", err, "output", output)
+				logger.Error("failed to execute command", "command", command, "duration", result.Duration, "error", err, "output", output.Combined)
 
-				return fmt.Errorf("failed to execute command %q: %w", command, err)
+				if r.checkDisconnected(ctx, client, err) {
+					return fmt.Errorf("%w: %w", ErrHostDisconnected, newCommandError(client, command, output, err))
+				}
+				return newCommandError(client, command, output, err)
 			}
 
-			logger.Info("command done", "command", command, "output", output)
+			logger.Info("command done", "command", command, "duration", result.Duration, "output", output.Combined)
 		}
 	}
 
@@ -225,6 +1284,125 @@ func (r *Remex) execCommands(client RemoteClient, commands []string) error {
 	return nil
 }
 
+// recordBreaker counts the outcome of an execution on the host for the circuit
+// breaker, executions interrupted by the cancellation of ctx are not counted
+func (r *Remex) recordBreaker(ctx context.Context, id string, err error) {
+	if ctx.Err() != nil {
+		r.breaker.abandon(id)
+		return
+	}
+	r.breaker.record(id, err)
+}
+
+// acquireSlot waits for a free execution slot of WithMaxConcurrency, the returned
+// function releases it
+func (r *Remex) acquireSlot(ctx context.Context) (func(), error) {
+	if r.executeSlots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case r.executeSlots <- struct{}{}:
+		return func() { <-r.executeSlots }, nil
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+}
+
+// checkPolicy returns a *CommandError wrapping ErrCommandBlocked for the first command rejected by the policy
+func (r *Remex) checkPolicy(client RemoteClient, commands []string) error {
+	if r.policy == nil {
+		return nil
+	}
+	for _, command := range commands {
+		if err := r.policy.check(command); err != nil {
+			return newCommandError(client, command, CommandOutput{ExitCode: -1}, err)
+		}
+	}
+	return nil
+}
+
+// executeCommand executes a single command, killing it when the per-command timeout expires
+func (r *Remex) executeCommand(ctx context.Context, client RemoteClient, command string) (CommandOutput, error) {
+	parent := ctx
+	if r.streamOutput {
+		remoteAddr := client.RemoteAddr()
+		ctx = WithLineHandler(ctx, func(stream Stream, line string) {
+			result := ExecResult{Command: command, ID: client.ID(), Stage: StageOutput, RemoteAddr: remoteAddr, Output: line}
+			if stream == StreamStderr {
+				result.Stderr = line
+			} else {
+				result.Stdout = line
+			}
+			r.notifyHandlers(result)
+		})
+	}
+
+	if r.commandTimeout <= 0 {
+		return executeCommandOutput(ctx, client, command)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.commandTimeout)
+	defer cancel()
+
+	output, err := executeCommandOutput(ctx, client, command)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && parent.Err() == nil {
+		err = &CommandTimeoutError{Command: command, Timeout: r.commandTimeout, Err: err}
+	}
+	return output, err
+}
+
+// executeCommandOutput executes a command, capturing stdout and stderr separately when the client supports it
+func executeCommandOutput(ctx context.Context, client RemoteClient, command string) (CommandOutput, error) {
+	if executor, ok := client.(OutputExecutor); ok {
+		return executor.ExecuteCommandOutput(ctx, command)
+	}
+
+	output, err := client.ExecuteCommand(ctx, command)
+	exitCode, signal := exitStatus(err)
+	return CommandOutput{Stdout: output, Combined: output, ExitCode: exitCode, Signal: signal}, err
+}
+
+// HealthCheck pings all connected clients in parallel and returns the result keyed by ID,
+// a nil error means the host answered. Clients that do not implement Pinger are reported as alive.
+func (r *Remex) HealthCheck() map[string]error {
+	r.mutex.RLock()
+	clients := maps.Clone(r.clients)
+	r.mutex.RUnlock()
+
+	var (
+		results     = make(map[string]error, len(clients))
+		resultMutex sync.Mutex
+
+		g errgroup.Group
+	)
+
+	for id, client := range clients {
+		pinger, ok := client.(Pinger)
+		if !ok {
+			resultMutex.Lock()
+			results[id] = nil
+			resultMutex.Unlock()
+			continue
+		}
+
+		g.Go(func() error {
+			err := pinger.Ping(r.ctx)
+			if err != nil {
+				r.logger.Warn("health check failed", "id", id, "remote", client.RemoteAddr(), "error", err)
+			}
+
+			resultMutex.Lock()
+			results[id] = err
+			resultMutex.Unlock()
+			return nil
+		})
+	}
+
+	g.Wait()
+	return results
+}
+
 // GetConnectedHosts returns the list of currently connected hosts
 func (r *Remex) GetConnectedHosts() map[string]string {
 	r.mutex.RLock()
@@ -259,14 +1437,88 @@ func (r *Remex) GetClientByID(id string) (RemoteClient, bool) {
 	return nil, false
 }
 
-// Close closes all SSH connections and cleans up resources
+// Close stops accepting new executions and closes all SSH connections right away,
+// which kills the running commands, use Shutdown to let them finish first.
+// Calling Close again, or after Shutdown, returns the result of the first call.
 func (r *Remex) Close() error {
-	if err := r.errGroup.Wait(); err != nil {
-		return err
+	r.stopExecutions()
+	return r.closeClients()
+}
+
+// Shutdown stops accepting new executions, waits for the running ones to finish and
+// then closes all SSH connections. With WithResultBuffer it also waits until the
+// handlers received the queued results. When ctx is done before the executions finish,
+// the connections are closed anyway, killing the remaining commands, and the
+// context error is returned.
+func (r *Remex) Shutdown(ctx context.Context) error {
+	r.stopExecutions()
+	r.logger.Info("shutting down, waiting for running executions")
+
+	finished := make(chan struct{})
+	go func() {
+		r.executions.Wait()
+		close(finished)
+	}()
+
+	var waitErr error
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		waitErr = ctx.Err()
+		r.logger.Warn("shutdown deadline reached, closing connections of running executions", "error", waitErr)
+	}
+
+	closeErr := r.closeClients()
+
+	if r.resultsDone != nil && waitErr == nil {
+		select {
+		case <-r.resultsDone:
+		case <-ctx.Done():
+			waitErr = ctx.Err()
+			r.logger.Warn("shutdown deadline reached before the handlers received every result", "error", waitErr)
+		}
 	}
 
+	return errors.Join(waitErr, closeErr)
+}
+
+// stopExecutions makes every execution started from now on fail with ErrClosed
+func (r *Remex) stopExecutions() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.closing = true
+}
+
+// closeClients closes all SSH connections once, later calls return the result of the first
+func (r *Remex) closeClients() error {
+	r.closeOnce.Do(func() {
+		r.closeErr = r.closeAllClients()
+		r.closeResults()
+	})
+	return r.closeErr
+}
+
+// closeResults closes the result buffer, the delivery goroutine still delivers the queued results
+func (r *Remex) closeResults() {
+	if r.results == nil {
+		return
+	}
+
+	r.resultsMutex.Lock()
+	defer r.resultsMutex.Unlock()
+
+	r.resultsClosed = true
+	close(r.results)
+}
+
+// closeAllClients closes all SSH connections
+func (r *Remex) closeAllClients() error {
+	r.mutex.RLock()
+	clients := maps.Clone(r.clients)
+	r.mutex.RUnlock()
+
 	var closeErrors []error
-	for _, client := range r.clients {
+	for _, client := range clients {
 		if err := client.Close(); err != nil {
 			closeErrors = append(closeErrors, err)
 		}
@@ -1,10 +1,36 @@
 package remex
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
 	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 // mockAddr 是一个模拟的 fmt.Stringer 接口实现，用于测试
@@ -174,3 +200,2761 @@ func TestNewSSHConfig(t *testing.T) {
 		})
 	}
 }
+
+// TestSSHConfig_AuthMethods 测试私钥认证方式的解析
+func TestSSHConfig_AuthMethods(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatalf("MarshalPrivateKey() error = %v", err)
+	}
+	encryptedBlock, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("secret"))
+	if err != nil {
+		t.Fatalf("MarshalPrivateKeyWithPassphrase() error = %v", err)
+	}
+
+	challenge := func(string, string, []string, []bool) ([]string, error) {
+		return nil, nil
+	}
+	withChallenge := func(config *SSHConfig) *SSHConfig {
+		config.KeyboardInteractive = challenge
+		return config
+	}
+
+	testCases := []struct {
+		name        string
+		config      *SSHConfig
+		shouldError bool
+		wantErr     error
+		wantMethods int
+	}{
+		{
+			name:   "密码认证",
+			config: NewSSHConfig(netip.MustParseAddr("192.168.1.1"), "testuser", "testpass"),
+		},
+		{
+			name:        "密码与键盘交互认证",
+			config:      withChallenge(NewSSHConfig(netip.MustParseAddr("192.168.1.1"), "testuser", "testpass")),
+			wantMethods: 2,
+		},
+		{
+			name:   "仅键盘交互认证",
+			config: withChallenge(NewSSHConfig(netip.MustParseAddr("192.168.1.1"), "testuser", "")),
+		},
+		{
+			name:   "私钥认证且密码为空",
+			config: NewSSHConfigWithKey(netip.MustParseAddr("192.168.1.1"), "testuser", pem.EncodeToMemory(block)),
+		},
+		{
+			name:        "无效私钥",
+			config:      NewSSHConfigWithKey(netip.MustParseAddr("192.168.1.1"), "testuser", []byte("invalid pem")),
+			shouldError: true,
+		},
+		{
+			name: "加密私钥",
+			config: NewSSHConfigWithEncryptedKey(netip.MustParseAddr("192.168.1.1"), "testuser",
+				pem.EncodeToMemory(encryptedBlock), []byte("secret")),
+		},
+		{
+			name: "加密私钥密码错误",
+			config: NewSSHConfigWithEncryptedKey(netip.MustParseAddr("192.168.1.1"), "testuser",
+				pem.EncodeToMemory(encryptedBlock), []byte("wrong")),
+			shouldError: true,
+			wantErr:     ErrIncorrectPassphrase,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			auth, err := tc.config.authMethods()
+			if tc.shouldError {
+				if err == nil || !strings.Contains(err.Error(), "failed to parse private key") {
+					t.Errorf("authMethods() error = %v, want parse error", err)
+				}
+				if tc.wantErr != nil && !errors.Is(err, tc.wantErr) {
+					t.Errorf("authMethods() error = %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("authMethods() unexpected error = %v", err)
+			}
+			wantMethods := max(tc.wantMethods, 1)
+			if len(auth) != wantMethods {
+				t.Errorf("authMethods() returned %d methods, want %d", len(auth), wantMethods)
+			}
+		})
+	}
+}
+
+// TestSSHConfig_HostKeyCallback 测试 known_hosts 主机密钥校验
+func TestSSHConfig_HostKeyCallback(t *testing.T) {
+	newKey := func() ssh.PublicKey {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("GenerateKey() error = %v", err)
+		}
+		key, err := ssh.NewPublicKey(pub)
+		if err != nil {
+			t.Fatalf("NewPublicKey() error = %v", err)
+		}
+		return key
+	}
+
+	knownKey, otherKey := newKey(), newKey()
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{"192.168.1.1:22"}, knownKey) + "\n"
+	if err := os.WriteFile(knownHostsPath, []byte(line), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	testCases := []struct {
+		name        string
+		hostname    string
+		key         ssh.PublicKey
+		insecure    bool
+		shouldError bool
+		unknown     bool
+	}{
+		{name: "已知主机", hostname: "192.168.1.1:22", key: knownKey},
+		{name: "未知主机", hostname: "192.168.1.2:22", key: knownKey, shouldError: true, unknown: true},
+		{name: "主机密钥变更", hostname: "192.168.1.1:22", key: otherKey, shouldError: true},
+		{name: "显式忽略校验", hostname: "192.168.1.2:22", key: otherKey, insecure: true},
+	}
+
+	t.Run("自定义回调", func(t *testing.T) {
+		var called bool
+		config := NewSSHConfig(netip.MustParseAddr("192.168.1.1"), "testuser", "testpass")
+		config.KnownHostsPath = filepath.Join(t.TempDir(), "missing")
+		config.HostKeyCallback = func(string, net.Addr, ssh.PublicKey) error {
+			called = true
+			return nil
+		}
+
+		callback, err := config.hostKeyCallback()
+		if err != nil {
+			t.Fatalf("hostKeyCallback() error = %v", err)
+		}
+		if err := callback("192.168.1.1:22", nil, otherKey); err != nil || !called {
+			t.Errorf("custom callback not used, called = %v, error = %v", called, err)
+		}
+	})
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := NewSSHConfig(netip.MustParseAddr("192.168.1.1"), "testuser", "testpass")
+			config.KnownHostsPath = knownHostsPath
+			config.InsecureIgnoreHostKey = tc.insecure
+
+			callback, err := config.hostKeyCallback()
+			if err != nil {
+				t.Fatalf("hostKeyCallback() error = %v", err)
+			}
+
+			remote, _ := net.ResolveTCPAddr("tcp", tc.hostname)
+			err = callback(tc.hostname, remote, tc.key)
+			if !tc.shouldError {
+				if err != nil {
+					t.Errorf("callback() unexpected error = %v", err)
+				}
+				return
+			}
+
+			var hostKeyErr *HostKeyError
+			if !errors.As(err, &hostKeyErr) {
+				t.Fatalf("callback() error = %v, want *HostKeyError", err)
+			}
+			if hostKeyErr.Unknown() != tc.unknown {
+				t.Errorf("HostKeyError.Unknown() = %v, want %v", hostKeyErr.Unknown(), tc.unknown)
+			}
+		})
+	}
+}
+
+// TestSSHConfig_ConnectTimeout 测试连接超时的默认值
+func TestSSHConfig_ConnectTimeout(t *testing.T) {
+	config := NewSSHConfig(netip.MustParseAddr("192.168.1.1"), "testuser", "testpass")
+	if got := config.connectTimeout(); got != DefaultConnectTimeout {
+		t.Errorf("connectTimeout() = %v, want %v", got, DefaultConnectTimeout)
+	}
+
+	// 修改包级默认值对已创建的配置生效
+	defer func(timeout time.Duration) { DefaultConnectTimeout = timeout }(DefaultConnectTimeout)
+	DefaultConnectTimeout = 10 * time.Second
+	if got := config.connectTimeout(); got != 10*time.Second {
+		t.Errorf("connectTimeout() = %v, want %v", got, 10*time.Second)
+	}
+
+	config.ConnectTimeout = time.Second
+	if got := config.connectTimeout(); got != time.Second {
+		t.Errorf("connectTimeout() = %v, want %v", got, time.Second)
+	}
+}
+
+// mockClient 是一个模拟的 RemoteClient 实现，用于测试
+type mockClient struct {
+	id   string
+	addr netip.AddrPort
+
+	execute func(ctx context.Context, cmd string) (string, error)
+	ping    func(ctx context.Context) error
+	closed  atomic.Bool
+	// closes 记录 Close 的调用次数，closeErr 为 Close 的返回值
+	closes   atomic.Int32
+	closeErr error
+}
+
+func (m *mockClient) ID() string {
+	return m.id
+}
+
+func (m *mockClient) RemoteAddr() netip.AddrPort {
+	return m.addr
+}
+
+func (m *mockClient) ExecuteCommand(ctx context.Context, cmd string) (string, error) {
+	if m.execute == nil {
+		return cmd, nil
+	}
+	return m.execute(ctx, cmd)
+}
+
+func (m *mockClient) Ping(ctx context.Context) error {
+	if m.ping == nil {
+		return nil
+	}
+	return m.ping(ctx)
+}
+
+func (m *mockClient) Close() error {
+	m.closed.Store(true)
+	m.closes.Add(1)
+	return m.closeErr
+}
+
+// newMockRemex 创建一个使用模拟客户端的 Remex 实例
+func newMockRemex(t *testing.T, ids []string, newClient func(string, *SSHConfig) (RemoteClient, error), opts ...Option) *Remex {
+	t.Helper()
+
+	configs := make(map[string]*SSHConfig, len(ids))
+	for i, id := range ids {
+		configs[id] = NewSSHConfig(netip.AddrFrom4([4]byte{192, 168, 1, byte(i + 1)}), "testuser", "testpass")
+	}
+
+	if newClient == nil {
+		newClient = func(id string, config *SSHConfig) (RemoteClient, error) {
+			return &mockClient{id: id, addr: netip.AddrPortFrom(config.Addr, config.Port)}, nil
+		}
+	}
+
+	r := NewWithContext(context.Background(), slog.New(slog.DiscardHandler), configs, opts...)
+	r.setNewSSHClient(newClient)
+	return r
+}
+
+// TestRemex_Connect 测试并行建立连接
+func TestRemex_Connect(t *testing.T) {
+	t.Run("并行连接", func(t *testing.T) {
+		ids := []string{"host1", "host2", "host3"}
+
+		var started sync.WaitGroup
+		started.Add(len(ids))
+		allStarted := make(chan struct{})
+		go func() {
+			started.Wait()
+			close(allStarted)
+		}()
+
+		r := newMockRemex(t, ids, func(id string, config *SSHConfig) (RemoteClient, error) {
+			started.Done()
+			select {
+			case <-allStarted:
+			case <-time.After(time.Second):
+				return nil, errors.New("connections are not established in parallel")
+			}
+			return &mockClient{id: id}, nil
+		})
+
+		if err := r.Connect(); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		if got := len(r.GetConnectedHosts()); got != len(ids) {
+			t.Errorf("connected hosts = %d, want %d", got, len(ids))
+		}
+	})
+
+	t.Run("部分连接失败", func(t *testing.T) {
+		r := newMockRemex(t, []string{"host1", "host2"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+			if id == "host2" {
+				return nil, errors.New("connection refused")
+			}
+			return &mockClient{id: id}, nil
+		}, WithConnectConcurrency(1))
+
+		if err := r.Connect(); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		if _, ok := r.GetClientByID("host2"); ok {
+			t.Error("GetClientByID() found client for failed host")
+		}
+
+		result := r.ConnectResult()
+		if err, ok := result["host1"]; !ok || err != nil {
+			t.Errorf("ConnectResult()[host1] = %v, %v, want nil, true", err, ok)
+		}
+		if err := result["host2"]; err == nil || err.Error() != "connection refused" {
+			t.Errorf("ConnectResult()[host2] = %v, want connection refused", err)
+		}
+	})
+
+	t.Run("取消后记录未连接的主机", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		r := NewWithContext(ctx, slog.New(slog.DiscardHandler), map[string]*SSHConfig{
+			"host1": {Addr: netip.MustParseAddr("192.168.1.1"), Port: 22},
+		})
+		if got := r.ConnectResult(); got != nil {
+			t.Errorf("ConnectResult() before Connect = %v, want nil", got)
+		}
+
+		if err := r.Connect(); !errors.Is(err, context.Canceled) {
+			t.Fatalf("Connect() error = %v, want context.Canceled", err)
+		}
+		if err := r.ConnectResult()["host1"]; !errors.Is(err, context.Canceled) {
+			t.Errorf("ConnectResult()[host1] = %v, want context.Canceled", err)
+		}
+	})
+
+	t.Run("取消进行中的连接", func(t *testing.T) {
+		// host1 卡在拨号，host2 的 TCP 连接已建立但服务端不进行握手
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen() error = %v", err)
+		}
+		defer listener.Close()
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+			}
+		}()
+
+		dialing := make(chan struct{}, 2)
+		pending := NewSSHConfig(netip.MustParseAddr("192.168.1.1"), "testuser", "testpass")
+		pending.ConnectTimeout = time.Minute
+		pending.InsecureIgnoreHostKey = true
+		pending.Dialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialing <- struct{}{}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+
+		silentAddr := listener.Addr().(*net.TCPAddr).AddrPort()
+		silent := NewSSHConfig(silentAddr.Addr(), "testuser", "testpass")
+		silent.Port = silentAddr.Port()
+		silent.ConnectTimeout = time.Minute
+		silent.InsecureIgnoreHostKey = true
+		silent.Dialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			dialing <- struct{}{}
+			return conn, err
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		r := NewWithContext(ctx, slog.New(slog.DiscardHandler), map[string]*SSHConfig{"host1": pending, "host2": silent})
+
+		go func() {
+			<-dialing
+			<-dialing
+			cancel()
+		}()
+
+		start := time.Now()
+		err = r.Connect()
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Connect() error = %v, want context.Canceled", err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Connect() returned after %v, want it to return promptly", elapsed)
+		}
+		for id, err := range r.ConnectResult() {
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("ConnectResult()[%s] = %v, want context.Canceled", id, err)
+			}
+		}
+		if got := len(r.GetConnectedHosts()); got != 0 {
+			t.Errorf("connected hosts = %d, want 0", got)
+		}
+	})
+
+	t.Run("全部连接失败", func(t *testing.T) {
+		r := newMockRemex(t, []string{"host1", "host2"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+			return nil, errors.New("connection refused")
+		})
+
+		err := r.Connect()
+		if err == nil || !strings.Contains(err.Error(), "no successful connections") {
+			t.Errorf("Connect() error = %v, want no successful connections", err)
+		}
+	})
+}
+
+// TestRemex_Execute_Template 测试每台主机独立展开 {{REMEX_ID}} 模板
+func TestRemex_Execute_Template(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		executed = make(map[string][]string)
+	)
+
+	r := newMockRemex(t, []string{"host1", "host2"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+		return &mockClient{id: id, execute: func(ctx context.Context, cmd string) (string, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			executed[id] = append(executed[id], cmd)
+			return "", nil
+		}}, nil
+	})
+
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	commands := []string{"echo {{REMEX_ID}}", "hostname"}
+	if err := r.Execute(commands); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	for _, id := range []string{"host1", "host2"} {
+		want := []string{"echo " + id, "hostname"}
+		if !slices.Equal(executed[id], want) {
+			t.Errorf("host %s executed %v, want %v", id, executed[id], want)
+		}
+	}
+	if commands[0] != "echo {{REMEX_ID}}" {
+		t.Errorf("Execute() modified caller commands: %v", commands)
+	}
+}
+
+// TestRemex_Execute_TemplateVars 测试内置模板变量和调用方提供的变量
+func TestRemex_Execute_TemplateVars(t *testing.T) {
+	r := newMockRemex(t, []string{"host1", "host2"}, nil,
+		WithTemplateVars("host1", map[string]any{"ROLE": "primary", "WEIGHT": 10}),
+		WithTemplateVars("host2", map[string]any{"ROLE": "replica", "REMEX_ID": "ignored"}),
+	)
+
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	results, err := r.ExecuteCollect([]string{
+		"deploy --node={{REMEX_ID}} --ip={{REMEX_ADDR}}:{{REMEX_PORT}} --user={{REMEX_USER}} --index={{REMEX_INDEX}}",
+		"role {{ROLE}} {{WEIGHT}}",
+	})
+	if err != nil {
+		t.Fatalf("ExecuteCollect() error = %v", err)
+	}
+
+	want := map[string][]string{
+		"host1": {"deploy --node=host1 --ip=192.168.1.1:22 --user=testuser --index=0", "role primary 10"},
+		"host2": {"deploy --node=host2 --ip=192.168.1.2:22 --user=testuser --index=1", "role replica "},
+	}
+	for id, commands := range want {
+		var got []string
+		for _, result := range results[id] {
+			got = append(got, result.Command)
+		}
+		if !slices.Equal(got, commands) {
+			t.Errorf("host %s executed %q, want %q", id, got, commands)
+		}
+	}
+}
+
+// TestRemex_RegisterHandler 测试处理器能收到执行结果
+func TestRemex_RegisterHandler(t *testing.T) {
+	r := newMockRemex(t, []string{"host1"}, nil)
+
+	var (
+		mutex   sync.Mutex
+		results []ExecResult
+	)
+	r.RegisterHandler(func(result ExecResult) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		results = append(results, result)
+	})
+
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if err := r.Execute([]string{"uptime"}); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	stages := make([]Stage, 0, len(results))
+	for _, result := range results {
+		stages = append(stages, result.Stage)
+	}
+
+	want := []Stage{StageConnected, StageStart, StageFinish}
+	if !slices.Equal(stages, want) {
+		t.Fatalf("handler received stages %v, want %v", stages, want)
+	}
+	if results[2].Command != "uptime" || results[2].Output != "uptime" || results[2].ID != "host1" {
+		t.Errorf("finish result = %v", results[2])
+	}
+}
+
+// TestRemex_RegisterAbortHandler 测试处理器返回错误时中止执行
+func TestRemex_RegisterAbortHandler(t *testing.T) {
+	errCritical := errors.New("critical output")
+
+	var executed []string
+	r := newMockRemex(t, []string{"host1"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+		return &mockClient{id: id, execute: func(ctx context.Context, cmd string) (string, error) {
+			executed = append(executed, cmd)
+			if cmd == "check" {
+				return "CRITICAL: disk full", nil
+			}
+			return cmd, nil
+		}}, nil
+	})
+
+	var finished []string
+	r.RegisterHandler(func(result ExecResult) {
+		if result.Stage == StageFinish {
+			finished = append(finished, result.Command)
+		}
+	})
+	r.RegisterAbortHandler(func(result ExecResult) error {
+		if result.Stage == StageFinish && strings.HasPrefix(result.Output, "CRITICAL") {
+			return errCritical
+		}
+		return nil
+	})
+
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	err := r.Execute([]string{"check", "deploy"})
+	if !errors.Is(err, ErrAborted) || !errors.Is(err, errCritical) {
+		t.Fatalf("Execute() error = %v, want %v and %v", err, ErrAborted, errCritical)
+	}
+	if want := []string{"check"}; !slices.Equal(executed, want) {
+		t.Errorf("executed = %v, want %v", executed, want)
+	}
+	if want := []string{"check"}; !slices.Equal(finished, want) {
+		t.Errorf("handler received %v, want %v", finished, want)
+	}
+
+	// 中止后不再执行命令
+	if err := r.Execute([]string{"uptime"}); !errors.Is(err, ErrAborted) {
+		t.Errorf("Execute() after abort error = %v, want %v", err, ErrAborted)
+	}
+	if len(executed) != 1 {
+		t.Errorf("executed = %v after abort", executed)
+	}
+}
+
+// TestRemex_HandlerPanic 测试处理器 panic 时记录日志并继续通知其余处理器
+func TestRemex_HandlerPanic(t *testing.T) {
+	r := newMockRemex(t, []string{"host1"}, nil)
+
+	var buf strings.Builder
+	r.logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	var finished []string
+	r.RegisterHandler(func(result ExecResult) {
+		panic("handler bug")
+	})
+	r.RegisterAbortHandler(func(result ExecResult) error {
+		panic(errors.New("abort handler bug"))
+	})
+	r.RegisterHandler(func(result ExecResult) {
+		if result.Stage == StageFinish {
+			finished = append(finished, result.Command)
+		}
+	})
+
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if err := r.Execute([]string{"uptime", "whoami"}); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	if want := []string{"uptime", "whoami"}; !slices.Equal(finished, want) {
+		t.Errorf("handler received %v, want %v", finished, want)
+	}
+	for _, want := range []string{"handler panicked", "panic=\"handler bug\"", "panic=\"abort handler bug\""} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log does not contain %s:\n%s", want, buf.String())
+		}
+	}
+}
+
+// TestRemex_ResultBuffer 测试缓冲结果时慢处理器不阻塞命令执行
+func TestRemex_ResultBuffer(t *testing.T) {
+	commands := []string{"cmd1", "cmd2", "cmd3"}
+
+	t.Run("缓冲区足够时不阻塞", func(t *testing.T) {
+		r := newMockRemex(t, []string{"host1"}, nil, WithResultBuffer(16, OverflowBlock))
+
+		release := make(chan struct{})
+		var stages []Stage
+		r.RegisterHandler(func(result ExecResult) {
+			<-release
+			stages = append(stages, result.Stage)
+		})
+
+		if err := r.Connect(); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		if err := r.Execute(commands); err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+
+		close(release)
+		if err := r.Shutdown(context.Background()); err != nil {
+			t.Fatalf("Shutdown() error = %v", err)
+		}
+
+		want := []Stage{StageConnected, StageStart, StageFinish, StageStart, StageFinish, StageStart, StageFinish}
+		if !slices.Equal(stages, want) {
+			t.Errorf("handler received stages %v, want %v", stages, want)
+		}
+	})
+
+	t.Run("缓冲区满时丢弃", func(t *testing.T) {
+		r := newMockRemex(t, []string{"host1"}, nil, WithResultBuffer(1, OverflowDrop))
+
+		release := make(chan struct{})
+		var received atomic.Int32
+		r.RegisterHandler(func(result ExecResult) {
+			<-release
+			received.Add(1)
+		})
+
+		if err := r.Connect(); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		if err := r.Execute(commands); err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+
+		close(release)
+		if err := r.Shutdown(context.Background()); err != nil {
+			t.Fatalf("Shutdown() error = %v", err)
+		}
+
+		// 处理器阻塞时最多一个结果在处理、一个在缓冲区
+		if got := received.Load(); got < 1 || got > 2 {
+			t.Errorf("handler received %d results, want 1 or 2", got)
+		}
+	})
+
+	t.Run("关闭时等待超时", func(t *testing.T) {
+		r := newMockRemex(t, []string{"host1"}, nil, WithResultBuffer(16, OverflowBlock))
+
+		release := make(chan struct{})
+		defer close(release)
+		r.RegisterHandler(func(result ExecResult) {
+			<-release
+		})
+
+		if err := r.Connect(); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if err := r.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+// TestRemex_Duration 测试完成结果记录命令耗时
+func TestRemex_Duration(t *testing.T) {
+	r := newMockRemex(t, []string{"host1"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+		return &mockClient{id: id, execute: func(ctx context.Context, cmd string) (string, error) {
+			time.Sleep(20 * time.Millisecond)
+			return cmd, nil
+		}}, nil
+	})
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	results, err := r.ExecuteCollect([]string{"sleep 0.02"})
+	if err != nil {
+		t.Fatalf("ExecuteCollect() error = %v", err)
+	}
+	if got := results["host1"][0].Duration; got < 20*time.Millisecond || got > time.Second {
+		t.Errorf("Duration = %v, want about 20ms", got)
+	}
+}
+
+// TestRemex_Tracer 测试执行时创建嵌套的跟踪 span
+func TestRemex_Tracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "deploy")
+	defer parent.End()
+
+	configs := map[string]*SSHConfig{
+		"host1": NewSSHConfig(netip.MustParseAddr("192.168.1.1"), "testuser", "testpass"),
+	}
+	r := NewWithContext(ctx, slog.New(slog.DiscardHandler), configs, WithTracer(provider.Tracer("remex")))
+	r.setNewSSHClient(func(id string, config *SSHConfig) (RemoteClient, error) {
+		return &mockClient{id: id, addr: netip.AddrPortFrom(config.Addr, config.Port), execute: func(ctx context.Context, cmd string) (string, error) {
+			if cmd == "false" {
+				return "", errors.New("exit status 1")
+			}
+			return cmd, nil
+		}}, nil
+	})
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	if err := r.Execute([]string{"uptime", "false"}); err == nil {
+		t.Fatal("Execute() error = nil, want failure")
+	}
+	if _, err := r.ExecuteWithID("host1", "whoami"); err != nil {
+		t.Fatalf("ExecuteWithID() error = %v", err)
+	}
+
+	spans := recorder.Ended()
+	names := make([]string, 0, len(spans))
+	byName := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range spans {
+		names = append(names, span.Name())
+		byName[span.Name()] = append(byName[span.Name()], span)
+	}
+	if want := []string{"remex.command", "remex.command", "remex.Execute", "remex.ExecuteWithID"}; !slices.Equal(names, want) {
+		t.Fatalf("spans = %v, want %v", names, want)
+	}
+
+	execute := byName["remex.Execute"][0]
+	if execute.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("remex.Execute is not a child of the caller span")
+	}
+	if execute.Status().Code != codes.Error {
+		t.Errorf("remex.Execute status = %v, want %v", execute.Status().Code, codes.Error)
+	}
+	if byName["remex.ExecuteWithID"][0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("remex.ExecuteWithID is not a child of the caller span")
+	}
+
+	for i, command := range []string{"uptime", "false"} {
+		span := byName["remex.command"][i]
+		if span.Parent().SpanID() != execute.SpanContext().SpanID() {
+			t.Errorf("span of %q is not a child of remex.Execute", command)
+		}
+
+		attrs := make(map[attribute.Key]attribute.Value)
+		for _, attr := range span.Attributes() {
+			attrs[attr.Key] = attr.Value
+		}
+		if attrs["remex.host.id"].AsString() != "host1" || attrs["remex.host.addr"].AsString() != "192.168.1.1:22" ||
+			attrs["remex.command"].AsString() != command {
+			t.Errorf("span of %q attributes = %v", command, span.Attributes())
+		}
+		if _, ok := attrs["remex.exit_code"]; !ok {
+			t.Errorf("span of %q has no exit code", command)
+		}
+	}
+	if got := byName["remex.command"][1].Status().Code; got != codes.Error {
+		t.Errorf("failed command status = %v, want %v", got, codes.Error)
+	}
+
+	t.Run("使用调用方上下文中的 span", func(t *testing.T) {
+		recorder.Reset()
+
+		callerCtx, caller := provider.Tracer("test").Start(context.Background(), "request")
+		if err := r.ExecuteContext(callerCtx, []string{"uptime"}); err != nil {
+			t.Fatalf("ExecuteContext() error = %v", err)
+		}
+		if _, err := r.ExecuteWithIDContext(callerCtx, "host1", "whoami"); err != nil {
+			t.Fatalf("ExecuteWithIDContext() error = %v", err)
+		}
+		caller.End()
+
+		parents := make(map[string]trace.SpanID)
+		for _, span := range recorder.Ended() {
+			parents[span.Name()] = span.Parent().SpanID()
+		}
+		for _, name := range []string{"remex.Execute", "remex.ExecuteWithID"} {
+			if parents[name] != caller.SpanContext().SpanID() {
+				t.Errorf("%s is not a child of the caller span", name)
+			}
+		}
+	})
+
+	t.Run("调用方上下文取消时停止执行", func(t *testing.T) {
+		callerCtx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if err := r.ExecuteContext(callerCtx, []string{"uptime"}); !errors.Is(err, context.Canceled) {
+			t.Errorf("ExecuteContext() error = %v, want %v", err, context.Canceled)
+		}
+		if _, err := r.ExecuteWithIDContext(callerCtx, "host1", "whoami"); !errors.Is(err, context.Canceled) {
+			t.Errorf("ExecuteWithIDContext() error = %v, want %v", err, context.Canceled)
+		}
+	})
+}
+
+// recordingMetrics 记录 Metrics 收到的命令结果
+type recordingMetrics struct {
+	mutex   sync.Mutex
+	success map[string][]bool
+}
+
+func (m *recordingMetrics) OnCommandComplete(hostID string, success bool, d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.success[hostID] = append(m.success[hostID], success)
+}
+
+// TestRemex_Metrics 测试每条命令完成后通知 Metrics
+func TestRemex_Metrics(t *testing.T) {
+	metrics := &recordingMetrics{success: make(map[string][]bool)}
+
+	r := newMockRemex(t, []string{"host1", "host2"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+		return &mockClient{id: id, execute: func(ctx context.Context, cmd string) (string, error) {
+			if id == "host2" && cmd == "deploy" {
+				return "", errors.New("deploy failed")
+			}
+			return cmd, nil
+		}}, nil
+	}, WithMetrics(metrics))
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	if _, err := r.ExecuteBestEffort([]string{"check", "deploy"}); err == nil {
+		t.Fatal("ExecuteBestEffort() error = nil, want host2 failure")
+	}
+
+	want := map[string][]bool{"host1": {true, true}, "host2": {true, false}}
+	if !reflect.DeepEqual(metrics.success, want) {
+		t.Errorf("metrics = %v, want %v", metrics.success, want)
+	}
+}
+
+// TestNewJSONLinesHandler 测试每个结果写为一行 JSON
+func TestNewJSONLinesHandler(t *testing.T) {
+	var buf strings.Builder
+	handler := NewJSONLinesHandler(&buf)
+
+	r := newMockRemex(t, []string{"host1", "host2"}, nil)
+	r.RegisterHandler(handler)
+
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if err := r.Execute([]string{"uptime", "whoami"}); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 10 {
+		t.Fatalf("got %d lines, want 10:\n%s", len(lines), buf.String())
+	}
+
+	finished := make(map[string][]string)
+	for _, line := range lines {
+		var result ExecResult
+		if err := json.Unmarshal([]byte(line), &result); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", line, err)
+		}
+		if result.Stage == StageFinish {
+			finished[result.ID] = append(finished[result.ID], result.Output)
+		}
+	}
+
+	for _, id := range []string{"host1", "host2"} {
+		if want := []string{"uptime", "whoami"}; !slices.Equal(finished[id], want) {
+			t.Errorf("%s finished outputs = %v, want %v", id, finished[id], want)
+		}
+	}
+}
+
+// TestNewConsoleHandler 测试完成的命令输出为对齐的状态行
+func TestNewConsoleHandler(t *testing.T) {
+	var buf strings.Builder
+	handler := NewConsoleHandler(&buf)
+
+	for _, result := range []ExecResult{
+		{ID: "web1", Command: "uptime", Stage: StageStart},
+		{ID: "web1", Command: "uptime", Stage: StageFinish, Output: "up 3 days", Duration: 120 * time.Millisecond},
+		{ID: "database", Command: "systemctl restart postgresql", Stage: StageOutput, Output: "restarting"},
+		{ID: "database", Command: "systemctl restart postgresql", Stage: StageFinish, Error: errors.New("exit status 1"), Duration: 1500 * time.Millisecond},
+		{ID: "web1", Stage: StageConnected},
+		{ID: "web1", Command: "date", Stage: StageFinish, Duration: 5 * time.Millisecond},
+	} {
+		handler(result)
+	}
+
+	want := "" +
+		"web1  uptime  ok       120ms\n" +
+		"database  systemctl restart postgresql  FAIL      1.5s  exit status 1\n" +
+		"web1      date                          ok         5ms\n"
+	if buf.String() != want {
+		t.Errorf("output =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+// TestExecResult_JSON 测试 ExecResult 的 JSON 序列化与反序列化
+func TestExecResult_JSON(t *testing.T) {
+	fixedTime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		input    ExecResult
+		expected string
+	}{
+		{
+			name: "正常结果无错误",
+			input: ExecResult{
+				ID:         "test-id",
+				Command:    `echo "hi"`,
+				RemoteAddr: netip.MustParseAddrPort("192.168.1.1:22"),
+				Stage:      StageFinish,
+				Output:     "hi\n",
+				Time:       fixedTime,
+			},
+			expected: `{"id":"test-id","command":"echo \"hi\"","remote_addr":"192.168.1.1:22","stage":3,"error":null,"output":"hi\n","exit_code":0,"time":"2023-01-01T00:00:00Z"}`,
+		},
+		{
+			name: "包含错误信息",
+			input: ExecResult{
+				ID:         "error-id",
+				RemoteAddr: netip.MustParseAddr("192.168.1.2"),
+				Stage:      StageDisconnected,
+				Error:      errors.New("test error"),
+				Time:       fixedTime,
+			},
+			expected: `{"id":"error-id","command":"","remote_addr":"192.168.1.2","stage":0,"error":"test error","exit_code":0,"time":"2023-01-01T00:00:00Z"}`,
+		},
+		{
+			name: "记录耗时",
+			input: ExecResult{
+				ID:         "slow-id",
+				Command:    "sleep 1",
+				RemoteAddr: netip.MustParseAddrPort("192.168.1.4:22"),
+				Stage:      StageFinish,
+				Duration:   1500 * time.Millisecond,
+				Time:       fixedTime,
+			},
+			expected: `{"id":"slow-id","command":"sleep 1","remote_addr":"192.168.1.4:22","stage":3,"error":null,"exit_code":0,"duration":1500000000,"time":"2023-01-01T00:00:00Z"}`,
+		},
+		{
+			name: "输出被截断",
+			input: ExecResult{
+				ID:         "big-id",
+				Command:    "yes",
+				RemoteAddr: netip.MustParseAddrPort("192.168.1.3:22"),
+				Stage:      StageFinish,
+				Output:     "y\n[output truncated]",
+				Truncated:  true,
+				Time:       fixedTime,
+			},
+			expected: `{"id":"big-id","command":"yes","remote_addr":"192.168.1.3:22","stage":3,"error":null,"output":"y\n[output truncated]","exit_code":0,"truncated":true,"time":"2023-01-01T00:00:00Z"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(tc.input)
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+			if string(data) != tc.expected {
+				t.Errorf("json.Marshal() = %s, want %s", data, tc.expected)
+			}
+
+			var decoded ExecResult
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+			if decoded.String() != tc.input.String() {
+				t.Errorf("round trip = %v, want %v", decoded, tc.input)
+			}
+			if decoded.Duration != tc.input.Duration {
+				t.Errorf("round trip Duration = %v, want %v", decoded.Duration, tc.input.Duration)
+			}
+		})
+	}
+}
+
+// TestRemex_ExecuteBestEffort 测试部分主机失败时其余主机继续执行
+func TestRemex_ExecuteBestEffort(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		executed = make(map[string][]string)
+	)
+
+	ids := []string{"host1", "host2", "host3"}
+	r := newMockRemex(t, ids, func(id string, config *SSHConfig) (RemoteClient, error) {
+		return &mockClient{id: id, execute: func(ctx context.Context, cmd string) (string, error) {
+			mutex.Lock()
+			executed[id] = append(executed[id], cmd)
+			mutex.Unlock()
+
+			if id != "host2" && cmd == "deploy" {
+				return "", fmt.Errorf("deploy failed on %s", id)
+			}
+			return "", nil
+		}}, nil
+	})
+
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	failures, err := r.ExecuteBestEffort([]string{"deploy", "restart"})
+	if err == nil {
+		t.Fatal("ExecuteBestEffort() error = nil, want joined host errors")
+	}
+	for _, id := range []string{"host1", "host3"} {
+		if !strings.Contains(err.Error(), "host "+id) {
+			t.Errorf("ExecuteBestEffort() error = %v, want it to mention %s", err, id)
+		}
+		if failures[id] == nil {
+			t.Errorf("failures[%s] = nil, want error", id)
+		}
+	}
+	if len(failures) != 2 {
+		t.Errorf("ExecuteBestEffort() failures = %v, want host1 and host3", failures)
+	}
+	if want := []string{"deploy", "restart"}; !slices.Equal(executed["host2"], want) {
+		t.Errorf("host2 executed %v, want %v", executed["host2"], want)
+	}
+
+	t.Run("全部成功", func(t *testing.T) {
+		failures, err := r.ExecuteBestEffort([]string{"uptime"})
+		if err != nil || len(failures) != 0 {
+			t.Errorf("ExecuteBestEffort() = %v, %v, want no failures", failures, err)
+		}
+	})
+}
+
+// TestRemex_Execute_MaxConcurrency 测试限制同时执行命令的主机数量
+func TestRemex_Execute_MaxConcurrency(t *testing.T) {
+	var running, peak atomic.Int32
+
+	ids := []string{"host1", "host2", "host3", "host4", "host5"}
+	r := newMockRemex(t, ids, func(id string, config *SSHConfig) (RemoteClient, error) {
+		return &mockClient{id: id, execute: func(ctx context.Context, cmd string) (string, error) {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			return "", nil
+		}}, nil
+	}, WithMaxConcurrency(2))
+
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if err := r.Execute([]string{"ls", "pwd"}); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if _, err := r.ExecuteBestEffort([]string{"ls"}); err != nil {
+		t.Fatalf("ExecuteBestEffort() error = %v", err)
+	}
+
+	if got := peak.Load(); got > 2 {
+		t.Errorf("peak concurrent hosts = %d, want at most 2", got)
+	}
+}
+
+// TestRemex_Execute_CommandTimeout 测试单条命令超时
+func TestRemex_Execute_CommandTimeout(t *testing.T) {
+	r := newMockRemex(t, []string{"host1"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+		return &mockClient{id: id, execute: func(ctx context.Context, cmd string) (string, error) {
+			if cmd == "sleep" {
+				<-ctx.Done()
+				return "", ctx.Err()
+			}
+			return cmd, nil
+		}}, nil
+	}, WithCommandTimeout(50*time.Millisecond))
+
+	var (
+		mutex   sync.Mutex
+		results []ExecResult
+	)
+	r.RegisterHandler(func(result ExecResult) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		results = append(results, result)
+	})
+
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	err := r.Execute([]string{"uptime", "sleep", "never"})
+
+	var timeoutErr *CommandTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("Execute() error = %v, want *CommandTimeoutError", err)
+	}
+	if timeoutErr.Command != "sleep" || timeoutErr.Timeout != 50*time.Millisecond {
+		t.Errorf("CommandTimeoutError = %+v", timeoutErr)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Execute() error = %v, want it to match %v", err, context.DeadlineExceeded)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, result := range results {
+		if result.Command == "never" {
+			t.Error("command after the timed out command was executed")
+		}
+	}
+}
+
+// TestRemex_CommandError 测试命令失败时返回带主机信息的错误
+func TestRemex_CommandError(t *testing.T) {
+	errDeploy := errors.New("deploy failed")
+	addr := netip.MustParseAddrPort("192.0.2.1:22")
+
+	r := newMockRemex(t, []string{"host1"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+		return &mockClient{id: id, addr: addr, execute: func(ctx context.Context, cmd string) (string, error) {
+			if cmd == "deploy" {
+				return "", errDeploy
+			}
+			return cmd, nil
+		}}, nil
+	})
+
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	check := func(t *testing.T, err error) {
+		t.Helper()
+
+		var cmdErr *CommandError
+		if !errors.As(err, &cmdErr) {
+			t.Fatalf("error = %v, want *CommandError", err)
+		}
+		if cmdErr.HostID != "host1" || cmdErr.RemoteAddr != addr || cmdErr.Command != "deploy" || cmdErr.ExitCode != -1 {
+			t.Errorf("CommandError = %+v", cmdErr)
+		}
+		if !errors.Is(err, errDeploy) {
+			t.Errorf("error = %v, want it to wrap %v", err, errDeploy)
+		}
+	}
+
+	t.Run("Execute", func(t *testing.T) {
+		check(t, r.Execute([]string{"uptime", "deploy"}))
+	})
+
+	t.Run("ExecuteWithID", func(t *testing.T) {
+		_, err := r.ExecuteWithID("host1", "deploy")
+		check(t, err)
+	})
+
+	t.Run("ExecuteBestEffort", func(t *testing.T) {
+		_, err := r.ExecuteBestEffort([]string{"deploy"})
+		check(t, err)
+		if got := strings.Count(err.Error(), "host1"); got != 1 {
+			t.Errorf("ExecuteBestEffort() error = %q, want the host mentioned once", err)
+		}
+	})
+}
+
+// TestCommandPolicy 测试命令白名单和黑名单
+func TestCommandPolicy(t *testing.T) {
+	policy := CommandPolicy{
+		Allow: []string{"echo", "systemctl status"},
+		Deny:  []*regexp.Regexp{regexp.MustCompile(`[;&|]`)},
+	}
+
+	testCases := []struct {
+		command string
+		blocked bool
+	}{
+		{command: "echo", blocked: false},
+		{command: "  echo hello", blocked: false},
+		{command: "systemctl status nginx", blocked: false},
+		{command: "systemctl restart nginx", blocked: true},
+		{command: "echoes", blocked: true},
+		{command: "echo ok; rm -rf /", blocked: true},
+		{command: "rm -rf /", blocked: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.command, func(t *testing.T) {
+			err := policy.check(tc.command)
+			if blocked := errors.Is(err, ErrCommandBlocked); blocked != tc.blocked {
+				t.Errorf("check(%q) = %v, want blocked %v", tc.command, err, tc.blocked)
+			}
+		})
+	}
+
+	t.Run("只有黑名单", func(t *testing.T) {
+		policy := CommandPolicy{Deny: []*regexp.Regexp{regexp.MustCompile(`^rm\s+-rf\s+/\s*$`)}}
+		if err := policy.check("uptime"); err != nil {
+			t.Errorf("check() error = %v, want nil", err)
+		}
+		if err := policy.check("rm -rf /"); !errors.Is(err, ErrCommandBlocked) {
+			t.Errorf("check() error = %v, want %v", err, ErrCommandBlocked)
+		}
+	})
+}
+
+// TestRemex_CommandPolicy 测试执行前按策略拒绝命令
+func TestRemex_CommandPolicy(t *testing.T) {
+	var executed atomic.Int32
+
+	r := newMockRemex(t, []string{"host1"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+		return &mockClient{id: id, execute: func(ctx context.Context, cmd string) (string, error) {
+			executed.Add(1)
+			return cmd, nil
+		}}, nil
+	}, WithCommandPolicy(CommandPolicy{Allow: []string{"echo"}}))
+
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	if _, err := r.ExecuteWithID("host1", "reboot"); !errors.Is(err, ErrCommandBlocked) {
+		t.Errorf("ExecuteWithID() error = %v, want %v", err, ErrCommandBlocked)
+	}
+	if _, err := r.ExecuteWithID("host1", "echo ok"); err != nil {
+		t.Errorf("ExecuteWithID() error = %v", err)
+	}
+	executed.Store(0)
+
+	err := r.Execute([]string{"echo {{REMEX_ID}}", "rm -rf /"})
+	var cmdErr *CommandError
+	if !errors.Is(err, ErrCommandBlocked) || !errors.As(err, &cmdErr) || cmdErr.Command != "rm -rf /" {
+		t.Fatalf("Execute() error = %v, want blocked rm -rf /", err)
+	}
+	if n := executed.Load(); n != 0 {
+		t.Errorf("executed %d commands, want none of a blocked batch", n)
+	}
+
+	// 失败的批次不影响之后的执行
+	if err := r.Execute([]string{"echo {{REMEX_ID}}"}); err != nil {
+		t.Errorf("Execute() after a blocked batch error = %v", err)
+	}
+}
+
+// TestRemex_RegisterCommand 测试每个实例独立的命令注册表
+func TestRemex_RegisterCommand(t *testing.T) {
+	defer ResetCommands()
+
+	server := newTestSSHServer(t)
+
+	newRemex := func() *Remex {
+		r := NewWithContext(context.Background(), slog.New(slog.DiscardHandler), map[string]*SSHConfig{"host1": server.sshConfig()})
+		if err := r.Connect(); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		t.Cleanup(func() { r.Close() })
+		return r
+	}
+	tenantA, tenantB := newRemex(), newRemex()
+
+	greet := func(name string) remexCommand {
+		return func(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+			return "hello from " + name, nil
+		}
+	}
+	if err := RegisterCommand("greet", greet("global")); err != nil {
+		t.Fatalf("RegisterCommand() error = %v", err)
+	}
+	if err := tenantA.RegisterCommand("greet", greet("a")); err != nil {
+		t.Fatalf("Remex.RegisterCommand() error = %v", err)
+	}
+	if !tenantB.UnregisterCommand("remex.rm") {
+		t.Error("Remex.UnregisterCommand(remex.rm) = false, want true")
+	}
+
+	if output, err := tenantA.ExecuteWithID("host1", "remex.greet"); err != nil || output != "hello from a" {
+		t.Errorf("tenantA ExecuteWithID() = %q, %v, want instance command", output, err)
+	}
+	if output, err := tenantB.ExecuteWithID("host1", "remex.greet"); err != nil || output != "hello from global" {
+		t.Errorf("tenantB ExecuteWithID() = %q, %v, want global command", output, err)
+	}
+
+	missing := filepath.ToSlash(filepath.Join(t.TempDir(), "missing"))
+	if _, err := tenantB.ExecuteWithID("host1", "remex.rm "+missing); !errors.Is(err, ErrUnknownCommand) {
+		t.Errorf("tenantB ExecuteWithID(remex.rm) error = %v, want %v", err, ErrUnknownCommand)
+	}
+	if slices.Contains(tenantB.ListCommands(), "remex.rm") || !slices.Contains(tenantA.ListCommands(), "remex.rm") {
+		t.Error("ListCommands() does not reflect the command removed from tenantB")
+	}
+	if _, err := tenantA.ExecuteWithID("host1", "remex.rm "+missing); errors.Is(err, ErrUnknownCommand) {
+		t.Errorf("tenantA ExecuteWithID(remex.rm) error = %v, want the built-in command", err)
+	}
+
+	// Broadcast 同样使用实例的命令注册表
+	if result := tenantA.Broadcast(context.Background(), "remex.greet")["host1"]; result.Error != nil || result.Output != "hello from a" {
+		t.Errorf("tenantA Broadcast() = %q, %v, want instance command", result.Output, result.Error)
+	}
+	touched := filepath.ToSlash(filepath.Join(t.TempDir(), "touched"))
+	if !tenantB.UnregisterCommand("touch") {
+		t.Error("Remex.UnregisterCommand(touch) = false, want true")
+	}
+	if result := tenantB.Broadcast(context.Background(), "remex.touch "+touched)["host1"]; !errors.Is(result.Error, ErrUnknownCommand) {
+		t.Errorf("tenantB Broadcast(remex.touch) error = %v, want %v", result.Error, ErrUnknownCommand)
+	}
+	if _, err := os.Stat(touched); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat() error = %v, hidden command created the file", err)
+	}
+
+	if err := tenantA.RegisterCommandWithMeta("report", "report", "print the tenant report", greet("a")); err != nil {
+		t.Fatalf("Remex.RegisterCommandWithMeta() error = %v", err)
+	}
+	if !slices.Contains(tenantA.ListCommandsWithMeta(), CommandInfo{Name: "remex.report", Usage: "report", Description: "print the tenant report"}) {
+		t.Error("Remex.ListCommandsWithMeta() does not contain the instance command")
+	}
+	if _, ok := DescribeCommand("report"); ok {
+		t.Error("DescribeCommand(report) found the instance command globally")
+	}
+
+	t.Run("并发注册", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := range 8 {
+			wg.Go(func() {
+				name := fmt.Sprintf("concurrent%d", i)
+				RegisterCommand(name, greet(name))
+				tenantA.RegisterCommand(name, greet(name))
+				GetCommand("remex." + name)
+				tenantA.ListCommands()
+				tenantA.ExecuteWithID("host1", "remex."+name)
+			})
+		}
+		wg.Wait()
+	})
+}
+
+// TestRemex_CircuitBreaker 测试连续失败的主机被熔断并在冷却后试探恢复
+func TestRemex_CircuitBreaker(t *testing.T) {
+	const cooldown = 50 * time.Millisecond
+
+	var (
+		failing  atomic.Bool
+		executed atomic.Int32
+	)
+	failing.Store(true)
+
+	r := newMockRemex(t, []string{"host1", "host2"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+		return &mockClient{id: id, execute: func(ctx context.Context, cmd string) (string, error) {
+			if id != "host1" {
+				return "ok", nil
+			}
+			executed.Add(1)
+			if failing.Load() {
+				return "", errors.New("disk full")
+			}
+			return "ok", nil
+		}}, nil
+	}, WithCircuitBreaker(2, cooldown))
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	for range 2 {
+		if failures, _ := r.ExecuteBestEffort([]string{"echo"}); errors.Is(failures["host1"], ErrCircuitOpen) {
+			t.Fatalf("host1 skipped before reaching the threshold: %v", failures["host1"])
+		}
+	}
+	if got := r.HostState("host1"); got != CircuitOpen {
+		t.Fatalf("HostState(host1) = %v, want %v", got, CircuitOpen)
+	}
+	if got := r.HostState("host2"); got != CircuitClosed {
+		t.Errorf("HostState(host2) = %v, want %v", got, CircuitClosed)
+	}
+
+	failures, _ := r.ExecuteBestEffort([]string{"echo"})
+	if !errors.Is(failures["host1"], ErrCircuitOpen) {
+		t.Errorf("host1 error = %v, want %v", failures["host1"], ErrCircuitOpen)
+	}
+	if _, failed := failures["host2"]; failed {
+		t.Errorf("host2 failed: %v", failures["host2"])
+	}
+	if _, err := r.ExecuteWithID("host1", "echo"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("ExecuteWithID() error = %v, want %v", err, ErrCircuitOpen)
+	}
+	if n := executed.Load(); n != 2 {
+		t.Errorf("executed %d commands on host1, want 2", n)
+	}
+
+	t.Run("试探失败后重新熔断", func(t *testing.T) {
+		time.Sleep(cooldown)
+		if got := r.HostState("host1"); got != CircuitHalfOpen {
+			t.Fatalf("HostState(host1) = %v, want %v", got, CircuitHalfOpen)
+		}
+		if _, err := r.ExecuteWithID("host1", "echo"); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("ExecuteWithID() error = %v, want the trial to run and fail", err)
+		}
+		if got := r.HostState("host1"); got != CircuitOpen {
+			t.Errorf("HostState(host1) = %v, want %v", got, CircuitOpen)
+		}
+	})
+
+	t.Run("试探成功后恢复", func(t *testing.T) {
+		failing.Store(false)
+		time.Sleep(cooldown)
+
+		if err := r.Execute([]string{"echo"}); err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		if got := r.HostState("host1"); got != CircuitClosed {
+			t.Errorf("HostState(host1) = %v, want %v", got, CircuitClosed)
+		}
+	})
+
+	t.Run("延迟连接失败计入熔断", func(t *testing.T) {
+		var dials atomic.Int32
+		r := newMockRemex(t, []string{"host1"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+			dials.Add(1)
+			return nil, errors.New("connection refused")
+		}, WithLazyConnect(), WithCircuitBreaker(2, time.Hour))
+
+		for range 2 {
+			if err := r.Execute([]string{"echo"}); err == nil || errors.Is(err, ErrCircuitOpen) {
+				t.Fatalf("Execute() error = %v, want the connect error", err)
+			}
+		}
+		if got := r.HostState("host1"); got != CircuitOpen {
+			t.Fatalf("HostState(host1) = %v, want %v", got, CircuitOpen)
+		}
+
+		if err := r.Execute([]string{"echo"}); !errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("Execute() error = %v, want %v", err, ErrCircuitOpen)
+		}
+		if _, err := r.ExecuteWithID("host1", "echo"); !errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("ExecuteWithID() error = %v, want %v", err, ErrCircuitOpen)
+		}
+		if n := dials.Load(); n != 2 {
+			t.Errorf("dialed host1 %d times, want 2", n)
+		}
+	})
+
+	t.Run("延迟连接试探成功后执行命令", func(t *testing.T) {
+		var failing atomic.Bool
+		failing.Store(true)
+		r := newMockRemex(t, []string{"host1"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+			if failing.Load() {
+				return nil, errors.New("connection refused")
+			}
+			return &mockClient{id: id}, nil
+		}, WithLazyConnect(), WithCircuitBreaker(1, cooldown))
+
+		if err := r.Execute([]string{"echo"}); err == nil {
+			t.Fatal("Execute() error = nil, want the connect error")
+		}
+		failing.Store(false)
+		time.Sleep(cooldown)
+
+		if err := r.Execute([]string{"echo"}); err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		if got := r.HostState("host1"); got != CircuitClosed {
+			t.Errorf("HostState(host1) = %v, want %v", got, CircuitClosed)
+		}
+	})
+
+	if got := (&Remex{}).HostState("host1"); got != CircuitClosed {
+		t.Errorf("HostState() without circuit breaker = %v, want %v", got, CircuitClosed)
+	}
+}
+
+// TestRemex_SkipDisconnected 测试连接断开的主机被跳过并在下次执行时重连
+func TestRemex_SkipDisconnected(t *testing.T) {
+	var (
+		dials     atomic.Int32
+		reconnect atomic.Bool
+		clients   sync.Map
+	)
+
+	newClient := func(id string, config *SSHConfig) (RemoteClient, error) {
+		if id == "host2" && dials.Add(1) > 1 && !reconnect.Load() {
+			return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+		}
+		client := &mockClient{id: id, execute: func(ctx context.Context, cmd string) (string, error) {
+			switch {
+			case cmd == "fail":
+				return "", errors.New("exit status 1")
+			case id == "host2" && dials.Load() == 1:
+				return "", fmt.Errorf("failed to create session: %w", io.EOF)
+			}
+			return "ok", nil
+		}}
+		clients.Store(id, client)
+		return client, nil
+	}
+
+	t.Run("跳过断开的主机", func(t *testing.T) {
+		dials.Store(0)
+		r := newMockRemex(t, []string{"host1", "host2"}, newClient, WithSkipDisconnected(false))
+		if err := r.Connect(); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+
+		var disconnectedStage atomic.Bool
+		r.RegisterHandler(func(result ExecResult) {
+			if result.Stage == StageDisconnected && result.ID == "host2" {
+				disconnectedStage.Store(true)
+			}
+		})
+
+		if err := r.Execute([]string{"echo"}); err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		if got := r.DisconnectedHosts(); !slices.Equal(got, []string{"host2"}) {
+			t.Errorf("DisconnectedHosts() = %v, want [host2]", got)
+		}
+		if _, ok := r.GetClientByID("host2"); ok {
+			t.Error("GetClientByID() found the disconnected host")
+		}
+		if client, _ := clients.Load("host2"); !client.(*mockClient).closed.Load() {
+			t.Error("dead client was not closed")
+		}
+		if !disconnectedStage.Load() {
+			t.Error("handlers were not notified with StageDisconnected")
+		}
+		if stats, _ := r.Stats(); !errors.Is(stats.Errors["host2"], ErrHostDisconnected) {
+			t.Errorf("Stats().Errors[host2] = %v, want %v", stats.Errors["host2"], ErrHostDisconnected)
+		}
+
+		// 命令失败不视为连接断开
+		if err := r.Execute([]string{"fail"}); err == nil || errors.Is(err, ErrHostDisconnected) {
+			t.Errorf("Execute(fail) error = %v, want the command error", err)
+		}
+		if got := r.DisconnectedHosts(); !slices.Equal(got, []string{"host2"}) {
+			t.Errorf("DisconnectedHosts() = %v, want [host2]", got)
+		}
+		if n := dials.Load(); n != 1 {
+			t.Errorf("host2 dialed %d times without reconnect, want 1", n)
+		}
+	})
+
+	t.Run("下次执行时重连", func(t *testing.T) {
+		dials.Store(0)
+		reconnect.Store(false)
+		r := newMockRemex(t, []string{"host1", "host2"}, newClient, WithSkipDisconnected(true))
+		if err := r.Connect(); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		if failures, err := r.ExecuteBestEffort([]string{"echo"}); err != nil || len(failures) != 0 {
+			t.Fatalf("ExecuteBestEffort() = %v, %v, want no failures", failures, err)
+		}
+
+		// 重连失败的主机仍被跳过
+		if err := r.Execute([]string{"echo"}); err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		if got := r.DisconnectedHosts(); !slices.Equal(got, []string{"host2"}) {
+			t.Errorf("DisconnectedHosts() = %v, want [host2]", got)
+		}
+
+		reconnect.Store(true)
+		if output, err := r.ExecuteWithID("host2", "echo"); err != nil || output != "ok" {
+			t.Fatalf("ExecuteWithID() = %q, %v, want reconnected host", output, err)
+		}
+		if got := r.DisconnectedHosts(); len(got) != 0 {
+			t.Errorf("DisconnectedHosts() = %v, want none", got)
+		}
+	})
+
+	t.Run("广播时跳过断开的主机", func(t *testing.T) {
+		dials.Store(0)
+		reconnect.Store(false)
+		r := newMockRemex(t, []string{"host1", "host2"}, newClient, WithSkipDisconnected(true))
+		if err := r.Connect(); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		if err := r.Execute([]string{"echo"}); err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+
+		results := r.Broadcast(context.Background(), "echo")
+		if err := results["host2"].Error; !errors.Is(err, ErrHostDisconnected) {
+			t.Errorf("host2 error = %v, want %v", err, ErrHostDisconnected)
+		}
+		if err := results["host1"].Error; err != nil {
+			t.Errorf("host1 error = %v", err)
+		}
+		if stats, _ := r.Stats(); len(stats.Failed) != 0 {
+			t.Errorf("Stats().Failed = %v, want the skipped host left out like Execute", stats.Failed)
+		}
+		if got := r.DisconnectedHosts(); !slices.Equal(got, []string{"host2"}) {
+			t.Errorf("DisconnectedHosts() = %v, want [host2]", got)
+		}
+	})
+
+	t.Run("按标签执行时重连", func(t *testing.T) {
+		dials.Store(0)
+		reconnect.Store(false)
+		r := newMockRemex(t, []string{"host1", "host2"}, newClient, WithSkipDisconnected(true))
+		r.configs["host2"].Tags = []string{"web"}
+		if err := r.Connect(); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		if err := r.Execute([]string{"echo"}); err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+
+		reconnect.Store(true)
+		if err := r.ExecuteOnTag("web", []string{"echo"}); err != nil {
+			t.Fatalf("ExecuteOnTag() error = %v, want the disconnected host to reconnect", err)
+		}
+		if got := r.DisconnectedHosts(); len(got) != 0 {
+			t.Errorf("DisconnectedHosts() = %v, want none", got)
+		}
+	})
+}
+
+// TestRemex_Stats 测试批量执行后的统计汇总
+func TestRemex_Stats(t *testing.T) {
+	errDeploy := errors.New("deploy failed")
+
+	r := newMockRemex(t, []string{"host1", "host2", "host3"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+		return &mockClient{id: id, execute: func(ctx context.Context, cmd string) (string, error) {
+			if id == "host2" {
+				return "", errDeploy
+			}
+			return cmd, nil
+		}}, nil
+	})
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	if _, ok := r.Stats(); ok {
+		t.Error("Stats() ok = true before any execution")
+	}
+
+	for name, run := range map[string]func() error{
+		"Execute": func() error { return r.Execute([]string{"deploy", "check"}) },
+		"ExecuteBestEffort": func() error {
+			_, err := r.ExecuteBestEffort([]string{"deploy", "check"})
+			return err
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := run(); !errors.Is(err, errDeploy) {
+				t.Fatalf("error = %v, want %v", err, errDeploy)
+			}
+
+			stats, ok := r.Stats()
+			if !ok {
+				t.Fatal("Stats() ok = false")
+			}
+			if want := []string{"host1", "host3"}; !slices.Equal(stats.Succeeded, want) {
+				t.Errorf("Succeeded = %v, want %v", stats.Succeeded, want)
+			}
+			if want := []string{"host2"}; !slices.Equal(stats.Failed, want) {
+				t.Errorf("Failed = %v, want %v", stats.Failed, want)
+			}
+			if !errors.Is(stats.Errors["host2"], errDeploy) {
+				t.Errorf("Errors[host2] = %v, want %v", stats.Errors["host2"], errDeploy)
+			}
+			if stats.Commands != 5 {
+				t.Errorf("Commands = %d, want 5", stats.Commands)
+			}
+			if want := int64(2 * len("deploycheck")); stats.OutputBytes != want {
+				t.Errorf("OutputBytes = %d, want %d", stats.OutputBytes, want)
+			}
+			if stats.Duration <= 0 {
+				t.Errorf("Duration = %v, want it positive", stats.Duration)
+			}
+		})
+	}
+}
+
+// TestRemex_RetryFailed 测试只在上次失败的主机上重新执行
+func TestRemex_RetryFailed(t *testing.T) {
+	var (
+		executed   = make(map[string]int)
+		execMutex  sync.Mutex
+		host2Fails atomic.Bool
+	)
+	host2Fails.Store(true)
+
+	r := newMockRemex(t, []string{"host1", "host2", "host3"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+		return &mockClient{id: id, execute: func(ctx context.Context, cmd string) (string, error) {
+			execMutex.Lock()
+			executed[id]++
+			execMutex.Unlock()
+
+			if id == "host2" && host2Fails.Load() {
+				return "", errors.New("deploy failed")
+			}
+			return "ok", nil
+		}}, nil
+	})
+	for _, config := range r.configs {
+		config.RetryBackoff = time.Millisecond
+	}
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	if err := r.RetryFailed([]string{"deploy"}); err != nil {
+		t.Fatalf("RetryFailed() before any execution error = %v", err)
+	}
+
+	if _, err := r.ExecuteBestEffort([]string{"deploy"}); err == nil {
+		t.Fatal("ExecuteBestEffort() error = nil, want host2 failure")
+	}
+
+	if err := r.RetryFailed([]string{"deploy"}); err == nil {
+		t.Error("RetryFailed() error = nil while host2 still fails")
+	}
+
+	host2Fails.Store(false)
+	if err := r.RetryFailed([]string{"deploy"}); err != nil {
+		t.Fatalf("RetryFailed() error = %v", err)
+	}
+
+	want := map[string]int{"host1": 1, "host2": 3, "host3": 1}
+	if !maps.Equal(executed, want) {
+		t.Errorf("executed = %v, want %v", executed, want)
+	}
+
+	if stats, _ := r.Stats(); !slices.Equal(stats.Succeeded, []string{"host2"}) || len(stats.Failed) != 0 {
+		t.Errorf("Stats() after retry = %+v, want only host2 succeeded", stats)
+	}
+
+	// 没有失败的主机时不执行
+	if err := r.RetryFailed([]string{"deploy"}); err != nil {
+		t.Errorf("RetryFailed() error = %v", err)
+	}
+	if executed["host2"] != 3 {
+		t.Errorf("host2 executed %d times, want 3", executed["host2"])
+	}
+
+	// 指定主机
+	if err := r.RetryFailed([]string{"deploy"}, "host1"); err != nil {
+		t.Errorf("RetryFailed(host1) error = %v", err)
+	}
+	if executed["host1"] != 2 {
+		t.Errorf("host1 executed %d times, want 2", executed["host1"])
+	}
+	if err := r.RetryFailed([]string{"deploy"}, "unknown"); !errors.Is(err, ErrNoClientForID) {
+		t.Errorf("RetryFailed(unknown) error = %v, want %v", err, ErrNoClientForID)
+	}
+}
+
+// TestRemex_LazyConnect 测试首次执行时按需连接并缓存客户端
+func TestRemex_LazyConnect(t *testing.T) {
+	var (
+		mutex     sync.Mutex
+		dials     = make(map[string]int)
+		host3Down atomic.Bool
+	)
+	host3Down.Store(true)
+
+	r := newMockRemex(t, []string{"host1", "host2", "host3"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+		mutex.Lock()
+		dials[id]++
+		mutex.Unlock()
+
+		if id == "host3" && host3Down.Load() {
+			return nil, errors.New("connection refused")
+		}
+		return &mockClient{id: id, addr: netip.AddrPortFrom(config.Addr, config.Port)}, nil
+	}, WithLazyConnect())
+
+	var disconnected []string
+	r.RegisterHandler(func(result ExecResult) {
+		if result.Stage == StageDisconnected {
+			mutex.Lock()
+			disconnected = append(disconnected, result.ID)
+			mutex.Unlock()
+		}
+	})
+
+	output, err := r.ExecuteWithID("host1", "uptime")
+	if err != nil || output != "uptime" {
+		t.Fatalf("ExecuteWithID() = %q, %v", output, err)
+	}
+	if _, err := r.ExecuteWithID("host4", "uptime"); !errors.Is(err, ErrNoClientForID) {
+		t.Errorf("ExecuteWithID(host4) error = %v, want %v", err, ErrNoClientForID)
+	}
+
+	err = r.Execute([]string{"uptime"})
+	if err == nil || !strings.Contains(err.Error(), "host host3") {
+		t.Fatalf("Execute() error = %v, want host3 connection failure", err)
+	}
+	if stats, _ := r.Stats(); !slices.Equal(stats.Succeeded, []string{"host1", "host2"}) || !slices.Equal(stats.Failed, []string{"host3"}) {
+		t.Errorf("Stats() = %+v, want host3 failed", stats)
+	}
+	if !slices.Equal(disconnected, []string{"host3"}) {
+		t.Errorf("disconnected = %v, want [host3]", disconnected)
+	}
+
+	// 主机恢复后再次执行时连接，已连接的主机不重新连接
+	host3Down.Store(false)
+	if err := r.Execute([]string{"uptime"}); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if want := map[string]int{"host1": 1, "host2": 1, "host3": 2}; !maps.Equal(dials, want) {
+		t.Errorf("dials = %v, want %v", dials, want)
+	}
+	if got := len(r.GetConnectedHosts()); got != 3 {
+		t.Errorf("connected hosts = %d, want 3", got)
+	}
+}
+
+// TestRemex_BroadcastUpload 测试并行上传同一文件到所有主机
+func TestRemex_BroadcastUpload(t *testing.T) {
+	server := newTestSSHServer(t)
+
+	configs := map[string]*SSHConfig{
+		"host1": server.sshConfig(),
+		"host2": NewSSHConfig(netip.MustParseAddr("192.168.1.2"), "testuser", "testpass"),
+	}
+	r := NewWithContext(context.Background(), slog.New(slog.DiscardHandler), configs, WithMaxConcurrency(1))
+	r.setNewSSHClient(func(id string, config *SSHConfig) (RemoteClient, error) {
+		if id == "host2" {
+			return &mockClient{id: id, addr: netip.AddrPortFrom(config.Addr, config.Port)}, nil
+		}
+		return NewSSHClient(id, config)
+	})
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+
+	dir := t.TempDir()
+	localPath := filepath.Join(dir, "app.tar.gz")
+	remotePath := filepath.Join(dir, "remote", "app.tar.gz")
+	if err := os.WriteFile(localPath, []byte("release 1.2.3"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	results := r.BroadcastUpload(context.Background(), localPath, remotePath)
+	if len(results) != 2 {
+		t.Fatalf("BroadcastUpload() = %v, want a result for both hosts", results)
+	}
+	if err := results["host1"]; err != nil {
+		t.Errorf("host1 error = %v", err)
+	}
+	if err := results["host2"]; err == nil || !strings.Contains(err.Error(), "unsupported remote client type") {
+		t.Errorf("host2 error = %v, want unsupported client", err)
+	}
+	if got, _ := os.ReadFile(remotePath); string(got) != "release 1.2.3" {
+		t.Errorf("remote content = %q, want %q", got, "release 1.2.3")
+	}
+
+	t.Run("通知处理器并记录统计", func(t *testing.T) {
+		var (
+			mutex    sync.Mutex
+			finished = make(map[string]ExecResult)
+		)
+		r.RegisterHandler(func(result ExecResult) {
+			if result.Stage == StageFinish {
+				mutex.Lock()
+				defer mutex.Unlock()
+				finished[result.ID] = result
+			}
+		})
+
+		r.BroadcastUpload(context.Background(), localPath, remotePath)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		if result := finished["host1"]; result.Error != nil || !strings.HasPrefix(result.Command, "remex.upload ") {
+			t.Errorf("host1 result = %+v, want a successful remex.upload", result)
+		}
+		if result := finished["host2"]; result.Error == nil {
+			t.Errorf("host2 result = %+v, want the upload error", result)
+		}
+		if stats, _ := r.Stats(); !slices.Equal(stats.Succeeded, []string{"host1"}) || !slices.Equal(stats.Failed, []string{"host2"}) {
+			t.Errorf("Stats() = %+v, want host1 succeeded and host2 failed", stats)
+		}
+	})
+
+	t.Run("策略拒绝上传", func(t *testing.T) {
+		r := NewWithContext(context.Background(), slog.New(slog.DiscardHandler), configs,
+			WithCommandPolicy(CommandPolicy{Deny: []*regexp.Regexp{regexp.MustCompile(`^remex\.upload `)}}))
+		r.setNewSSHClient(func(id string, config *SSHConfig) (RemoteClient, error) {
+			return &mockClient{id: id, addr: netip.AddrPortFrom(config.Addr, config.Port)}, nil
+		})
+		if err := r.Connect(); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		t.Cleanup(func() { r.Close() })
+
+		for id, err := range r.BroadcastUpload(context.Background(), localPath, remotePath) {
+			if !errors.Is(err, ErrCommandBlocked) {
+				t.Errorf("%s error = %v, want %v", id, err, ErrCommandBlocked)
+			}
+		}
+	})
+
+	t.Run("本地文件不存在", func(t *testing.T) {
+		results := r.BroadcastUpload(context.Background(), filepath.Join(dir, "missing"), remotePath)
+		for _, id := range []string{"host1", "host2"} {
+			if !errors.Is(results[id], os.ErrNotExist) {
+				t.Errorf("%s error = %v, want %v", id, results[id], os.ErrNotExist)
+			}
+		}
+	})
+}
+
+// TestRemex_Broadcast 测试在所有主机上执行同一命令并返回结果
+func TestRemex_Broadcast(t *testing.T) {
+	r := newMockRemex(t, []string{"host1", "host2", "host3"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+		return &mockClient{id: id, addr: netip.AddrPortFrom(config.Addr, config.Port), execute: func(ctx context.Context, cmd string) (string, error) {
+			if id == "host3" {
+				return "", errors.New("command not found")
+			}
+			return "up on " + cmd, nil
+		}}, nil
+	}, WithMaxConcurrency(2), WithCommandPolicy(CommandPolicy{Deny: []*regexp.Regexp{regexp.MustCompile("host2")}}))
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	var finished atomic.Int32
+	r.RegisterHandler(func(result ExecResult) {
+		if result.Stage == StageFinish {
+			finished.Add(1)
+		}
+	})
+
+	results := r.Broadcast(context.Background(), "uptime {{REMEX_ID}}")
+	if len(results) != 3 {
+		t.Fatalf("Broadcast() = %v, want a result for every host", results)
+	}
+
+	if got := results["host1"]; got.Error != nil || got.Output != "up on uptime host1" || got.Command != "uptime host1" {
+		t.Errorf("host1 result = %v", got)
+	}
+	if got := results["host2"]; !errors.Is(got.Error, ErrCommandBlocked) || got.Stage != StageFinish {
+		t.Errorf("host2 result = %v, want %v", got, ErrCommandBlocked)
+	}
+	if got := results["host3"]; got.Error == nil || got.RemoteAddr.String() != "192.168.1.3:22" {
+		t.Errorf("host3 result = %v, want command failure", got)
+	}
+	if got := finished.Load(); got != 2 {
+		t.Errorf("handlers received %d finish results, want 2", got)
+	}
+	if stats, _ := r.Stats(); !slices.Equal(stats.Failed, []string{"host2", "host3"}) {
+		t.Errorf("Stats().Failed = %v, want [host2 host3]", stats.Failed)
+	}
+
+	t.Run("上下文取消", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		for id, result := range r.Broadcast(ctx, "uptime") {
+			if !errors.Is(result.Error, context.Canceled) {
+				t.Errorf("%s error = %v, want %v", id, result.Error, context.Canceled)
+			}
+		}
+	})
+}
+
+// TestRemex_ExecuteCollect 测试按主机返回执行结果
+func TestRemex_ExecuteCollect(t *testing.T) {
+	r := newMockRemex(t, []string{"host1", "host2"}, nil)
+
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	results, err := r.ExecuteCollect([]string{"echo {{REMEX_ID}}", "uptime"})
+	if err != nil {
+		t.Fatalf("ExecuteCollect() error = %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("ExecuteCollect() returned %d hosts, want 2", len(results))
+	}
+
+	for _, id := range []string{"host1", "host2"} {
+		hostResults := results[id]
+		if len(hostResults) != 2 {
+			t.Fatalf("host %s results = %v, want 2 results", id, hostResults)
+		}
+		if hostResults[0].Output != "echo "+id || hostResults[1].Command != "uptime" {
+			t.Errorf("host %s results = %v", id, hostResults)
+		}
+		for _, result := range hostResults {
+			if result.Stage != StageFinish || result.ID != id || result.Time.IsZero() {
+				t.Errorf("host %s result = %v, want finished result", id, result)
+			}
+		}
+	}
+}
+
+// TestRemex_ExecuteOn 测试只在指定主机上执行
+func TestRemex_ExecuteOn(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		executed = make(map[string][]string)
+	)
+
+	r := newMockRemex(t, []string{"host1", "host2", "host3"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+		return &mockClient{id: id, execute: func(ctx context.Context, cmd string) (string, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			executed[id] = append(executed[id], cmd)
+			return "", nil
+		}}, nil
+	})
+
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	if err := r.ExecuteOn([]string{"host1", "host3"}, []string{"echo {{REMEX_ID}}"}); err != nil {
+		t.Fatalf("ExecuteOn() error = %v", err)
+	}
+	want := map[string][]string{"host1": {"echo host1"}, "host3": {"echo host3"}}
+	if !reflect.DeepEqual(executed, want) {
+		t.Errorf("executed = %v, want %v", executed, want)
+	}
+
+	t.Run("未知主机", func(t *testing.T) {
+		err := r.ExecuteOn([]string{"host2", "host9", "host8"}, []string{"uptime"})
+		if err == nil || !strings.Contains(err.Error(), "host9, host8") {
+			t.Errorf("ExecuteOn() error = %v, want unknown host9, host8", err)
+		}
+		if _, ok := executed["host2"]; ok {
+			t.Error("ExecuteOn() executed commands although some ids are unknown")
+		}
+	})
+}
+
+// TestRemex_ExecuteOnTag 测试按标签选择主机执行
+func TestRemex_ExecuteOnTag(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		executed []string
+	)
+
+	r := newMockRemex(t, []string{"web1", "web2", "db1"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+		return &mockClient{id: id, execute: func(ctx context.Context, cmd string) (string, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			executed = append(executed, id)
+			return "", nil
+		}}, nil
+	})
+	r.configs["web1"].Tags = []string{"role=web", "env=prod"}
+	r.configs["web2"].Tags = []string{"role=web", "env=staging"}
+	r.configs["db1"].Tags = []string{"role=db", "env=prod"}
+
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	if err := r.ExecuteOnTag("role=web", []string{"systemctl reload nginx"}); err != nil {
+		t.Fatalf("ExecuteOnTag() error = %v", err)
+	}
+	slices.Sort(executed)
+	if want := []string{"web1", "web2"}; !slices.Equal(executed, want) {
+		t.Errorf("executed on %v, want %v", executed, want)
+	}
+
+	if err := r.ExecuteOnTag("role=cache", []string{"uptime"}); err == nil {
+		t.Error("ExecuteOnTag() error = nil for a tag without hosts")
+	}
+}
+
+// TestRemex_AddRemoveHost 测试运行时添加和移除主机
+func TestRemex_AddRemoveHost(t *testing.T) {
+	clients := make(map[string]*mockClient)
+	var mutex sync.Mutex
+
+	r := newMockRemex(t, []string{"host1"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+		if id == "bad" {
+			return nil, errors.New("connection refused")
+		}
+		client := &mockClient{id: id, addr: netip.AddrPortFrom(config.Addr, config.Port)}
+		mutex.Lock()
+		clients[id] = client
+		mutex.Unlock()
+		return client, nil
+	})
+
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	config := NewSSHConfig(netip.MustParseAddr("192.168.1.50"), "testuser", "testpass")
+
+	var g sync.WaitGroup
+	for i := range 5 {
+		g.Go(func() {
+			r.AddHost(fmt.Sprintf("new%d", i), config)
+			r.ExecuteWithID("host1", "uptime")
+		})
+	}
+	g.Wait()
+
+	if got := len(r.GetConnectedHosts()); got != 6 {
+		t.Errorf("connected hosts = %d, want 6", got)
+	}
+	if err := r.AddHost("new0", config); err == nil {
+		t.Error("AddHost() error = nil for an existing host")
+	}
+	if err := r.AddHost("bad", config); err == nil {
+		t.Error("AddHost() error = nil for a failed connection")
+	}
+	if _, ok := r.GetClientByID("bad"); ok {
+		t.Error("AddHost() registered a host that failed to connect")
+	}
+
+	if err := r.RemoveHost("new0"); err != nil {
+		t.Fatalf("RemoveHost() error = %v", err)
+	}
+	if !clients["new0"].closed.Load() {
+		t.Error("RemoveHost() did not close the client")
+	}
+	if _, ok := r.GetClientByID("new0"); ok {
+		t.Error("RemoveHost() kept the client")
+	}
+	if err := r.RemoveHost("new0"); err == nil {
+		t.Error("RemoveHost() error = nil for an unknown host")
+	}
+	if err := r.Execute([]string{"uptime"}); err != nil {
+		t.Errorf("Execute() error = %v", err)
+	}
+}
+
+// TestRemex_Shutdown 测试优雅关闭等待正在执行的命令
+func TestRemex_Shutdown(t *testing.T) {
+	newBlockingRemex := func(t *testing.T) (*Remex, *mockClient, chan struct{}, chan struct{}) {
+		started, release := make(chan struct{}, 1), make(chan struct{})
+		client := &mockClient{id: "host1", execute: func(ctx context.Context, cmd string) (string, error) {
+			if cmd == "slow" {
+				started <- struct{}{}
+				<-release
+			}
+			return cmd, nil
+		}}
+		r := newMockRemex(t, []string{"host1"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+			return client, nil
+		})
+		if err := r.Connect(); err != nil {
+			t.Fatalf("Connect() error = %v", err)
+		}
+		return r, client, started, release
+	}
+
+	t.Run("等待命令完成", func(t *testing.T) {
+		r, client, started, release := newBlockingRemex(t)
+
+		executeErr := make(chan error, 1)
+		go func() { executeErr <- r.Execute([]string{"slow"}) }()
+		<-started
+
+		shutdownErr := make(chan error, 1)
+		go func() { shutdownErr <- r.Shutdown(context.Background()) }()
+
+		// 等待 Shutdown 开始拒绝新的执行
+		for {
+			if _, err := r.ExecuteWithID("host1", "uptime"); errors.Is(err, ErrClosed) {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if err := r.Execute([]string{"uptime"}); !errors.Is(err, ErrClosed) {
+			t.Errorf("Execute() after Shutdown error = %v, want %v", err, ErrClosed)
+		}
+
+		select {
+		case err := <-shutdownErr:
+			t.Fatalf("Shutdown() = %v before the running command finished", err)
+		case <-time.After(20 * time.Millisecond):
+		}
+		if client.closed.Load() {
+			t.Error("client closed while its command was running")
+		}
+
+		close(release)
+		if err := <-executeErr; err != nil {
+			t.Errorf("Execute() error = %v", err)
+		}
+		if err := <-shutdownErr; err != nil {
+			t.Errorf("Shutdown() error = %v", err)
+		}
+		if !client.closed.Load() {
+			t.Error("client not closed after Shutdown")
+		}
+	})
+
+	t.Run("超时后强制关闭", func(t *testing.T) {
+		r, client, started, release := newBlockingRemex(t)
+		defer close(release)
+
+		go r.Execute([]string{"slow"})
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		if err := r.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+		if !client.closed.Load() {
+			t.Error("client not closed after the shutdown deadline")
+		}
+	})
+}
+
+// TestRemex_Close 测试重复关闭是安全的并返回第一次的结果
+func TestRemex_Close(t *testing.T) {
+	errClose := errors.New("close failed")
+	client := &mockClient{id: "host1", closeErr: errClose}
+
+	r := newMockRemex(t, []string{"host1"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+		return client, nil
+	})
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	first := r.Close()
+	if !errors.Is(first, errClose) {
+		t.Fatalf("Close() error = %v, want %v", first, errClose)
+	}
+	if err := r.Close(); err != first {
+		t.Errorf("second Close() error = %v, want the first result %v", err, first)
+	}
+	if err := r.Shutdown(context.Background()); !errors.Is(err, errClose) {
+		t.Errorf("Shutdown() after Close error = %v, want %v", err, errClose)
+	}
+	if n := client.closes.Load(); n != 1 {
+		t.Errorf("client closed %d times, want 1", n)
+	}
+}
+
+// TestRemex_GetUpdateConfig 测试读取和更新主机配置
+func TestRemex_GetUpdateConfig(t *testing.T) {
+	var (
+		mutex sync.Mutex
+		ports []uint16
+	)
+	r := newMockRemex(t, []string{"host1"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		ports = append(ports, config.Port)
+		return &mockClient{id: id, addr: netip.AddrPortFrom(config.Addr, config.Port)}, nil
+	})
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	config, ok := r.GetConfig("host1")
+	if !ok || config.Port != DefaultSSHPort || config.Password != "testpass" {
+		t.Fatalf("GetConfig() = %+v, %v", config, ok)
+	}
+	config.Port = 2222
+	config.Tags = append(config.Tags, "role=web")
+
+	if stored, _ := r.GetConfig("host1"); stored.Port != DefaultSSHPort || len(stored.Tags) != 0 {
+		t.Errorf("modifying the copy changed the stored config: %+v", stored)
+	}
+
+	if err := r.UpdateConfig("host1", config); err != nil {
+		t.Fatalf("UpdateConfig() error = %v", err)
+	}
+	config.Port = 3333
+	if client, _ := r.GetClientByID("host1"); client.RemoteAddr().Port() != DefaultSSHPort {
+		t.Errorf("UpdateConfig() replaced the current connection")
+	}
+
+	if err := r.Reconnect("host1"); err != nil {
+		t.Fatalf("Reconnect() error = %v", err)
+	}
+	if want := []uint16{DefaultSSHPort, 2222}; !slices.Equal(ports, want) {
+		t.Errorf("connected with ports %v, want %v", ports, want)
+	}
+	if stored, _ := r.GetConfig("host1"); !stored.HasTag("role=web") {
+		t.Errorf("GetConfig() = %+v, want the updated tags", stored)
+	}
+
+	if _, ok := r.GetConfig("missing"); ok {
+		t.Error("GetConfig(missing) ok = true, want false")
+	}
+	if err := r.UpdateConfig("missing", config); err == nil {
+		t.Error("UpdateConfig(missing) error = nil, want error")
+	}
+}
+
+// TestRemex_Reconnect 测试重新连接单台主机
+func TestRemex_Reconnect(t *testing.T) {
+	var (
+		dials  atomic.Int32
+		failed atomic.Bool
+		mutex  sync.Mutex
+		latest *mockClient
+	)
+
+	r := newMockRemex(t, []string{"host1", "host2"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+		if id == "host1" {
+			dials.Add(1)
+			if failed.Load() {
+				return nil, errors.New("connection refused")
+			}
+		}
+		client := &mockClient{id: id}
+		if id == "host1" {
+			mutex.Lock()
+			latest = client
+			mutex.Unlock()
+		}
+		return client, nil
+	})
+
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	stale := latest
+
+	if err := r.Reconnect("host1"); err != nil {
+		t.Fatalf("Reconnect() error = %v", err)
+	}
+	if !stale.closed.Load() {
+		t.Error("Reconnect() did not close the stale client")
+	}
+	if client, _ := r.GetClientByID("host1"); client != latest || dials.Load() != 2 {
+		t.Errorf("Reconnect() client = %v, dials = %d, want the new client", client, dials.Load())
+	}
+
+	t.Run("重连失败", func(t *testing.T) {
+		failed.Store(true)
+		if err := r.Reconnect("host1"); err == nil {
+			t.Fatal("Reconnect() error = nil, want connection error")
+		}
+		if _, ok := r.GetClientByID("host1"); ok {
+			t.Error("Reconnect() kept the stale client after a failed dial")
+		}
+	})
+
+	t.Run("未知主机", func(t *testing.T) {
+		err := r.Reconnect("host9")
+		if err == nil || !strings.Contains(err.Error(), "no config found") {
+			t.Errorf("Reconnect() error = %v, want no config found", err)
+		}
+	})
+}
+
+// TestRemex_HealthCheck 测试探测所有连接的存活状态
+func TestRemex_HealthCheck(t *testing.T) {
+	r := newMockRemex(t, []string{"host1", "host2"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+		client := &mockClient{id: id}
+		if id == "host2" {
+			client.ping = func(ctx context.Context) error { return errors.New("connection reset") }
+		}
+		return client, nil
+	})
+
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	results := r.HealthCheck()
+	if len(results) != 2 {
+		t.Fatalf("HealthCheck() = %v, want 2 hosts", results)
+	}
+	if results["host1"] != nil {
+		t.Errorf("host1 error = %v, want nil", results["host1"])
+	}
+	if results["host2"] == nil {
+		t.Error("host2 error = nil, want ping error")
+	}
+}
+
+// TestRemex_Connect_Retry 测试网络错误时重试连接
+func TestRemex_Connect_Retry(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	testCases := []struct {
+		name         string
+		maxRetries   int
+		failures     int
+		err          error
+		wantAttempts int32
+		shouldError  bool
+	}{
+		{name: "重试后成功", maxRetries: 3, failures: 2, err: refused, wantAttempts: 3},
+		{name: "超过重试次数", maxRetries: 2, failures: 5, err: refused, wantAttempts: 3, shouldError: true},
+		{name: "认证失败不重试", maxRetries: 3, failures: 5, err: errors.New("ssh: handshake failed: ssh: unable to authenticate"), wantAttempts: 1, shouldError: true},
+		{name: "主机密钥错误不重试", maxRetries: 3, failures: 5, err: &HostKeyError{Hostname: "host1"}, wantAttempts: 1, shouldError: true},
+		{name: "未配置重试", failures: 1, err: refused, wantAttempts: 1, shouldError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			r := newMockRemex(t, []string{"host1"}, func(id string, config *SSHConfig) (RemoteClient, error) {
+				if int(attempts.Add(1)) <= tc.failures {
+					return nil, tc.err
+				}
+				return &mockClient{id: id}, nil
+			})
+			r.configs["host1"].MaxRetries = tc.maxRetries
+			r.configs["host1"].RetryBackoff = time.Millisecond
+
+			if err := r.Connect(); (err != nil) != tc.shouldError {
+				t.Fatalf("Connect() error = %v, shouldError %v", err, tc.shouldError)
+			}
+			if got := attempts.Load(); got != tc.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tc.wantAttempts)
+			}
+		})
+	}
+
+	t.Run("等待重试时取消", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		r := NewWithContext(ctx, slog.New(slog.DiscardHandler), map[string]*SSHConfig{
+			"host1": {Addr: netip.MustParseAddr("192.168.1.1"), MaxRetries: 10, RetryBackoff: time.Hour},
+		})
+		r.setNewSSHClient(func(id string, config *SSHConfig) (RemoteClient, error) {
+			cancel()
+			return nil, refused
+		})
+
+		if err := r.Connect(); !errors.Is(err, context.Canceled) {
+			t.Errorf("Connect() error = %v, want %v", err, context.Canceled)
+		}
+	})
+}
+
+// TestSSHConfig_RetryDelay 测试退避时间指数增长并有上限
+func TestSSHConfig_RetryDelay(t *testing.T) {
+	config := &SSHConfig{RetryBackoff: 100 * time.Millisecond}
+
+	for attempt, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		if got := config.retryDelay(attempt); got < want/2 || got > want {
+			t.Errorf("retryDelay(%d) = %v, want between %v and %v", attempt, got, want/2, want)
+		}
+	}
+	if got := config.retryDelay(100); got > MaxRetryBackoff {
+		t.Errorf("retryDelay(100) = %v, want at most %v", got, MaxRetryBackoff)
+	}
+}
+
+// TestRemex_RedactSecrets 测试日志中不出现密码并带有自定义字段
+func TestRemex_RedactSecrets(t *testing.T) {
+	var buf strings.Builder
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	configs := map[string]*SSHConfig{
+		"host1": NewSSHConfig(netip.MustParseAddr("192.168.1.1"), "testuser", "hunter2"),
+	}
+	r := NewWithContext(context.Background(), logger, configs, WithRedactSecrets(), WithLogAttrs("run_id", "run-42"))
+	r.setNewSSHClient(func(id string, config *SSHConfig) (RemoteClient, error) {
+		return &mockClient{id: id, execute: func(ctx context.Context, cmd string) (string, error) {
+			return "login with hunter2 ok", nil
+		}}, nil
+	})
+
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if err := r.Execute([]string{"echo hunter2 | sudo -S true"}); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	r.logger.Info("debugging", "config", configs["host1"], "password", "other", "group", slog.GroupValue(slog.String("api_token", "t0k3n")))
+
+	output := buf.String()
+	for _, secret := range []string{"hunter2", "other", "t0k3n"} {
+		if strings.Contains(output, secret) {
+			t.Errorf("log output contains secret %q:\n%s", secret, output)
+		}
+	}
+	if !strings.Contains(output, redacted) {
+		t.Errorf("log output has no redacted values:\n%s", output)
+	}
+	if strings.Count(output, `"run_id":"run-42"`) != strings.Count(output, "\n") {
+		t.Errorf("not every log line carries the run id:\n%s", output)
+	}
+
+	t.Run("私钥口令与短密码", func(t *testing.T) {
+		var buf strings.Builder
+		logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+		config := NewSSHConfig(netip.MustParseAddr("192.168.1.1"), "testuser", "a")
+		config.Passphrase = []byte("unlock-key")
+		r := NewWithContext(context.Background(), logger, map[string]*SSHConfig{"host1": config}, WithRedactSecrets())
+
+		r.logger.Info("deploying a release", "output", "key unlock-key accepted", "password", "a")
+
+		output := buf.String()
+		if strings.Contains(output, "unlock-key") {
+			t.Errorf("log output contains the passphrase:\n%s", output)
+		}
+		if !strings.Contains(output, `"msg":"deploying a release"`) {
+			t.Errorf("short password was scrubbed from the log text:\n%s", output)
+		}
+		if !strings.Contains(output, `"password":"`+redacted+`"`) {
+			t.Errorf("password attribute was not redacted:\n%s", output)
+		}
+	})
+}
+
+// TestSSHConfig_Clone 测试深拷贝配置
+func TestSSHConfig_Clone(t *testing.T) {
+	template := NewSSHConfig(netip.MustParseAddr("192.168.1.1"), "deploy", "secret")
+	template.ConnectTimeout = 3 * time.Second
+	template.PrivateKey = []byte("key")
+	template.Passphrase = []byte("passphrase")
+	template.Tags = []string{"role=web"}
+	template.Env = map[string]string{"APP_ENV": "prod"}
+	template.SudoCommands = []string{"sudo"}
+	template.Algorithms = Algorithms{Ciphers: []string{"aes256-ctr"}}
+	template.PTY = &PTYOptions{Term: "xterm", Modes: ssh.TerminalModes{ssh.ECHO: 0}}
+
+	config := template.Clone()
+	if !reflect.DeepEqual(config, template) {
+		t.Fatalf("Clone() = %+v, want %+v", config, template)
+	}
+	if !config.autoRootPassword {
+		t.Error("Clone() did not copy the unexported fields")
+	}
+
+	config.Addr = netip.MustParseAddr("192.168.1.2")
+	config.PrivateKey[0] = 'K'
+	config.Passphrase[0] = 'P'
+	config.Tags[0] = "role=db"
+	config.Env["APP_ENV"] = "dev"
+	config.SudoCommands[0] = "doas"
+	config.Algorithms.Ciphers[0] = "aes128-ctr"
+	config.PTY.Term = "vt100"
+	config.PTY.Modes[ssh.ECHO] = 1
+
+	want := NewSSHConfig(netip.MustParseAddr("192.168.1.1"), "deploy", "secret")
+	want.ConnectTimeout = 3 * time.Second
+	want.PrivateKey = []byte("key")
+	want.Passphrase = []byte("passphrase")
+	want.Tags = []string{"role=web"}
+	want.Env = map[string]string{"APP_ENV": "prod"}
+	want.SudoCommands = []string{"sudo"}
+	want.Algorithms = Algorithms{Ciphers: []string{"aes256-ctr"}}
+	want.PTY = &PTYOptions{Term: "xterm", Modes: ssh.TerminalModes{ssh.ECHO: 0}}
+	if !reflect.DeepEqual(template, want) {
+		t.Errorf("modifying the clone changed the template: %+v", template)
+	}
+}
+
+// TestSSHConfig_LogValue 测试配置的日志和字符串形式不包含凭据
+func TestSSHConfig_LogValue(t *testing.T) {
+	config := NewSSHConfig(netip.MustParseAddr("192.168.1.1"), "testuser", "hunter2")
+	config.Passphrase = []byte("phrase")
+
+	var buf strings.Builder
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("config", "config", config)
+
+	for _, output := range []string{buf.String(), fmt.Sprint(config), fmt.Sprintf("%v", config)} {
+		if strings.Contains(output, "hunter2") || strings.Contains(output, "phrase") {
+			t.Errorf("output contains credentials: %s", output)
+		}
+		if !strings.Contains(output, "testuser") {
+			t.Errorf("output = %s, want the username", output)
+		}
+	}
+}
+
+// TestLoadConfigs 测试从 YAML/JSON 主机清单加载配置
+func TestLoadConfigs(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "id_test"), []byte("key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "web.env"), []byte("APP_ENV=prod\nREGION=eu\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(t *testing.T, name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	check := func(t *testing.T, configs map[string]*SSHConfig) {
+		t.Helper()
+
+		if len(configs) != 2 {
+			t.Fatalf("LoadConfigs() = %d configs, want 2", len(configs))
+		}
+		web := configs["web1"]
+		if web.Addr != netip.MustParseAddr("192.168.1.10") || web.Host != "" || web.Port != 2222 || web.Username != "deploy" ||
+			string(web.PrivateKey) != "key" || !web.HasTag("role=web") || !web.autoRootPassword || !web.ForwardAgent {
+			t.Errorf("web1 config = %+v", web)
+		}
+		if want := map[string]string{"APP_ENV": "prod", "REGION": "us"}; !reflect.DeepEqual(web.Env, want) {
+			t.Errorf("web1 Env = %v, want %v", web.Env, want)
+		}
+		db := configs["db1"]
+		if db.Host != "db1.example.com" || db.Port != DefaultSSHPort || db.Password != "secret" || len(db.PrivateKey) != 0 || !db.InsecureIgnoreHostKey {
+			t.Errorf("db1 config = %+v", db)
+		}
+	}
+
+	t.Run("YAML", func(t *testing.T) {
+		configs, err := LoadConfigs(write(t, "hosts.yaml", `hosts:
+  - id: web1
+    host: 192.168.1.10
+    port: 2222
+    user: deploy
+    key_path: id_test
+    env_file: web.env
+    env: {REGION: us}
+    tags: [role=web]
+    forward_agent: true
+  - id: db1
+    host: db1.example.com
+    user: root
+    password: secret
+    insecure_ignore_host_key: true
+`))
+		if err != nil {
+			t.Fatalf("LoadConfigs() error = %v", err)
+		}
+		check(t, configs)
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		configs, err := LoadConfigs(write(t, "hosts.json", `{"hosts": [
+  {"id": "web1", "host": "192.168.1.10", "port": 2222, "user": "deploy", "key_path": "id_test", "env_file": "web.env", "env": {"REGION": "us"}, "tags": ["role=web"], "forward_agent": true},
+  {"id": "db1", "host": "db1.example.com", "user": "root", "password": "secret", "insecure_ignore_host_key": true}
+]}`))
+		if err != nil {
+			t.Fatalf("LoadConfigs() error = %v", err)
+		}
+		check(t, configs)
+	})
+
+	testCases := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "缺少地址",
+			file:    "no-host.yaml",
+			content: "hosts:\n  - {id: web1, user: root, password: x}\n",
+			wantErr: `host "web1": host is required`,
+		},
+		{
+			name:    "缺少认证",
+			file:    "no-auth.yaml",
+			content: "hosts:\n  - {id: web1, host: 192.168.1.10, user: root}\n",
+			wantErr: `host "web1": password or key_path is required`,
+		},
+		{
+			name:    "ID 重复",
+			file:    "duplicate.yaml",
+			content: "hosts:\n  - {id: web1, host: 192.168.1.10, user: root, password: x}\n  - {id: web1, host: 192.168.1.11, user: root, password: x}\n",
+			wantErr: `host "web1": duplicate id`,
+		},
+		{
+			name:    "缺少 ID",
+			file:    "no-id.json",
+			content: `{"hosts": [{"host": "192.168.1.10", "user": "root", "password": "x"}]}`,
+			wantErr: "host #1: id is required",
+		},
+		{
+			name:    "私钥不存在",
+			file:    "no-key.yaml",
+			content: "hosts:\n  - {id: web1, host: 192.168.1.10, user: root, key_path: missing}\n",
+			wantErr: `host "web1": failed to read private key`,
+		},
+		{
+			name:    "未知字段",
+			file:    "unknown.json",
+			content: `{"hosts": [{"id": "web1", "hostname": "192.168.1.10"}]}`,
+			wantErr: "failed to parse inventory",
+		},
+		{
+			name:    "不支持的格式",
+			file:    "hosts.toml",
+			content: "",
+			wantErr: "unsupported inventory format",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadConfigs(write(t, tc.file, tc.content))
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("LoadConfigs() error = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// TestLoadEnvFile 测试读取 dotenv 格式的环境变量文件
+func TestLoadEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	content := `# deploy settings
+APP_ENV=prod
+export REGION = eu-west-1
+EMPTY=
+URL=http://example.com/#anchor # comment
+SINGLE='keep \n $HOME'
+DOUBLE="line1\nline2 \"quoted\""
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := LoadEnvFile(path)
+	if err != nil {
+		t.Fatalf("LoadEnvFile() error = %v", err)
+	}
+
+	want := map[string]string{
+		"APP_ENV": "prod",
+		"REGION":  "eu-west-1",
+		"EMPTY":   "",
+		"URL":     "http://example.com/#anchor",
+		"SINGLE":  `keep \n $HOME`,
+		"DOUBLE":  "line1\nline2 \"quoted\"",
+	}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("LoadEnvFile() = %q, want %q", env, want)
+	}
+
+	for name, content := range map[string]string{
+		"无效的变量名": "1ABC=x\n",
+		"缺少等号":   "APP_ENV\n",
+		"引号未闭合":  "A=\"open\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadEnvFile(path); err == nil || !strings.Contains(err.Error(), path+":1") {
+				t.Errorf("LoadEnvFile() error = %v, want an error naming line 1", err)
+			}
+		})
+	}
+}
@@ -1,30 +1,202 @@
 package remex
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
 	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"net"
 	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 var (
+	// DefaultSSHPort is the port set by NewSSHConfig and used by LoadConfigs when none is given
 	DefaultSSHPort uint16 = 22
+	// DefaultConnectTimeout is used when SSHConfig.ConnectTimeout is zero
+	DefaultConnectTimeout = 5 * time.Second
+	// DefaultRetryBackoff is the first retry delay used when SSHConfig.RetryBackoff is zero
+	DefaultRetryBackoff = 500 * time.Millisecond
+	// MaxRetryBackoff caps the exponential backoff between connection attempts
+	MaxRetryBackoff = 30 * time.Second
+
+	// ErrIncorrectPassphrase is returned when an encrypted private key cannot be decrypted with the given passphrase
+	ErrIncorrectPassphrase = errors.New("incorrect private key passphrase")
+
+	// ErrClientNil is returned when a command is run without an SSH connection
+	ErrClientNil = errors.New("ssh client is nil")
+	// ErrClientClosed is returned by SSHClient.Underlying once the client is closed
+	ErrClientClosed = errors.New("ssh client is closed")
+	// ErrEmptyCommand is returned when the command to run is empty
+	ErrEmptyCommand = errors.New("command is empty")
+	// ErrUnknownCommand is returned for remex.* commands that are not registered
+	ErrUnknownCommand = errors.New("unknown remex command")
+
+	// ErrTimeout is returned when a remote command is killed because its context deadline passed,
+	// the error also matches context.DeadlineExceeded
+	ErrTimeout = errors.New("remote command timed out")
+	// ErrCanceled is returned when a remote command is killed because its context was canceled,
+	// the error also matches context.Canceled
+	ErrCanceled = errors.New("remote command canceled")
 )
 
 // SSHConfig holds the configuration for SSH connection
 type SSHConfig struct {
 	Username string
 	Password string
-	Addr     netip.Addr
-	Port     uint16
+	// Addr may carry an IPv6 zone for link-local addresses, e.g. fe80::1%eth0
+	Addr netip.Addr
+	Port uint16
+	// Host is a DNS name or address dialed instead of Addr when set, the name
+	// is resolved on every connect so round-robin DNS is followed
+	Host string
+
+	// Dialer opens the TCP connection when set, e.g. through a SOCKS5 proxy with
+	// the DialContext method of golang.org/x/net/proxy, the context carries
+	// ConnectTimeout and the ConnectContext cancellation. Addresses are dialed
+	// directly with a net.Dialer when nil.
+	Dialer DialFunc
+
+	// ConnectTimeout limits the time spent establishing the connection,
+	// defaults to DefaultConnectTimeout when zero
+	ConnectTimeout time.Duration
+	// MaxRetries is the number of times a connection failing with a network
+	// error is retried, authentication and host key failures are never retried
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, it doubles on every
+	// attempt with jitter, defaults to DefaultRetryBackoff when zero
+	RetryBackoff time.Duration
+
+	// PrivateKey holds a PEM encoded private key, when set it is used
+	// instead of password authentication
+	PrivateKey []byte
+	// Passphrase decrypts PrivateKey when the key is encrypted
+	Passphrase []byte
+	// KeyboardInteractive answers keyboard-interactive challenges, it is
+	// tried after password authentication when both are set
+	KeyboardInteractive ssh.KeyboardInteractiveChallenge
+
+	// KnownHostsPath is the known_hosts file used to verify host keys,
+	// defaults to ~/.ssh/known_hosts
+	KnownHostsPath string
+	// InsecureIgnoreHostKey disables host key verification
+	InsecureIgnoreHostKey bool
+	// HostKeyCallback overrides the host key verification, leaving it nil
+	// keeps the known_hosts or insecure behavior
+	HostKeyCallback ssh.HostKeyCallback
+	// BannerCallback receives the banner sent by the server before authentication,
+	// e.g. to log it for auditing, see ssh.BannerDisplayStderr
+	BannerCallback ssh.BannerCallback
+
+	// ForwardAgent forwards the local SSH agent to every command run on the host,
+	// so commands there can authenticate to further hosts with its keys, like
+	// ssh -A. The agent at SSH_AUTH_SOCK is used unless Agent is set. Only enable
+	// it for trusted hosts, their root can use the agent while a command runs.
+	ForwardAgent bool
+	// Agent is forwarded instead of the agent at SSH_AUTH_SOCK when set, e.g. an
+	// agent.NewKeyring holding keys loaded by the program
+	Agent agent.Agent
+
+	// KeepAliveInterval sends a keepalive request at this interval once connected,
+	// a client whose keepalive fails is marked dead, zero disables keepalives
+	KeepAliveInterval time.Duration
+
+	// PTY allocates a pseudo-terminal for every command run on the host,
+	// needed by tools like top or sudo configured with requiretty
+	PTY *PTYOptions
+
+	// SudoCommands overrides DefaultSudoCommands, the commands whose password
+	// prompt is answered with Password
+	SudoCommands []string
+
+	// MaxOutputBytes caps the output captured from every command, see ExecOptions.MaxOutputBytes
+	MaxOutputBytes int64
+
+	// Algorithms restricts the ciphers, key exchanges, MACs and host key
+	// algorithms negotiated, unknown names make Connect fail
+	Algorithms Algorithms
+	// ClientVersion is the identification string sent to the server, it must
+	// start with "SSH-2.0-", the golang.org/x/crypto/ssh default is used when empty
+	ClientVersion string
+
+	// Env is set in the session of every command run on the host, REMEX_ID
+	// takes precedence, see LoadEnvFile to read it from a dotenv file
+	Env map[string]string
+
+	// Tags label the host for Remex.ExecuteOnTag, e.g. "role=web" or "env=prod"
+	Tags []string
 
 	autoRootPassword bool
 }
 
+// DialFunc opens a network connection to addr, see SSHConfig.Dialer
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// HasTag reports whether the host is labeled with tag
+func (config *SSHConfig) HasTag(tag string) bool {
+	return slices.Contains(config.Tags, tag)
+}
+
+// Clone returns a deep copy of the configuration, e.g. to derive the configs of
+// several hosts from a template. Slices, maps and PTY are copied, unexported
+// fields too, while callbacks, Dialer and Agent are shared with the original.
+func (config *SSHConfig) Clone() *SSHConfig {
+	c := *config
+	c.PrivateKey = slices.Clone(config.PrivateKey)
+	c.Passphrase = slices.Clone(config.Passphrase)
+	c.SudoCommands = slices.Clone(config.SudoCommands)
+	c.Tags = slices.Clone(config.Tags)
+	c.Env = maps.Clone(config.Env)
+	c.Algorithms = config.Algorithms.clone()
+	if config.PTY != nil {
+		pty := *config.PTY
+		pty.Modes = maps.Clone(config.PTY.Modes)
+		c.PTY = &pty
+	}
+	return &c
+}
+
+// dialAddr returns the host:port dialed by Connect, Host takes precedence over Addr
+func (config *SSHConfig) dialAddr() string {
+	if config.Host != "" {
+		return net.JoinHostPort(config.Host, strconv.Itoa(int(config.Port)))
+	}
+	return netip.AddrPortFrom(config.Addr, config.Port).String()
+}
+
+// remote identifies the host in logs and results before it is connected, Host when set or Addr
+func (config *SSHConfig) remote() fmt.Stringer {
+	if config.Host != "" {
+		return hostName(config.Host)
+	}
+	return config.Addr
+}
+
+// hostName is a host name reported as ExecResult.RemoteAddr
+type hostName string
+
+func (h hostName) String() string {
+	return string(h)
+}
+
 // NewSSHConfig creates a default configuration
 func NewSSHConfig(remoteAddr netip.Addr, username, password string) *SSHConfig {
 	return &SSHConfig{
@@ -36,24 +208,246 @@ func NewSSHConfig(remoteAddr netip.Addr, username, password string) *SSHConfig {
 	}
 }
 
+// NewSSHConfigWithKey creates a default configuration using private key authentication
+func NewSSHConfigWithKey(remoteAddr netip.Addr, username string, pemBytes []byte) *SSHConfig {
+	return &SSHConfig{
+		Username:         username,
+		Addr:             remoteAddr,
+		Port:             DefaultSSHPort,
+		PrivateKey:       pemBytes,
+		autoRootPassword: true,
+	}
+}
+
+// NewSSHConfigWithEncryptedKey creates a default configuration using an encrypted private key
+func NewSSHConfigWithEncryptedKey(remoteAddr netip.Addr, username string, pemBytes, passphrase []byte) *SSHConfig {
+	config := NewSSHConfigWithKey(remoteAddr, username, pemBytes)
+	config.Passphrase = passphrase
+	return config
+}
+
+// parsePrivateKey parses the private key, decrypting it when a passphrase is set
+func (config *SSHConfig) parsePrivateKey() (ssh.Signer, error) {
+	if len(config.Passphrase) == 0 {
+		return ssh.ParsePrivateKey(config.PrivateKey)
+	}
+
+	signer, err := ssh.ParsePrivateKeyWithPassphrase(config.PrivateKey, config.Passphrase)
+	if errors.Is(err, x509.IncorrectPasswordError) {
+		return nil, fmt.Errorf("%w: %w", ErrIncorrectPassphrase, err)
+	}
+	return signer, err
+}
+
+// authMethods returns the SSH authentication methods for the configuration
+func (config *SSHConfig) authMethods() ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+
+	if len(config.PrivateKey) > 0 {
+		signer, err := config.parsePrivateKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	} else if config.Password != "" || config.KeyboardInteractive == nil {
+		methods = append(methods, ssh.Password(config.Password))
+	}
+
+	if config.KeyboardInteractive != nil {
+		methods = append(methods, ssh.KeyboardInteractive(config.KeyboardInteractive))
+	}
+
+	return methods, nil
+}
+
+// HostKeyError is returned when the host key of a remote host is unknown or has changed
+type HostKeyError struct {
+	Hostname string
+	Remote   net.Addr
+	Key      ssh.PublicKey
+
+	// Want holds the known keys for the host, it is empty when the host is unknown
+	Want []knownhosts.KnownKey
+}
+
+func (e *HostKeyError) Error() string {
+	if e.Unknown() {
+		return fmt.Sprintf("host key for %s is unknown", e.Hostname)
+	}
+	return fmt.Sprintf("host key for %s has changed", e.Hostname)
+}
+
+// Unknown reports whether the host is missing from known_hosts
+func (e *HostKeyError) Unknown() bool {
+	return len(e.Want) == 0
+}
+
+// hostKeyCallback returns the host key verification callback for the configuration
+func (config *SSHConfig) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if config.HostKeyCallback != nil {
+		return config.HostKeyCallback, nil
+	}
+	if config.InsecureIgnoreHostKey {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	path := config.KnownHostsPath
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate known_hosts: %w", err)
+		}
+		path = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts %s: %w", path, err)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			return &HostKeyError{Hostname: hostname, Remote: remote, Key: key, Want: keyErr.Want}
+		}
+		return err
+	}, nil
+}
+
+// connectTimeout returns the configured dial timeout or the default one
+func (config *SSHConfig) connectTimeout() time.Duration {
+	if config.ConnectTimeout > 0 {
+		return config.ConnectTimeout
+	}
+	return DefaultConnectTimeout
+}
+
+// retryDelay returns the jittered exponential backoff before the retry following attempt
+func (config *SSHConfig) retryDelay(attempt int) time.Duration {
+	delay := config.RetryBackoff
+	if delay <= 0 {
+		delay = DefaultRetryBackoff
+	}
+	for range attempt {
+		if delay >= MaxRetryBackoff/2 {
+			delay = MaxRetryBackoff
+			break
+		}
+		delay *= 2
+	}
+
+	// 在 [delay/2, delay) 之间随机，避免大量主机同时重试
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// isRetryableConnectError reports whether a connection error is caused by the network
+// rather than by the credentials or host key, which would fail again on retry
+func isRetryableConnectError(err error) bool {
+	var (
+		netErr     net.Error
+		hostKeyErr *HostKeyError
+	)
+	if errors.As(err, &hostKeyErr) || errors.Is(err, ErrIncorrectPassphrase) {
+		return false
+	}
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// ConfigError reports an invalid SSHConfig field, see SSHConfig.Validate
+type ConfigError struct {
+	// Field is the name of the invalid field, e.g. "Username"
+	Field  string
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid SSH config: %s %s", e.Field, e.Reason)
+}
+
+// Validate checks the configuration without connecting, missing fields are
+// reported as a *ConfigError naming the field. Connect calls it before dialing.
+func (config *SSHConfig) Validate() error {
+	switch {
+	case config.Username == "":
+		return &ConfigError{Field: "Username", Reason: "cannot be empty"}
+	case config.Host == "" && !config.Addr.IsValid():
+		return &ConfigError{Field: "Addr", Reason: "must be a valid address when Host is empty"}
+	case config.Port == 0:
+		return &ConfigError{Field: "Port", Reason: "cannot be zero"}
+	case config.Password == "" && len(config.PrivateKey) == 0 && config.KeyboardInteractive == nil:
+		return &ConfigError{Field: "Password", Reason: "cannot be empty without PrivateKey or KeyboardInteractive"}
+	}
+
+	if err := config.Algorithms.validate(); err != nil {
+		return err
+	}
+	if config.ClientVersion != "" && !strings.HasPrefix(config.ClientVersion, "SSH-2.0-") {
+		return fmt.Errorf("invalid client version %q: must start with SSH-2.0-", config.ClientVersion)
+	}
+	return nil
+}
+
 // Connect establishes an SSH connection
 func (config *SSHConfig) Connect() (*ssh.Client, error) {
+	return config.ConnectContext(context.Background())
+}
+
+// ConnectContext establishes an SSH connection, cancelling ctx aborts both the
+// dial and the handshake. ConnectTimeout still applies when ctx has no earlier deadline.
+func (config *SSHConfig) ConnectContext(ctx context.Context) (*ssh.Client, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	auth, err := config.authMethods()
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback, err := config.hostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+
 	sshConfig := &ssh.ClientConfig{
-		User: config.Username,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(config.Password),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         5 * time.Second,
+		User:            config.Username,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		ClientVersion:   config.ClientVersion,
+		BannerCallback:  config.BannerCallback,
 	}
+	config.Algorithms.apply(sshConfig)
+
+	ctx, cancel := context.WithTimeout(ctx, config.connectTimeout())
+	defer cancel()
 
-	addrPort := netip.AddrPortFrom(config.Addr, config.Port)
+	addr := config.dialAddr()
+
+	dial := config.Dialer
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
 
-	client, err := ssh.Dial("tcp", addrPort.String(), sshConfig)
+	// ssh.NewClientConn 不接受 context，取消时通过过期的 deadline 中断握手
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, sshConfig)
+	if !stop() {
+		if err == nil {
+			c.Close()
+		}
+		err = context.Cause(ctx)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to %s: %w", addrPort.String(), err)
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
 	}
-	return client, nil
+	return ssh.NewClient(c, chans, reqs), nil
 }
 
 type RemoteClient interface {
@@ -63,21 +457,225 @@ type RemoteClient interface {
 	Close() error
 }
 
+// OutputExecutor is implemented by clients that capture stdout and stderr separately
+type OutputExecutor interface {
+	ExecuteCommandOutput(ctx context.Context, cmd string) (CommandOutput, error)
+}
+
+// Pinger is implemented by clients that can probe whether their connection is still alive
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// CommandOutput holds the captured output of a command
+type CommandOutput struct {
+	Stdout string
+	Stderr string
+	// Combined holds stdout and stderr interleaved in the order they were received
+	Combined string
+
+	// ExitCode is the exit status of the command, -1 when it is unknown
+	ExitCode int
+	// Signal is the name of the signal that terminated the command, if any
+	Signal string
+	// Truncated reports that output was cut off at ExecOptions.MaxOutputBytes
+	Truncated bool
+}
+
+// exitStatus extracts the exit code and terminating signal from a command error
+func exitStatus(err error) (int, string) {
+	if err == nil {
+		return 0, ""
+	}
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), exitErr.Signal()
+	}
+	return -1, ""
+}
+
+// ExecOptions controls how a remote command is executed
+type ExecOptions struct {
+	// Env is sent to the server with Setenv, variables it refuses to set, as sshd
+	// does for names missing from AcceptEnv, are exported by the remote shell instead
+	Env              map[string]string
+	Password         string
+	AutoRootPassword bool
+	// PTY allocates a pseudo-terminal for the command when set
+	PTY *PTYOptions
+	// OnLine is called with every line of output as it arrives, calls are serialized
+	OnLine LineHandler
+	// Stdin is copied to the command's standard input, which is closed once it is
+	// exhausted. The sudo password is sent before it when AutoRootPassword applies.
+	Stdin io.Reader
+	// SudoCommands are the commands whose password prompt is answered with
+	// Password when AutoRootPassword is set, defaults to DefaultSudoCommands
+	SudoCommands []string
+	// SudoPromptTimeout is how long a sudo command with Stdin is waited for to
+	// prompt for the password, defaults to DefaultSudoPromptTimeout
+	SudoPromptTimeout time.Duration
+	// MaxOutputBytes caps each of Stdout, Stderr and Combined, the rest of the
+	// output is discarded and CommandOutput.Truncated is set, zero means unlimited.
+	// OnLine still receives every line, use it to stream large output elsewhere.
+	MaxOutputBytes int64
+	// Stdout and Stderr receive the output of the command as it arrives instead of
+	// it being captured, the matching CommandOutput fields stay empty. Writes to both
+	// are serialized, so the same writer may be passed for the two streams.
+	Stdout io.Writer
+	Stderr io.Writer
+	// ForwardAgent requests agent forwarding for the session, the client must
+	// serve the agent channels, see agent.ForwardToAgent and SSHConfig.ForwardAgent
+	ForwardAgent bool
+}
+
+// Stream identifies the output stream a line was read from
+type Stream uint8
+
+const (
+	StreamStdout Stream = iota
+	StreamStderr
+)
+
+func (s Stream) String() string {
+	if s == StreamStderr {
+		return "stderr"
+	}
+	return "stdout"
+}
+
+// LineHandler receives a line of command output without its line terminator
+type LineHandler func(stream Stream, line string)
+
+type lineHandlerKey struct{}
+
+// WithLineHandler returns a context that makes SSHClient stream the output of the commands it runs to h
+func WithLineHandler(ctx context.Context, h LineHandler) context.Context {
+	return context.WithValue(ctx, lineHandlerKey{}, h)
+}
+
+func lineHandlerFromContext(ctx context.Context) LineHandler {
+	h, _ := ctx.Value(lineHandlerKey{}).(LineHandler)
+	return h
+}
+
+type stdinKey struct{}
+
+// WithStdin returns a context that makes SSHClient feed r to the standard input of the next command it runs
+func WithStdin(ctx context.Context, r io.Reader) context.Context {
+	return context.WithValue(ctx, stdinKey{}, r)
+}
+
+func stdinFromContext(ctx context.Context) io.Reader {
+	r, _ := ctx.Value(stdinKey{}).(io.Reader)
+	return r
+}
+
+// PTYOptions describes the pseudo-terminal requested for a command. With a
+// terminal the remote side merges stderr into stdout.
+type PTYOptions struct {
+	// Term is the TERM value, defaults to "xterm"
+	Term string
+	// Width and Height are the terminal size in characters, default to 80x24
+	Width, Height int
+	// Modes are the terminal modes, defaults to echo disabled
+	Modes ssh.TerminalModes
+}
+
+// request requests the pseudo-terminal on the session, filling in the defaults
+func (p *PTYOptions) request(session *ssh.Session) error {
+	term, width, height, modes := cmp.Or(p.Term, "xterm"), cmp.Or(p.Width, 80), cmp.Or(p.Height, 24), p.Modes
+	if modes == nil {
+		modes = ssh.TerminalModes{ssh.ECHO: 0}
+	}
+	return session.RequestPty(term, height, width, modes)
+}
+
 type SSHClient struct {
 	id     string
 	config *SSHConfig
 
 	*ssh.Client
+
+	sftpMutex  sync.Mutex
+	sftpClient *sftp.Client
+
+	deadMutex sync.Mutex
+	deadErr   error
+
+	// platformOS and platformArch cache the result of Uname
+	platformMutex sync.Mutex
+	platformOS    string
+	platformArch  string
+
+	done      chan struct{}
+	closeOnce sync.Once
+	closed    atomic.Bool
+
+	// forwards holds the running port forwards stopped by Close
+	forwardMutex sync.Mutex
+	forwards     map[*Forward]struct{}
+
+	// agentConn is the connection to the forwarded SSH agent, nil when not needed
+	agentConn io.Closer
 }
 
 // NewSSHClient creates a new SSHClient instance
 func NewSSHClient(ID string, config *SSHConfig) (RemoteClient, error) {
-	client, err := config.Connect()
+	return NewSSHClientContext(context.Background(), ID, config)
+}
+
+// NewSSHClientContext creates a new SSHClient instance, ctx only bounds the connect
+// and does not affect the returned client
+func NewSSHClientContext(ctx context.Context, ID string, config *SSHConfig) (RemoteClient, error) {
+	client, err := config.ConnectContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return &SSHClient{ID, config, client}, nil
+	sc := &SSHClient{id: ID, config: config, Client: client, done: make(chan struct{})}
+	if config.ForwardAgent {
+		if sc.agentConn, err = forwardAgent(client, config); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	if config.KeepAliveInterval > 0 {
+		go sc.keepAlive(config.KeepAliveInterval)
+	}
+
+	return sc, nil
+}
+
+// keepAlive pings the server every interval until the client is closed or a keepalive fails
+func (sc *SSHClient) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sc.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			err := sc.Ping(ctx)
+			cancel()
+
+			if err != nil {
+				sc.deadMutex.Lock()
+				sc.deadErr = err
+				sc.deadMutex.Unlock()
+				return
+			}
+		}
+	}
+}
+
+// Err returns the keepalive error once the connection has been marked dead, nil while it is alive
+func (sc *SSHClient) Err() error {
+	sc.deadMutex.Lock()
+	defer sc.deadMutex.Unlock()
+	return sc.deadErr
 }
 
 // ID returns the ID of the SSHClient instance
@@ -87,105 +685,456 @@ func (sc *SSHClient) ID() string {
 
 // ExecuteCommand executes a command on the remote server and returns the output
 func (sc *SSHClient) ExecuteCommand(ctx context.Context, command string) (string, error) {
+	output, err := sc.ExecuteCommandOutput(ctx, command)
+	return output.Combined, err
+}
+
+// ExecuteCommandOutput executes a command on the remote server and returns stdout and stderr separately
+func (sc *SSHClient) ExecuteCommandOutput(ctx context.Context, command string) (CommandOutput, error) {
 	if sc.Client == nil {
-		return "", errors.New("SSH client is not connected")
+		return CommandOutput{ExitCode: -1}, ErrClientNil
+	}
+	if err := sc.Err(); err != nil {
+		return CommandOutput{ExitCode: -1}, fmt.Errorf("SSH connection is dead: %w", err)
 	}
 
 	if strings.HasPrefix(command, "remex.") {
-		return ExecRemexCommand(ctx, sc.Client, command)
-	} else {
-		return ExecRemoteCommand(ctx, map[string]string{remexID: sc.ID()}, sc.Client, sc.config.Password, command, sc.config.autoRootPassword)
+		output, err := ExecRemexCommand(withSSHClient(ctx, sc), sc.Client, command)
+		exitCode, signal := exitStatus(err)
+		return CommandOutput{Stdout: output, Combined: output, ExitCode: exitCode, Signal: signal}, err
 	}
+
+	return ExecRemoteCommandWithOptions(ctx, sc.Client, command, ExecOptions{
+		Env:              mergeEnv(sc.config.Env, map[string]string{remexID: sc.ID()}),
+		Password:         sc.config.Password,
+		AutoRootPassword: sc.config.autoRootPassword,
+		PTY:              sc.config.PTY,
+		OnLine:           lineHandlerFromContext(ctx),
+		Stdin:            stdinFromContext(ctx),
+		SudoCommands:     sc.config.SudoCommands,
+		MaxOutputBytes:   sc.config.MaxOutputBytes,
+		ForwardAgent:     sc.config.ForwardAgent,
+	})
 }
 
-// RemoteAddr returns the remote address of the SSH connection
+// RemoteAddr returns the remote address of the SSH connection, for a
+// configuration using Host it is the address the name resolved to
 func (sc *SSHClient) RemoteAddr() netip.AddrPort {
 	if sc.config == nil {
 		return netip.AddrPort{}
 	}
 
+	if sc.config.Host != "" && sc.Client != nil {
+		if addr, ok := sc.Client.RemoteAddr().(*net.TCPAddr); ok {
+			addrPort := addr.AddrPort()
+			return netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port())
+		}
+	}
+
 	return netip.AddrPortFrom(sc.config.Addr, sc.config.Port)
 }
 
+// Ping sends a keepalive request to check that the connection is still alive,
+// the server rejecting the request still counts as a response
+func (sc *SSHClient) Ping(ctx context.Context) error {
+	if sc.Client == nil {
+		return ErrClientNil
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := sc.Client.SendRequest("keepalive@openssh.com", true, nil)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("keepalive failed: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Underlying returns the *ssh.Client of the connection for what remex does not
+// cover, e.g. port forwarding. It fails once the client is closed or the
+// connection was marked dead by the keepalive. The returned client must not be
+// closed directly, use Close.
+func (sc *SSHClient) Underlying() (*ssh.Client, error) {
+	if sc.Client == nil {
+		return nil, ErrClientNil
+	}
+
+	if sc.closed.Load() {
+		return nil, ErrClientClosed
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("SSH connection is dead: %w", err)
+	}
+	return sc.Client, nil
+}
+
+// SFTPClient returns the SFTP client shared by the remex commands run on this connection,
+// it is created on first use and recreated when the previous SFTP session has ended
+func (sc *SSHClient) SFTPClient() (*sftp.Client, error) {
+	if sc.Client == nil {
+		return nil, ErrClientNil
+	}
+
+	sc.sftpMutex.Lock()
+	defer sc.sftpMutex.Unlock()
+
+	if sc.sftpClient != nil {
+		return sc.sftpClient, nil
+	}
+
+	sftpClient, err := sftp.NewClient(sc.Client)
+	if err != nil {
+		return nil, err
+	}
+	sc.sftpClient = sftpClient
+
+	// 连接断开或 SFTP 会话结束后清除缓存，下次使用时重新创建
+	go func() {
+		sftpClient.Wait()
+
+		sc.sftpMutex.Lock()
+		defer sc.sftpMutex.Unlock()
+		if sc.sftpClient == sftpClient {
+			sc.sftpClient = nil
+		}
+	}()
+
+	return sftpClient, nil
+}
+
 // Close closes the SSH connection
 func (sc *SSHClient) Close() error {
 	if sc.Client == nil {
 		return nil
 	}
 
-	return sc.Client.Close()
+	sc.forwardMutex.Lock()
+	sc.closed.Store(true)
+	sc.forwardMutex.Unlock()
+	sc.closeForwards()
+
+	sc.closeOnce.Do(func() {
+		if sc.done != nil {
+			close(sc.done)
+		}
+	})
+
+	sc.sftpMutex.Lock()
+	if sc.sftpClient != nil {
+		sc.sftpClient.Close()
+		sc.sftpClient = nil
+	}
+	sc.sftpMutex.Unlock()
+
+	err := sc.Client.Close()
+	if sc.agentConn != nil {
+		sc.agentConn.Close()
+	}
+	return err
 }
 
-// ExecuteRemoteCommand executes a command on the remote server and returns the output
+// ExecuteRemoteCommand executes a command on the remote server and returns the combined output
 func ExecRemoteCommand(ctx context.Context, env map[string]string, client *ssh.Client, password, command string, autoRootPassword bool) (string, error) {
+	output, err := ExecRemoteCommandWithOptions(ctx, client, command, ExecOptions{
+		Env:              env,
+		Password:         password,
+		AutoRootPassword: autoRootPassword,
+	})
+	return output.Combined, err
+}
+
+// ExecRemoteCommandWithOptions executes a command on the remote server and captures stdout and stderr separately
+func ExecRemoteCommandWithOptions(ctx context.Context, client *ssh.Client, command string, opts ExecOptions) (CommandOutput, error) {
 	if client == nil {
-		return "", errors.New("SSH client is nil")
+		return CommandOutput{ExitCode: -1}, ErrClientNil
+	}
+	if strings.TrimSpace(command) == "" {
+		return CommandOutput{ExitCode: -1}, ErrEmptyCommand
 	}
 
 	session, err := client.NewSession()
 	if err != nil {
-		return "", fmt.Errorf("failed to create session: %w", err)
+		return CommandOutput{ExitCode: -1}, fmt.Errorf("failed to create session: %w", err)
 	}
 	defer session.Close()
 
-	for k, v := range env {
-		session.Setenv(k, v)
+	script := command
+	var rejected []string
+	for _, name := range slices.Sorted(maps.Keys(opts.Env)) {
+		if err := session.Setenv(name, opts.Env[name]); err != nil {
+			rejected = append(rejected, name)
+		}
+	}
+	if len(rejected) > 0 {
+		exports, err := exportEnv(opts.Env, rejected)
+		if err != nil {
+			return CommandOutput{ExitCode: -1}, err
+		}
+		script = exports + command
 	}
 
-	outputCh := make(chan []byte)
-	errCh := make(chan error)
+	if opts.ForwardAgent {
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			return CommandOutput{ExitCode: -1}, fmt.Errorf("failed to request agent forwarding: %w", err)
+		}
+	}
 
-	// 读取输出 goroutine
-	go func() {
-		output, err := session.CombinedOutput(command)
+	if opts.PTY != nil {
+		if err := opts.PTY.request(session); err != nil {
+			return CommandOutput{ExitCode: -1}, fmt.Errorf("failed to request pty: %w", err)
+		}
+	}
 
-		errCh <- err
-		outputCh <- output
-	}()
+	var (
+		stdout   = &limitedBuffer{limit: opts.MaxOutputBytes}
+		stderr   = &limitedBuffer{limit: opts.MaxOutputBytes}
+		combined = &lockedBuffer{buf: limitedBuffer{limit: opts.MaxOutputBytes}}
+
+		scanners sync.WaitGroup
+
+		stdoutWriter io.Writer = io.MultiWriter(stdout, combined)
+		stderrWriter io.Writer = io.MultiWriter(stderr, combined)
+	)
+
+	var writeMutex sync.Mutex
+	if opts.Stdout != nil {
+		stdoutWriter = &syncWriter{mutex: &writeMutex, w: opts.Stdout}
+	}
+	if opts.Stderr != nil {
+		stderrWriter = &syncWriter{mutex: &writeMutex, w: opts.Stderr}
+	}
+
+	// 检测到密码提示后才写入密码，伪终端会把提示输出到标准输出
+	var prompter *passwordPrompter
+	if opts.AutoRootPassword && isSudoCommand(command, opts.SudoCommands) {
+		prompter = newPasswordPrompter(opts.Password)
+		stderrWriter = io.MultiWriter(stderrWriter, prompter)
+		if opts.PTY != nil {
+			stdoutWriter = io.MultiWriter(stdoutWriter, prompter)
+		}
+	}
 
-	if autoRootPassword && strings.HasPrefix(command, "sudo") {
-		stdin, err := session.StdinPipe()
+	if opts.OnLine != nil {
+		stdoutPipe, err := session.StdoutPipe()
+		if err != nil {
+			return CommandOutput{ExitCode: -1}, fmt.Errorf("failed to open stdout: %w", err)
+		}
+		stderrPipe, err := session.StderrPipe()
 		if err != nil {
-			return "", err
+			return CommandOutput{ExitCode: -1}, fmt.Errorf("failed to open stderr: %w", err)
+		}
+
+		var lineMutex sync.Mutex
+		emit := func(stream Stream, line string) {
+			lineMutex.Lock()
+			defer lineMutex.Unlock()
+			opts.OnLine(stream, line)
 		}
-		defer stdin.Close()
 
-		fmt.Fprintf(stdin, "%s\n", password)
+		scanners.Go(func() { scanLines(stdoutPipe, stdoutWriter, StreamStdout, emit) })
+		scanners.Go(func() { scanLines(stderrPipe, stderrWriter, StreamStderr, emit) })
+	} else {
+		session.Stdout = stdoutWriter
+		session.Stderr = stderrWriter
 	}
 
+	if opts.Stdin != nil || prompter != nil {
+		// 自行复制标准输入，避免 Wait 等待一个永远不结束的 Reader
+		stdinPipe, err := session.StdinPipe()
+		if err != nil {
+			return CommandOutput{ExitCode: -1}, fmt.Errorf("failed to open stdin: %w", err)
+		}
+		exited := make(chan struct{})
+		defer close(exited)
+
+		go func() {
+			if prompter != nil {
+				// 没有标准输入要转发时一直等待提示，直到命令退出
+				var timeout time.Duration
+				if opts.Stdin != nil {
+					timeout = cmp.Or(opts.SudoPromptTimeout, DefaultSudoPromptTimeout)
+				}
+				prompter.answer(ctx, exited, timeout, stdinPipe)
+			}
+			if opts.Stdin != nil {
+				io.Copy(NewInterruptibleWriter(ctx, stdinPipe), opts.Stdin)
+			}
+			stdinPipe.Close()
+		}()
+	}
+
+	err = runSession(ctx, session, script)
+	if ctxErr := ctx.Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+		return CommandOutput{ExitCode: -1, Signal: string(ssh.SIGKILL)}, err
+	}
+
+	// 等待剩余输出读取完毕
+	scanners.Wait()
+
+	exitCode, signal := exitStatus(err)
+	return CommandOutput{
+		Stdout:    stdout.String(),
+		Stderr:    stderr.String(),
+		Combined:  combined.String(),
+		ExitCode:  exitCode,
+		Signal:    signal,
+		Truncated: stdout.truncated || stderr.truncated || combined.truncated(),
+	}, err
+}
+
+// ExecRemoteCommandTo executes a command on the remote server, writing its stdout and
+// stderr to the given writers as the output arrives, a nil writer discards the stream.
+// Use ExecRemoteCommandWithOptions with ExecOptions.Stdout and Stderr to combine it
+// with a PTY, stdin or the sudo password.
+func ExecRemoteCommandTo(ctx context.Context, client *ssh.Client, command string, stdout, stderr io.Writer) error {
+	_, err := ExecRemoteCommandWithOptions(ctx, client, command, ExecOptions{
+		Stdout: cmp.Or(stdout, io.Discard),
+		Stderr: cmp.Or(stderr, io.Discard),
+	})
+	return err
+}
+
+// syncWriter serializes writes to w with a mutex shared between the output streams
+type syncWriter struct {
+	mutex *sync.Mutex
+	w     io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.w.Write(p)
+}
+
+// scanLines copies r to w and passes every line read to emit
+func scanLines(r io.Reader, w io.Writer, stream Stream, emit LineHandler) {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			w.Write([]byte(line))
+			emit(stream, strings.TrimRight(line, "\r\n"))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// runSession runs the command on the session, killing it when the context is done
+func runSession(ctx context.Context, session *ssh.Session, command string) error {
+	errCh := make(chan error, 1)
+
+	// 执行命令 goroutine
+	go func() {
+		errCh <- session.Run(command)
+	}()
+
 	select {
 	case <-ctx.Done():
 		_ = session.Signal(ssh.SIGKILL) // 发送 KILL 信号到远程
+		_ = session.Close()             // 关闭会话，远程忽略信号时也能结束命令
 
-		return "", ctx.Err()
-	case err := <-errCh:
-		output := <-outputCh // 命令结束
+		return contextError(ctx.Err())
+	case err := <-errCh: // 命令结束
+		return err
+	}
+}
 
-		if err != nil {
-			return string(output), err
-		}
-		return string(output), nil
+// contextError wraps the error of a done context into ErrTimeout or ErrCanceled
+func contextError(err error) error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	case errors.Is(err, context.Canceled):
+		return fmt.Errorf("%w: %w", ErrCanceled, err)
+	default:
+		return err
 	}
 }
 
+// truncatedMarker is appended to output cut off at ExecOptions.MaxOutputBytes
+const truncatedMarker = "\n[output truncated]"
+
+// limitedBuffer keeps the first limit bytes written to it and discards the rest
+// while still reporting every write as complete, zero means unlimited
+type limitedBuffer struct {
+	limit     int64
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.limit <= 0 {
+		return b.buf.Write(p)
+	}
+
+	if remaining := b.limit - int64(b.buf.Len()); int64(len(p)) > remaining {
+		b.truncated = true
+		b.buf.Write(p[:max(remaining, 0)])
+	} else {
+		b.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + truncatedMarker
+	}
+	return b.buf.String()
+}
+
+// lockedBuffer is a limitedBuffer safe for concurrent writes from the stdout and stderr copiers
+type lockedBuffer struct {
+	mutex sync.Mutex
+	buf   limitedBuffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func (b *lockedBuffer) truncated() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.truncated
+}
+
 // ExecuteRemexCommand executes a command on the remote server and returns the output
 func ExecRemexCommand(ctx context.Context, client *ssh.Client, command string) (string, error) {
 	if client == nil {
-		return "", fmt.Errorf("ssh client is nil")
+		return "", ErrClientNil
 	}
 
 	commandSplit := strings.Split(strings.TrimSpace(command), " ")
-	if len(commandSplit) == 0 {
-		return "", errors.New("invalid command")
+	if commandSplit[0] == "" {
+		return "", ErrEmptyCommand
 	}
 
-	if iFunc, exists := GetCommand(commandSplit[0]); exists {
-		output, err := iFunc(ctx, client, commandSplit[1:]...)
+	if cmd, exists := registryFromContext(ctx).lookup(commandSplit[0]); exists {
+		output, err := cmd.fn(ctx, client, commandSplit[1:]...)
 		if err != nil {
 			return "", fmt.Errorf("remex command '%s' failed: %w", commandSplit[0], err)
 		}
 		return output, nil
 	}
 
-	return "", fmt.Errorf("unknown remex command: %s", commandSplit[0])
+	return "", fmt.Errorf("%w: %s", ErrUnknownCommand, commandSplit[0])
 }
@@ -0,0 +1,2001 @@
+package remex
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// testCommandHandler 处理测试服务器收到的 exec 请求，返回退出码
+type testCommandHandler func(ctx context.Context, command string, env map[string]string, stdin io.Reader, stdout, stderr io.Writer) uint32
+
+// testSSHServer 是一个用于测试的本地 SSH 服务器
+// 默认使用 mvdan.cc/sh 解释执行命令，并支持 sftp 子系统
+type testSSHServer struct {
+	addr     netip.AddrPort
+	listener net.Listener
+
+	handler testCommandHandler
+	// exitSignal 非空时以该信号结束命令而不是返回退出码
+	exitSignal string
+	// rejectEnv 为 true 时拒绝 env 请求，模拟未配置 AcceptEnv 的 sshd
+	rejectEnv bool
+	// legacySFTP 为 true 时 SFTP 服务端不声明扩展且 rename 不覆盖已有文件，见 legacySFTPConn
+	legacySFTP bool
+
+	mutex      sync.Mutex
+	commands   []string
+	subsystems int
+	ptys       []string
+}
+
+// newTestSSHServer 启动一个测试 SSH 服务器，测试结束时自动关闭
+func newTestSSHServer(t *testing.T) *testSSHServer {
+	t.Helper()
+	return newTestSSHServerOn(t, "127.0.0.1:0")
+}
+
+// testBanner 是测试 SSH 服务器在认证前发送的登录横幅
+const testBanner = "Authorized access only\n"
+
+// newTestSSHServerOn 在指定地址上启动测试 SSH 服务器
+func newTestSSHServerOn(t *testing.T, address string) *testSSHServer {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey() error = %v", err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "testuser" && string(password) == "testpass" {
+				return nil, nil
+			}
+			return nil, errors.New("invalid credentials")
+		},
+		BannerCallback: func(conn ssh.ConnMetadata) string {
+			return testBanner
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &testSSHServer{
+		addr:     netip.MustParseAddrPort(listener.Addr().String()),
+		listener: listener,
+		handler:  runTestCommand,
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serveConn(conn, config)
+		}
+	}()
+
+	return s
+}
+
+// sshConfig 返回连接到测试服务器的配置
+func (s *testSSHServer) sshConfig() *SSHConfig {
+	config := NewSSHConfig(s.addr.Addr(), "testuser", "testpass")
+	config.Port = s.addr.Port()
+	config.InsecureIgnoreHostKey = true
+	return config
+}
+
+// dial 建立到测试服务器的 SSH 连接
+func (s *testSSHServer) dial(t *testing.T) *ssh.Client {
+	t.Helper()
+
+	client, err := s.sshConfig().Connect()
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// sftpSessions 返回服务器建立的 sftp 会话数量
+func (s *testSSHServer) sftpSessions() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.subsystems
+}
+
+// requestedPTYs 返回服务器收到的伪终端请求，格式为 "TERM 宽x高"
+func (s *testSSHServer) requestedPTYs() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.ptys...)
+}
+
+// executed 返回服务器收到的所有命令
+func (s *testSSHServer) executed() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func (s *testSSHServer) serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go s.serveGlobalRequests(sshConn, reqs)
+
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			go s.serveSession(sshConn, newChannel)
+		case "direct-tcpip":
+			go serveDirectTCPIP(newChannel)
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+// tcpipPayload 是 direct-tcpip 与 forwarded-tcpip 通道的负载
+type tcpipPayload struct {
+	Addr       string
+	Port       uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// serveDirectTCPIP 处理本地端口转发，连接到客户端请求的地址
+func serveDirectTCPIP(newChannel ssh.NewChannel) {
+	var payload tcpipPayload
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "invalid payload")
+		return
+	}
+
+	target, err := net.Dial("tcp", net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port))))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		target.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	pipeTestConns(channel, target)
+}
+
+// serveGlobalRequests 处理远程端口转发请求，其余请求一律拒绝
+func (s *testSSHServer) serveGlobalRequests(conn *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	listeners := make(map[string]net.Listener)
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+
+	for req := range reqs {
+		var payload struct {
+			Addr string
+			Port uint32
+		}
+		if req.Type != "tcpip-forward" && req.Type != "cancel-tcpip-forward" || ssh.Unmarshal(req.Payload, &payload) != nil {
+			req.Reply(false, nil)
+			continue
+		}
+
+		addr := net.JoinHostPort(payload.Addr, strconv.Itoa(int(payload.Port)))
+		if req.Type == "cancel-tcpip-forward" {
+			if listener, ok := listeners[addr]; ok {
+				listener.Close()
+				delete(listeners, addr)
+			}
+			req.Reply(true, nil)
+			continue
+		}
+
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			req.Reply(false, nil)
+			continue
+		}
+		port := uint32(listener.Addr().(*net.TCPAddr).Port)
+		listeners[net.JoinHostPort(payload.Addr, strconv.Itoa(int(port)))] = listener
+		req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+
+		go func() {
+			for {
+				local, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				origin := local.RemoteAddr().(*net.TCPAddr)
+				channel, requests, err := conn.OpenChannel("forwarded-tcpip", ssh.Marshal(tcpipPayload{
+					Addr: payload.Addr, Port: port, OriginAddr: origin.IP.String(), OriginPort: uint32(origin.Port),
+				}))
+				if err != nil {
+					local.Close()
+					continue
+				}
+				go ssh.DiscardRequests(requests)
+				go pipeTestConns(channel, local)
+			}
+		}()
+	}
+}
+
+// listForwardedAgentKeys 返回通过客户端转发的 agent 列出公钥的处理器，模拟 ssh-add -L
+func listForwardedAgentKeys(conn *ssh.ServerConn) testCommandHandler {
+	return func(ctx context.Context, command string, env map[string]string, stdin io.Reader, stdout, stderr io.Writer) uint32 {
+		channel, requests, err := conn.OpenChannel("auth-agent@openssh.com", nil)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		defer channel.Close()
+		go ssh.DiscardRequests(requests)
+
+		keys, err := agent.NewClient(channel).List()
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		for _, key := range keys {
+			fmt.Fprintln(stdout, key.String())
+		}
+		return 0
+	}
+}
+
+// pipeTestConns 在通道与连接之间双向复制数据，任一方向结束后关闭两端
+func pipeTestConns(channel ssh.Channel, conn net.Conn) {
+	defer channel.Close()
+	defer conn.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(channel, conn)
+		channel.CloseWrite()
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, channel)
+		conn.(*net.TCPConn).CloseWrite()
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+func (s *testSSHServer) serveSession(conn *ssh.ServerConn, newChannel ssh.NewChannel) {
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	env := make(map[string]string)
+	agentForwarded := false
+	for req := range requests {
+		switch req.Type {
+		case "auth-agent-req@openssh.com":
+			agentForwarded = true
+			req.Reply(true, nil)
+		case "env":
+			var kv struct{ Name, Value string }
+			if s.rejectEnv {
+				req.Reply(false, nil)
+				continue
+			}
+			if err := ssh.Unmarshal(req.Payload, &kv); err == nil {
+				env[kv.Name] = kv.Value
+			}
+			req.Reply(true, nil)
+		case "pty-req":
+			var payload struct {
+				Term          string
+				Columns, Rows uint32
+				Width, Height uint32
+				Modes         string
+			}
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			s.mutex.Lock()
+			s.ptys = append(s.ptys, fmt.Sprintf("%s %dx%d", payload.Term, payload.Columns, payload.Rows))
+			s.mutex.Unlock()
+			req.Reply(true, nil)
+		case "signal":
+			cancel()
+		case "exec":
+			var payload struct{ Command string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+
+			s.mutex.Lock()
+			s.commands = append(s.commands, payload.Command)
+			s.mutex.Unlock()
+
+			handler := s.handler
+			if agentForwarded && payload.Command == "ssh-add -L" {
+				handler = listForwardedAgentKeys(conn)
+			}
+
+			go func() {
+				status := handler(ctx, payload.Command, env, channel, channel, channel.Stderr())
+				if ctx.Err() != nil || s.exitSignal != "" {
+					signal := cmp.Or(s.exitSignal, "KILL")
+					channel.SendRequest("exit-signal", false, ssh.Marshal(struct {
+						Signal     string
+						CoreDumped bool
+						Error      string
+						Lang       string
+					}{Signal: signal}))
+				} else {
+					channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				}
+				channel.Close()
+			}()
+		case "subsystem":
+			var payload struct{ Name string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil || payload.Name != "sftp" {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+
+			s.mutex.Lock()
+			s.subsystems++
+			s.mutex.Unlock()
+
+			var rwc io.ReadWriteCloser = channel
+			if s.legacySFTP {
+				rwc = &legacySFTPConn{ReadWriteCloser: channel}
+			}
+
+			go func() {
+				server, err := sftp.NewServer(rwc)
+				if err == nil {
+					server.Serve()
+				}
+				channel.Close()
+			}()
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// legacySFTPConn 模拟只实现 SFTP v3 基本语义的服务器：版本响应中不声明任何扩展，
+// 目标已存在时 rename 失败而不是覆盖
+type legacySFTPConn struct {
+	io.ReadWriteCloser
+
+	// writeMutex 保证服务端的数据包和注入的响应完整写出，互不交错
+	writeMutex sync.Mutex
+	wbuf       []byte
+	versioned  bool
+
+	rbuf []byte
+}
+
+// sftp 数据包类型
+const (
+	sftpPacketVersion = 2
+	sftpPacketRename  = 18
+	sftpPacketStatus  = 101
+)
+
+func (c *legacySFTPConn) Write(p []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	// 服务端分多次写出一个数据包，凑齐后整体转发
+	c.wbuf = append(c.wbuf, p...)
+	for len(c.wbuf) >= 4 && len(c.wbuf) >= 4+int(binary.BigEndian.Uint32(c.wbuf)) {
+		n := 4 + int(binary.BigEndian.Uint32(c.wbuf))
+		packet := c.wbuf[:n]
+		if !c.versioned && packet[4] == sftpPacketVersion && n >= 9 {
+			// 只保留类型和版本号，去掉扩展名与数据对
+			packet = append(binary.BigEndian.AppendUint32(nil, 5), packet[4:9]...)
+		}
+		c.versioned = true
+
+		if _, err := c.ReadWriteCloser.Write(packet); err != nil {
+			return 0, err
+		}
+		c.wbuf = c.wbuf[n:]
+	}
+	return len(p), nil
+}
+
+func (c *legacySFTPConn) Read(p []byte) (int, error) {
+	for len(c.rbuf) == 0 {
+		var length [4]byte
+		if _, err := io.ReadFull(c.ReadWriteCloser, length[:]); err != nil {
+			return 0, err
+		}
+		packet := make([]byte, 4+binary.BigEndian.Uint32(length[:]))
+		copy(packet, length[:])
+		if _, err := io.ReadFull(c.ReadWriteCloser, packet[4:]); err != nil {
+			return 0, err
+		}
+
+		if id, exists := c.renameOverExisting(packet); exists {
+			if err := c.replyFailure(id, "target already exists"); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		c.rbuf = packet
+	}
+
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+// renameOverExisting 判断数据包是否为目标已存在的 rename 请求
+func (c *legacySFTPConn) renameOverExisting(packet []byte) (uint32, bool) {
+	if len(packet) < 13 || packet[4] != sftpPacketRename {
+		return 0, false
+	}
+	id := binary.BigEndian.Uint32(packet[5:])
+
+	// 依次为旧路径和新路径，均为 uint32 长度加内容
+	rest := packet[9:]
+	for range 2 {
+		if len(rest) < 4 || len(rest) < 4+int(binary.BigEndian.Uint32(rest)) {
+			return 0, false
+		}
+		n := 4 + int(binary.BigEndian.Uint32(rest))
+		if len(rest) == n {
+			_, err := os.Lstat(string(rest[4:n]))
+			return id, err == nil
+		}
+		rest = rest[n:]
+	}
+	return 0, false
+}
+
+// replyFailure 以 SSH_FX_FAILURE 状态响应请求
+func (c *legacySFTPConn) replyFailure(id uint32, message string) error {
+	packet := []byte{0, 0, 0, 0, sftpPacketStatus}
+	packet = binary.BigEndian.AppendUint32(packet, id)
+	packet = binary.BigEndian.AppendUint32(packet, uint32(sftp.ErrSSHFxFailure))
+	packet = binary.BigEndian.AppendUint32(packet, uint32(len(message)))
+	packet = append(packet, message...)
+	packet = binary.BigEndian.AppendUint32(packet, 0)
+	binary.BigEndian.PutUint32(packet, uint32(len(packet)-4))
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	_, err := c.ReadWriteCloser.Write(packet)
+	return err
+}
+
+// runTestCommand 使用 mvdan.cc/sh 解释执行命令
+func runTestCommand(ctx context.Context, command string, env map[string]string, stdin io.Reader, stdout, stderr io.Writer) uint32 {
+	// sh -s 从标准输入读取脚本
+	if command == "sh -s" {
+		script, err := io.ReadAll(stdin)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		command, stdin = string(script), strings.NewReader("")
+	}
+
+	file, err := syntax.NewParser().Parse(strings.NewReader(command), "")
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	pairs := make([]string, 0, len(env))
+	for k, v := range env {
+		pairs = append(pairs, k+"="+v)
+	}
+
+	runner, err := interp.New(
+		interp.Env(expand.ListEnviron(pairs...)),
+		interp.StdIO(stdin, stdout, stderr),
+		interp.ExecHandlers(bashScriptHandler(env)),
+	)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	err = runner.Run(ctx, file)
+	if status, ok := interp.IsExitStatus(err); ok {
+		return uint32(status)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// bashScriptHandler 用同一个解释器执行 bash -c 的脚本，测试不依赖系统中的 bash
+func bashScriptHandler(env map[string]string) func(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+	return func(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+		return func(ctx context.Context, args []string) error {
+			if len(args) < 3 || args[0] != "bash" || args[1] != "-c" {
+				return next(ctx, args)
+			}
+
+			hc := interp.HandlerCtx(ctx)
+			if status := runTestCommand(ctx, args[2], env, hc.Stdin, hc.Stdout, hc.Stderr); status != 0 {
+				return interp.ExitStatus(status)
+			}
+			return nil
+		}
+	}
+}
+
+// TestExecRemoteCommandWithOptions 测试分别捕获标准输出和标准错误
+func TestExecRemoteCommandWithOptions(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	testCases := []struct {
+		name        string
+		command     string
+		env         map[string]string
+		stdout      string
+		stderr      string
+		shouldError bool
+	}{
+		{
+			name:    "仅标准输出",
+			command: "echo hello",
+			stdout:  "hello\n",
+		},
+		{
+			name:    "标准输出和标准错误",
+			command: "echo out; echo warn >&2",
+			stdout:  "out\n",
+			stderr:  "warn\n",
+		},
+		{
+			name:        "失败时仅有标准错误",
+			command:     "echo failed >&2; exit 1",
+			stderr:      "failed\n",
+			shouldError: true,
+		},
+		{
+			name:    "环境变量",
+			command: "echo $REMEX_ID",
+			env:     map[string]string{remexID: "host1"},
+			stdout:  "host1\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := ExecRemoteCommandWithOptions(context.Background(), client, tc.command, ExecOptions{Env: tc.env})
+			if (err != nil) != tc.shouldError {
+				t.Fatalf("ExecRemoteCommandWithOptions() error = %v, shouldError %v", err, tc.shouldError)
+			}
+			if output.Stdout != tc.stdout {
+				t.Errorf("Stdout = %q, want %q", output.Stdout, tc.stdout)
+			}
+			if output.Stderr != tc.stderr {
+				t.Errorf("Stderr = %q, want %q", output.Stderr, tc.stderr)
+			}
+			if len(output.Combined) != len(tc.stdout)+len(tc.stderr) {
+				t.Errorf("Combined = %q, want stdout and stderr combined", output.Combined)
+			}
+		})
+	}
+
+	t.Run("兼容合并输出", func(t *testing.T) {
+		output, err := ExecRemoteCommand(context.Background(), nil, client, "", "echo out; echo err >&2", false)
+		if err != nil {
+			t.Fatalf("ExecRemoteCommand() error = %v", err)
+		}
+		if !strings.Contains(output, "out\n") || !strings.Contains(output, "err\n") {
+			t.Errorf("ExecRemoteCommand() output = %q, want combined output", output)
+		}
+	})
+}
+
+// TestExecRemoteCommandWithOptions_ExitCode 测试解析命令退出码和终止信号
+func TestExecRemoteCommandWithOptions_ExitCode(t *testing.T) {
+	testCases := []struct {
+		name       string
+		command    string
+		exitSignal string
+		exitCode   int
+		signal     string
+	}{
+		{name: "成功", command: "true", exitCode: 0},
+		{name: "退出码 1", command: "exit 1", exitCode: 1},
+		{name: "退出码 2", command: "exit 2", exitCode: 2},
+		{name: "信号终止", command: "true", exitSignal: "TERM", exitCode: 143, signal: "TERM"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newTestSSHServer(t)
+			server.exitSignal = tc.exitSignal
+			client := server.dial(t)
+
+			output, err := ExecRemoteCommandWithOptions(context.Background(), client, tc.command, ExecOptions{})
+			if (err != nil) != (tc.exitCode != 0) {
+				t.Fatalf("ExecRemoteCommandWithOptions() error = %v", err)
+			}
+			if output.ExitCode != tc.exitCode {
+				t.Errorf("ExitCode = %d, want %d", output.ExitCode, tc.exitCode)
+			}
+			if output.Signal != tc.signal {
+				t.Errorf("Signal = %q, want %q", output.Signal, tc.signal)
+			}
+		})
+	}
+}
+
+// TestExecRemoteCommandWithOptions_Context 测试超时与取消返回不同的错误并结束远程命令
+func TestExecRemoteCommandWithOptions_Context(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	killed := make(chan struct{}, 1)
+	server.handler = func(ctx context.Context, command string, env map[string]string, stdin io.Reader, stdout, stderr io.Writer) uint32 {
+		<-ctx.Done()
+		killed <- struct{}{}
+		return 0
+	}
+
+	testCases := []struct {
+		name    string
+		ctx     func() (context.Context, context.CancelFunc)
+		want    error
+		wantCtx error
+		notWant error
+	}{
+		{
+			name: "超时",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			want:    ErrTimeout,
+			wantCtx: context.DeadlineExceeded,
+			notWant: ErrCanceled,
+		},
+		{
+			name: "取消",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			want:    ErrCanceled,
+			wantCtx: context.Canceled,
+			notWant: ErrTimeout,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := tc.ctx()
+			defer cancel()
+
+			_, err := ExecRemoteCommandWithOptions(ctx, client, "slow", ExecOptions{})
+			if !errors.Is(err, tc.want) || !errors.Is(err, tc.wantCtx) {
+				t.Errorf("ExecRemoteCommandWithOptions() error = %v, want %v and %v", err, tc.want, tc.wantCtx)
+			}
+			if errors.Is(err, tc.notWant) {
+				t.Errorf("ExecRemoteCommandWithOptions() error = %v, should not match %v", err, tc.notWant)
+			}
+
+			select {
+			case <-killed:
+			case <-time.After(time.Second):
+				t.Error("remote command was not killed")
+			}
+		})
+	}
+}
+
+// TestSSHConfig_Host 测试通过主机名连接
+func TestSSHConfig_Host(t *testing.T) {
+	server := newTestSSHServer(t)
+
+	config := server.sshConfig()
+	config.Addr = netip.Addr{}
+	config.Host = "localhost"
+
+	if got, want := config.String(), fmt.Sprintf("testuser@localhost:%d", server.addr.Port()); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	client, err := NewSSHClient("host1", config)
+	if err != nil {
+		t.Fatalf("NewSSHClient() error = %v", err)
+	}
+	defer client.Close()
+
+	if got := client.RemoteAddr(); got != server.addr {
+		t.Errorf("RemoteAddr() = %v, want %v", got, server.addr)
+	}
+	if _, err := client.ExecuteCommand(context.Background(), "echo ok"); err != nil {
+		t.Errorf("ExecuteCommand() error = %v", err)
+	}
+}
+
+// TestSSHConfig_Validate 测试连接前的配置校验
+func TestSSHConfig_Validate(t *testing.T) {
+	valid := func() *SSHConfig {
+		return NewSSHConfig(netip.MustParseAddr("192.168.1.1"), "testuser", "testpass")
+	}
+
+	testCases := []struct {
+		name      string
+		modify    func(config *SSHConfig)
+		wantField string
+	}{
+		{name: "有效配置", modify: func(config *SSHConfig) {}},
+		{name: "空用户名", modify: func(config *SSHConfig) { config.Username = "" }, wantField: "Username"},
+		{name: "无效地址", modify: func(config *SSHConfig) { config.Addr = netip.Addr{} }, wantField: "Addr"},
+		{name: "使用主机名", modify: func(config *SSHConfig) { config.Addr, config.Host = netip.Addr{}, "localhost" }},
+		{name: "端口为零", modify: func(config *SSHConfig) { config.Port = 0 }, wantField: "Port"},
+		{name: "缺少认证方式", modify: func(config *SSHConfig) { config.Password = "" }, wantField: "Password"},
+		{name: "私钥认证", modify: func(config *SSHConfig) { config.Password, config.PrivateKey = "", []byte("key") }},
+		{name: "键盘交互认证", modify: func(config *SSHConfig) {
+			config.Password = ""
+			config.KeyboardInteractive = func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+				return nil, nil
+			}
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := valid()
+			tc.modify(config)
+
+			err := config.Validate()
+			if tc.wantField == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+
+			var configErr *ConfigError
+			if !errors.As(err, &configErr) || configErr.Field != tc.wantField {
+				t.Errorf("Validate() error = %v, want ConfigError for %s", err, tc.wantField)
+			}
+		})
+	}
+
+	t.Run("连接前校验", func(t *testing.T) {
+		config := valid()
+		config.Username = ""
+		config.Dialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			t.Error("Dialer called for an invalid config")
+			return nil, errors.New("unexpected dial")
+		}
+
+		var configErr *ConfigError
+		if _, err := config.Connect(); !errors.As(err, &configErr) || configErr.Field != "Username" {
+			t.Errorf("Connect() error = %v, want ConfigError for Username", err)
+		}
+		if _, err := NewSSHClient("host1", config); !errors.As(err, &configErr) {
+			t.Errorf("NewSSHClient() error = %v, want ConfigError", err)
+		}
+	})
+}
+
+// TestSSHConfig_Dialer 测试通过自定义拨号器建立连接
+func TestSSHConfig_Dialer(t *testing.T) {
+	server := newTestSSHServer(t)
+
+	var dialed []string
+	config := server.sshConfig()
+	config.Host = "target.internal"
+	config.Dialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("dialer context has no deadline")
+		}
+		dialed = append(dialed, network+" "+addr)
+
+		// 模拟代理：目标地址只能经由代理访问
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", server.addr.String())
+	}
+
+	client, err := NewSSHClient("host1", config)
+	if err != nil {
+		t.Fatalf("NewSSHClient() error = %v", err)
+	}
+	defer client.Close()
+
+	if output, err := client.ExecuteCommand(context.Background(), "echo ok"); err != nil || output != "ok\n" {
+		t.Errorf("ExecuteCommand() = %q, %v", output, err)
+	}
+	if want := []string{fmt.Sprintf("tcp target.internal:%d", server.addr.Port())}; !slices.Equal(dialed, want) {
+		t.Errorf("dialed = %q, want %q", dialed, want)
+	}
+
+	errProxy := errors.New("proxy refused connection")
+	config.Dialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, errProxy
+	}
+	if _, err := config.Connect(); !errors.Is(err, errProxy) {
+		t.Errorf("Connect() error = %v, want %v", err, errProxy)
+	}
+}
+
+// TestSSHConfig_ConnectContext 测试取消 context 时立即中断握手
+func TestSSHConfig_ConnectContext(t *testing.T) {
+	// 只接受 TCP 连接而不响应 SSH 握手的服务器
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	config := &SSHConfig{
+		Username:              "testuser",
+		Password:              "testpass",
+		Addr:                  netip.MustParseAddr("127.0.0.1"),
+		Port:                  uint16(listener.Addr().(*net.TCPAddr).Port),
+		InsecureIgnoreHostKey: true,
+		ConnectTimeout:        time.Minute,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		conn := <-accepted
+		defer conn.Close()
+		cancel()
+		time.Sleep(time.Second)
+	}()
+
+	start := time.Now()
+	_, err = config.ConnectContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ConnectContext() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("ConnectContext() returned after %v, want prompt return on cancel", elapsed)
+	}
+
+	t.Run("已取消", func(t *testing.T) {
+		if _, err := config.ConnectContext(ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("ConnectContext() error = %v, want context.Canceled", err)
+		}
+	})
+}
+
+// TestSSHConfig_Algorithms 测试限制协商的加密算法
+func TestSSHConfig_Algorithms(t *testing.T) {
+	server := newTestSSHServer(t)
+
+	tests := []struct {
+		name       string
+		algorithms Algorithms
+		wantErr    string
+	}{
+		{
+			name: "指定算法",
+			algorithms: Algorithms{
+				Ciphers:           []string{"aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com"},
+				KeyExchanges:      []string{"curve25519-sha256"},
+				MACs:              []string{"hmac-sha2-512-etm@openssh.com"},
+				HostKeyAlgorithms: []string{"ssh-ed25519"},
+			},
+		},
+		{
+			name:       "未知算法",
+			algorithms: Algorithms{Ciphers: []string{"aes256-gcm@openssh.com", "rot13"}},
+			wantErr:    `unsupported cipher algorithm "rot13"`,
+		},
+		{
+			name:       "不安全的算法需显式指定",
+			algorithms: Algorithms{MACs: []string{"hmac-sha1-96"}},
+		},
+		{
+			name:       "无共同算法",
+			algorithms: Algorithms{HostKeyAlgorithms: []string{"rsa-sha2-512"}},
+			wantErr:    "no common algorithm for host key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := server.sshConfig()
+			config.Algorithms = tt.algorithms
+
+			client, err := NewSSHClient("host1", config)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewSSHClient() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewSSHClient() error = %v", err)
+			}
+			defer client.Close()
+
+			if _, err := client.ExecuteCommand(context.Background(), "echo ok"); err != nil {
+				t.Errorf("ExecuteCommand() error = %v", err)
+			}
+		})
+	}
+}
+
+// TestSSHConfig_ClientVersion 测试发送自定义客户端版本字符串
+func TestSSHConfig_ClientVersion(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+
+	banner := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		banner <- line
+	}()
+
+	config := &SSHConfig{
+		Username:              "testuser",
+		Password:              "testpass",
+		Addr:                  netip.MustParseAddr("127.0.0.1"),
+		Port:                  uint16(listener.Addr().(*net.TCPAddr).Port),
+		InsecureIgnoreHostKey: true,
+		ClientVersion:         "SSH-2.0-remex_1.0",
+	}
+	config.Connect()
+
+	if got := <-banner; got != "SSH-2.0-remex_1.0\r\n" {
+		t.Errorf("client banner = %q, want %q", got, "SSH-2.0-remex_1.0\r\n")
+	}
+
+	config.ClientVersion = "remex_1.0"
+	if _, err := config.Connect(); err == nil || !strings.Contains(err.Error(), "must start with SSH-2.0-") {
+		t.Errorf("Connect() error = %v, want invalid client version", err)
+	}
+}
+
+// TestSSHConfig_BannerCallback 测试接收服务器的登录横幅
+func TestSSHConfig_BannerCallback(t *testing.T) {
+	server := newTestSSHServer(t)
+
+	var banners []string
+	config := server.sshConfig()
+	config.BannerCallback = func(message string) error {
+		banners = append(banners, message)
+		return nil
+	}
+
+	client, err := config.Connect()
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	client.Close()
+
+	if want := []string{testBanner}; !slices.Equal(banners, want) {
+		t.Errorf("banners = %q, want %q", banners, want)
+	}
+
+	errBanner := errors.New("banner rejected")
+	config.BannerCallback = func(message string) error { return errBanner }
+	if _, err := config.Connect(); !errors.Is(err, errBanner) {
+		t.Errorf("Connect() error = %v, want %v", err, errBanner)
+	}
+}
+
+// TestSSHConfig_Zone 测试带区域的 IPv6 地址
+func TestSSHConfig_Zone(t *testing.T) {
+	config := NewSSHConfig(netip.MustParseAddr("fe80::1%eth0"), "testuser", "testpass")
+	if got, want := config.dialAddr(), "[fe80::1%eth0]:22"; got != want {
+		t.Errorf("dialAddr() = %q, want %q", got, want)
+	}
+	if got, want := config.String(), "testuser@[fe80::1%eth0]:22"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	var zone string
+	if interfaces, err := net.Interfaces(); err == nil {
+		for _, iface := range interfaces {
+			if iface.Flags&net.FlagLoopback != 0 {
+				zone = iface.Name
+				break
+			}
+		}
+	}
+	if zone == "" {
+		t.Skip("no loopback interface")
+	}
+	if listener, err := net.Listen("tcp", "[::1]:0"); err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	} else {
+		listener.Close()
+	}
+
+	server := newTestSSHServerOn(t, "[::1]:0")
+	config = server.sshConfig()
+	config.Addr = config.Addr.WithZone(zone)
+
+	client, err := NewSSHClient("host1", config)
+	if err != nil {
+		t.Fatalf("NewSSHClient() error = %v", err)
+	}
+	defer client.Close()
+
+	if got := client.RemoteAddr(); got.Addr().Zone() != zone || got.Port() != server.addr.Port() {
+		t.Errorf("RemoteAddr() = %v, want zone %s", got, zone)
+	}
+	if _, err := client.ExecuteCommand(context.Background(), "echo ok"); err != nil {
+		t.Errorf("ExecuteCommand() error = %v", err)
+	}
+}
+
+// TestSSHClient_Env 测试主机环境变量，服务器拒绝 Setenv 时改为在远程 shell 中导出
+func TestSSHClient_Env(t *testing.T) {
+	for _, rejectEnv := range []bool{false, true} {
+		t.Run(fmt.Sprintf("rejectEnv=%v", rejectEnv), func(t *testing.T) {
+			server := newTestSSHServer(t)
+			server.rejectEnv = rejectEnv
+
+			config := server.sshConfig()
+			config.Env = map[string]string{"GREETING": "it's $HOME", "REMEX_ID": "ignored"}
+
+			client, err := NewSSHClient("host1", config)
+			if err != nil {
+				t.Fatalf("NewSSHClient() error = %v", err)
+			}
+			defer client.Close()
+
+			output, err := client.ExecuteCommand(context.Background(), `echo "$GREETING" $REMEX_ID`)
+			if err != nil {
+				t.Fatalf("ExecuteCommand() error = %v", err)
+			}
+			if want := "it's $HOME host1\n"; output != want {
+				t.Errorf("ExecuteCommand() = %q, want %q", output, want)
+			}
+		})
+	}
+}
+
+// TestSSHClient_ExecuteBatch 测试在同一会话中批量执行命令
+func TestSSHClient_ExecuteBatch(t *testing.T) {
+	server := newTestSSHServer(t)
+
+	client, err := NewSSHClient("host1", server.sshConfig())
+	if err != nil {
+		t.Fatalf("NewSSHClient() error = %v", err)
+	}
+	defer client.Close()
+	sc := client.(*SSHClient)
+
+	t.Run("全部成功", func(t *testing.T) {
+		output, err := sc.ExecuteBatch(context.Background(), []string{"NAME=web", "echo $NAME # 注释", "echo done"})
+		if err != nil {
+			t.Fatalf("ExecuteBatch() error = %v", err)
+		}
+		if want := "web\ndone\n"; output != want {
+			t.Errorf("ExecuteBatch() = %q, want %q", output, want)
+		}
+		if got := len(server.executed()); got != 1 {
+			t.Errorf("sessions = %d, want 1", got)
+		}
+	})
+
+	t.Run("在首个失败的命令处停止", func(t *testing.T) {
+		output, err := sc.ExecuteBatch(context.Background(), []string{"echo one", "echo -n oops; exit 3", "echo never"})
+
+		var batchErr *BatchError
+		if !errors.As(err, &batchErr) {
+			t.Fatalf("ExecuteBatch() error = %v, want *BatchError", err)
+		}
+		if batchErr.Index != 1 || batchErr.Command != "echo -n oops; exit 3" {
+			t.Errorf("BatchError = %+v", batchErr)
+		}
+		if code, _ := exitStatus(err); code != 3 {
+			t.Errorf("exit code = %d, want 3", code)
+		}
+		if want := "one\noops"; output != want {
+			t.Errorf("ExecuteBatch() = %q, want %q", output, want)
+		}
+	})
+
+	t.Run("使用 bash -c 执行", func(t *testing.T) {
+		before := len(server.executed())
+		if _, err := sc.ExecuteBatch(context.Background(), []string{"echo ok"}); err != nil {
+			t.Fatalf("ExecuteBatch() error = %v", err)
+		}
+
+		executed := server.executed()[before:]
+		if len(executed) != 1 || !strings.HasPrefix(executed[0], "bash -c '") {
+			t.Fatalf("executed %q, want a single bash -c command", executed)
+		}
+		script, err := syntax.NewParser().Parse(strings.NewReader(executed[0]), "")
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", executed[0], err)
+		}
+		call, ok := script.Stmts[0].Cmd.(*syntax.CallExpr)
+		if len(script.Stmts) != 1 || !ok || len(call.Args) != 3 {
+			t.Fatalf("executed %q, want bash -c with the script as a single argument", executed[0])
+		}
+		body, err := expand.Literal(nil, call.Args[2])
+		if err != nil || !strings.HasPrefix(body, "trap ") || !strings.Contains(body, "{ echo ok\n}") {
+			t.Errorf("bash -c script = %q, %v, want the batch script", body, err)
+		}
+	})
+
+	t.Run("无效命令", func(t *testing.T) {
+		for _, commands := range [][]string{nil, {"echo ok", " "}, {"remex.mkdir /tmp/a"}} {
+			if _, err := sc.ExecuteBatch(context.Background(), commands); err == nil {
+				t.Errorf("ExecuteBatch(%q) error = nil", commands)
+			}
+		}
+	})
+}
+
+// TestQuoteCommand 测试引用后的参数在远程 shell 中保持原样
+func TestQuoteCommand(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	args := []string{"plain", "with space", "it's", `"double"`, "$HOME", "`id`", "a;b && c", "line\nbreak\ttab", ""}
+
+	command, err := QuoteCommand("printf", append([]string{"[%s]"}, args...)...)
+	if err != nil {
+		t.Fatalf("QuoteCommand() error = %v", err)
+	}
+
+	output, err := ExecRemoteCommandWithOptions(context.Background(), client, command, ExecOptions{})
+	if err != nil {
+		t.Fatalf("ExecRemoteCommandWithOptions(%q) error = %v", command, err)
+	}
+
+	var want strings.Builder
+	for _, arg := range args {
+		want.WriteString("[" + arg + "]")
+	}
+	if output.Stdout != want.String() {
+		t.Errorf("Stdout = %q, want %q", output.Stdout, want.String())
+	}
+
+	if quoted, err := QuoteArg("plain"); err != nil || quoted != "plain" {
+		t.Errorf("QuoteArg(plain) = %q, %v, want it unchanged", quoted, err)
+	}
+	if _, err := QuoteArg("nul\x00byte"); err == nil {
+		t.Error("QuoteArg() with a null byte error = nil, want error")
+	}
+}
+
+// TestExecRemoteCommandWithOptions_MaxOutputBytes 测试限制捕获的输出大小
+func TestExecRemoteCommandWithOptions_MaxOutputBytes(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	const command = "i=0; while [ $i -lt 100 ]; do echo 0123456789; i=$((i+1)); done; echo err >&2"
+
+	var lines atomic.Int32
+	output, err := ExecRemoteCommandWithOptions(context.Background(), client, command, ExecOptions{
+		MaxOutputBytes: 25,
+		OnLine:         func(Stream, string) { lines.Add(1) },
+	})
+	if err != nil {
+		t.Fatalf("ExecRemoteCommandWithOptions() error = %v", err)
+	}
+
+	if !output.Truncated {
+		t.Error("Truncated = false, want true")
+	}
+	if want := "0123456789\n0123456789\n012" + truncatedMarker; output.Stdout != want {
+		t.Errorf("Stdout = %q, want %q", output.Stdout, want)
+	}
+	if output.Stderr != "err\n" {
+		t.Errorf("Stderr = %q, want %q", output.Stderr, "err\n")
+	}
+	if want := 25 + len(truncatedMarker); len(output.Combined) != want {
+		t.Errorf("len(Combined) = %d, want %d", len(output.Combined), want)
+	}
+	if n := lines.Load(); n != 101 {
+		t.Errorf("OnLine called %d times, want every line", n)
+	}
+
+	output, err = ExecRemoteCommandWithOptions(context.Background(), client, "echo small", ExecOptions{MaxOutputBytes: 25})
+	if err != nil || output.Truncated || output.Stdout != "small\n" {
+		t.Errorf("ExecRemoteCommandWithOptions() = %+v, %v, want untruncated output", output, err)
+	}
+}
+
+// TestExecRemoteCommandTo 测试把输出直接写入 io.Writer
+func TestExecRemoteCommandTo(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	t.Run("同一个 Writer", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := ExecRemoteCommandTo(context.Background(), client, "echo out; echo err >&2; echo out", &b, &b); err != nil {
+			t.Fatalf("ExecRemoteCommandTo() error = %v", err)
+		}
+		// 两个流并发写入，行内容可能交错
+		if got := b.String(); strings.Count(got, "out") != 2 || strings.Count(got, "err") != 1 || strings.Count(got, "\n") != 3 {
+			t.Errorf("output = %q, want both streams", got)
+		}
+	})
+
+	t.Run("丢弃标准错误", func(t *testing.T) {
+		var stdout bytes.Buffer
+		err := ExecRemoteCommandTo(context.Background(), client, "echo out; echo err >&2; exit 3", &stdout, nil)
+		if code, _ := exitStatus(err); code != 3 {
+			t.Errorf("ExecRemoteCommandTo() error = %v, want exit status 3", err)
+		}
+		if stdout.String() != "out\n" {
+			t.Errorf("stdout = %q, want %q", stdout.String(), "out\n")
+		}
+	})
+
+	t.Run("边运行边写入", func(t *testing.T) {
+		stdinReader, stdinWriter := io.Pipe()
+		defer stdinWriter.Close()
+
+		// 漏掉触发时超时失败，而不是一直等待标准输入
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var (
+			mutex   sync.Mutex
+			written strings.Builder
+			once    sync.Once
+		)
+		output, err := ExecRemoteCommandWithOptions(ctx, client, "echo first; read x; echo $x", ExecOptions{
+			Stdin: stdinReader,
+			Stdout: writerFunc(func(p []byte) (int, error) {
+				mutex.Lock()
+				written.Write(p)
+				first := strings.Contains(written.String(), "first\n")
+				mutex.Unlock()
+
+				if first {
+					// 收到第一行后命令仍在等待标准输入，写入可能被任意拆分
+					once.Do(func() {
+						go func() {
+							fmt.Fprintln(stdinWriter, "second")
+							stdinWriter.Close()
+						}()
+					})
+				}
+				return len(p), nil
+			}),
+		})
+		if err != nil {
+			t.Fatalf("ExecRemoteCommandWithOptions() error = %v", err)
+		}
+		if output.Stdout != "" {
+			t.Errorf("Stdout = %q, want it empty when written to a writer", output.Stdout)
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		if got := written.String(); got != "first\nsecond\n" {
+			t.Errorf("written %q, want %q", got, "first\nsecond\n")
+		}
+	})
+}
+
+// writerFunc 把函数适配为 io.Writer
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// TestExecRemoteCommandWithOptions_PTY 测试为命令分配伪终端
+func TestExecRemoteCommandWithOptions_PTY(t *testing.T) {
+	testCases := []struct {
+		name string
+		pty  *PTYOptions
+		want []string
+	}{
+		{name: "不分配伪终端"},
+		{name: "默认终端", pty: &PTYOptions{}, want: []string{"xterm 80x24"}},
+		{name: "自定义终端", pty: &PTYOptions{Term: "vt100", Width: 120, Height: 40}, want: []string{"vt100 120x40"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newTestSSHServer(t)
+			client := server.dial(t)
+
+			output, err := ExecRemoteCommandWithOptions(context.Background(), client, "echo hello", ExecOptions{PTY: tc.pty})
+			if err != nil {
+				t.Fatalf("ExecRemoteCommandWithOptions() error = %v", err)
+			}
+			if output.Stdout != "hello\n" {
+				t.Errorf("Stdout = %q, want %q", output.Stdout, "hello\n")
+			}
+			if got := server.requestedPTYs(); !slices.Equal(got, tc.want) {
+				t.Errorf("requested ptys = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestExecRemoteCommandWithOptions_OnLine 测试逐行回调命令输出
+func TestExecRemoteCommandWithOptions_OnLine(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	var lines []string
+	output, err := ExecRemoteCommandWithOptions(context.Background(), client,
+		"echo one; echo warn >&2; printf 'two\\nthree'", ExecOptions{
+			OnLine: func(stream Stream, line string) {
+				lines = append(lines, stream.String()+": "+line)
+			},
+		})
+	if err != nil {
+		t.Fatalf("ExecRemoteCommandWithOptions() error = %v", err)
+	}
+
+	if output.Stdout != "one\ntwo\nthree" || output.Stderr != "warn\n" {
+		t.Errorf("output = %+v, want full stdout and stderr", output)
+	}
+	if len(output.Combined) != len(output.Stdout)+len(output.Stderr) {
+		t.Errorf("Combined = %q, want stdout and stderr combined", output.Combined)
+	}
+
+	slices.Sort(lines)
+	want := []string{"stderr: warn", "stdout: one", "stdout: three", "stdout: two"}
+	if !slices.Equal(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+}
+
+// TestRemex_WithStreamOutput 测试处理器逐行收到命令输出
+func TestRemex_WithStreamOutput(t *testing.T) {
+	server := newTestSSHServer(t)
+
+	r := newMockRemex(t, []string{"host1"}, func(id string, _ *SSHConfig) (RemoteClient, error) {
+		return NewSSHClient(id, server.sshConfig())
+	}, WithStreamOutput())
+	defer r.Close()
+
+	var (
+		mutex  sync.Mutex
+		stages []Stage
+		lines  []string
+	)
+	r.RegisterHandler(func(result ExecResult) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		stages = append(stages, result.Stage)
+		if result.Stage == StageOutput {
+			lines = append(lines, result.Stdout)
+		}
+	})
+
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if err := r.Execute([]string{"echo building; echo done"}); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	if want := []Stage{StageConnected, StageStart, StageOutput, StageOutput, StageFinish}; !slices.Equal(stages, want) {
+		t.Errorf("stages = %v, want %v", stages, want)
+	}
+	if want := []string{"building", "done"}; !slices.Equal(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+}
+
+// TestExecRemoteCommandWithOptions_Stdin 测试为远程命令提供标准输入
+func TestExecRemoteCommandWithOptions_Stdin(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	testCases := []struct {
+		name    string
+		command string
+		opts    ExecOptions
+		stdout  string
+	}{
+		{
+			name:    "读取标准输入",
+			command: "while read line; do echo got $line; done",
+			opts:    ExecOptions{Stdin: strings.NewReader("a\nb\n")},
+			stdout:  "got a\ngot b\n",
+		},
+		{
+			name:    "命令不读取未结束的标准输入",
+			command: "echo done",
+			opts:    ExecOptions{Stdin: blockingReader{}},
+			stdout:  "done\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := ExecRemoteCommandWithOptions(context.Background(), client, tc.command, tc.opts)
+			if err != nil {
+				t.Fatalf("ExecRemoteCommandWithOptions() error = %v", err)
+			}
+			if output.Stdout != tc.stdout {
+				t.Errorf("Stdout = %q, want %q", output.Stdout, tc.stdout)
+			}
+		})
+	}
+}
+
+// blockingReader 的 Read 永远阻塞
+type blockingReader struct{}
+
+func (blockingReader) Read([]byte) (int, error) {
+	select {}
+}
+
+// fakeSudo 模拟 sudo：输出 prompt 后从标准输入读取密码，再执行剩余命令，prompt 为空时模拟已缓存的凭据
+func fakeSudo(prompt string) testCommandHandler {
+	return func(ctx context.Context, command string, env map[string]string, stdin io.Reader, stdout, stderr io.Writer) uint32 {
+		fields := strings.Fields(command)
+		for len(fields) > 0 && isEnvAssignment(fields[0]) {
+			fields = fields[1:]
+		}
+		fields = fields[1:]
+		for len(fields) > 0 && strings.HasPrefix(fields[0], "-") {
+			fields = fields[1:]
+		}
+
+		if prompt != "" {
+			fmt.Fprint(stderr, prompt)
+
+			var password []byte
+			for b := make([]byte, 1); ; {
+				if _, err := stdin.Read(b); err != nil || b[0] == '\n' {
+					break
+				}
+				password = append(password, b[0])
+			}
+			if string(password) != "secret" {
+				fmt.Fprintln(stderr, "Sorry, try again.")
+				return 1
+			}
+		}
+
+		return runTestCommand(ctx, strings.Join(fields, " "), env, stdin, stdout, stderr)
+	}
+}
+
+// TestExecRemoteCommandWithOptions_Sudo 测试检测到密码提示后才写入 sudo 密码
+func TestExecRemoteCommandWithOptions_Sudo(t *testing.T) {
+	timeout := DefaultSudoPromptTimeout
+	DefaultSudoPromptTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { DefaultSudoPromptTimeout = timeout })
+
+	const sudoPrompt = "[sudo] password for testuser: "
+
+	testCases := []struct {
+		name        string
+		prompt      string
+		command     string
+		opts        ExecOptions
+		delay       time.Duration
+		stdout      string
+		shouldError bool
+	}{
+		{
+			name:    "sudo -S",
+			prompt:  sudoPrompt,
+			command: "sudo -S echo ok",
+			stdout:  "ok\n",
+		},
+		{
+			name:    "环境变量与标准输入",
+			prompt:  sudoPrompt,
+			command: "LANG=C sudo -i read line; echo $line",
+			opts:    ExecOptions{Stdin: strings.NewReader("data\n")},
+			stdout:  "data\n",
+		},
+		{
+			name:    "凭据已缓存时不写入密码",
+			command: "sudo read line; echo $line",
+			opts:    ExecOptions{Stdin: strings.NewReader("data\n")},
+			stdout:  "data\n",
+		},
+		{
+			name:    "没有标准输入时等待较慢的提示",
+			prompt:  sudoPrompt,
+			command: "sudo -S echo ok",
+			delay:   200 * time.Millisecond,
+			stdout:  "ok\n",
+		},
+		{
+			name:    "单次调用的提示超时",
+			prompt:  sudoPrompt,
+			command: "sudo -S read line; echo $line",
+			opts:    ExecOptions{Stdin: strings.NewReader("data\n"), SudoPromptTimeout: 10 * time.Second},
+			delay:   200 * time.Millisecond,
+			stdout:  "data\n",
+		},
+		{
+			name:    "凭据已缓存且没有标准输入",
+			command: "sudo echo ok",
+			stdout:  "ok\n",
+		},
+		{
+			name:    "doas",
+			prompt:  "doas (testuser@host) password: ",
+			command: "doas echo ok",
+			stdout:  "ok\n",
+		},
+		{
+			name:    "自定义命令",
+			prompt:  "Password: ",
+			command: "pfexec echo ok",
+			opts:    ExecOptions{SudoCommands: []string{"pfexec"}},
+			stdout:  "ok\n",
+		},
+		{
+			name:        "未启用自动密码",
+			prompt:      sudoPrompt,
+			command:     "sudo -S echo ok",
+			opts:        ExecOptions{AutoRootPassword: false},
+			shouldError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newTestSSHServer(t)
+			sudo := fakeSudo(tc.prompt)
+			server.handler = func(ctx context.Context, command string, env map[string]string, stdin io.Reader, stdout, stderr io.Writer) uint32 {
+				// 模拟加载较慢的主机或 PAM 查询
+				time.Sleep(tc.delay)
+				return sudo(ctx, command, env, stdin, stdout, stderr)
+			}
+			client := server.dial(t)
+
+			opts := tc.opts
+			opts.Password = "secret"
+			opts.AutoRootPassword = !tc.shouldError
+
+			output, err := ExecRemoteCommandWithOptions(context.Background(), client, tc.command, opts)
+			if (err != nil) != tc.shouldError {
+				t.Fatalf("ExecRemoteCommandWithOptions() error = %v, shouldError %v, output = %+v", err, tc.shouldError, output)
+			}
+			if output.Stdout != tc.stdout {
+				t.Errorf("Stdout = %q, want %q", output.Stdout, tc.stdout)
+			}
+			if strings.Contains(output.Combined, "secret") {
+				t.Errorf("output leaked the password: %q", output.Combined)
+			}
+		})
+	}
+}
+
+// TestIsSudoCommand 测试识别需要 root 密码的命令
+func TestIsSudoCommand(t *testing.T) {
+	testCases := []struct {
+		command  string
+		names    []string
+		expected bool
+	}{
+		{command: "sudo systemctl restart nginx", expected: true},
+		{command: "sudo -S ls", expected: true},
+		{command: "/usr/bin/sudo -i", expected: true},
+		{command: "LANG=C DEBUG=1 sudo ls", expected: true},
+		{command: "env PATH=/bin sudo ls", expected: true},
+		{command: "doas reboot", expected: true},
+		{command: "sudoedit /etc/hosts"},
+		{command: "echo sudo"},
+		{command: "=x sudo ls"},
+		{command: "pfexec ls", names: []string{"pfexec"}, expected: true},
+		{command: "sudo ls", names: []string{"pfexec"}},
+		{command: ""},
+	}
+
+	for _, tc := range testCases {
+		if got := isSudoCommand(tc.command, tc.names); got != tc.expected {
+			t.Errorf("isSudoCommand(%q, %v) = %v, want %v", tc.command, tc.names, got, tc.expected)
+		}
+	}
+}
+
+// TestSentinelErrors 测试常见错误可以通过 errors.Is 判断
+func TestSentinelErrors(t *testing.T) {
+	server := newTestSSHServer(t)
+	client := server.dial(t)
+
+	r := newMockRemex(t, []string{"host1"}, nil)
+	if err := r.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	testCases := []struct {
+		name string
+		run  func() error
+		want error
+	}{
+		{
+			name: "ExecRemoteCommandWithOptions 客户端为空",
+			run: func() error {
+				_, err := ExecRemoteCommandWithOptions(context.Background(), nil, "true", ExecOptions{})
+				return err
+			},
+			want: ErrClientNil,
+		},
+		{
+			name: "ExecRemoteCommandWithOptions 命令为空",
+			run: func() error {
+				_, err := ExecRemoteCommandWithOptions(context.Background(), client, "  ", ExecOptions{})
+				return err
+			},
+			want: ErrEmptyCommand,
+		},
+		{
+			name: "ExecRemexCommand 客户端为空",
+			run: func() error {
+				_, err := ExecRemexCommand(context.Background(), nil, "remex.mkdir /tmp")
+				return err
+			},
+			want: ErrClientNil,
+		},
+		{
+			name: "ExecRemexCommand 命令为空",
+			run: func() error {
+				_, err := ExecRemexCommand(context.Background(), client, " ")
+				return err
+			},
+			want: ErrEmptyCommand,
+		},
+		{
+			name: "未注册的命令",
+			run: func() error {
+				_, err := ExecRemexCommand(context.Background(), client, "remex.unknown")
+				return err
+			},
+			want: ErrUnknownCommand,
+		},
+		{
+			name: "未连接的 SSHClient",
+			run: func() error {
+				_, err := (&SSHClient{}).ExecuteCommand(context.Background(), "true")
+				return err
+			},
+			want: ErrClientNil,
+		},
+		{
+			name: "ExecuteWithID 未知主机",
+			run: func() error {
+				_, err := r.ExecuteWithID("host9", "true")
+				return err
+			},
+			want: ErrNoClientForID,
+		},
+		{
+			name: "ExecuteOn 未知主机",
+			run:  func() error { return r.ExecuteOn([]string{"host9"}, []string{"true"}) },
+			want: ErrNoClientForID,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.run(); !errors.Is(err, tc.want) {
+				t.Errorf("error = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+// TestParseUname 测试解析 uname 输出为 GOOS 和 GOARCH 名称
+func TestParseUname(t *testing.T) {
+	testCases := []struct {
+		output   string
+		os, arch string
+		ok       bool
+	}{
+		{output: "Linux x86_64\n", os: "linux", arch: "amd64", ok: true},
+		{output: "Linux aarch64\n", os: "linux", arch: "arm64", ok: true},
+		{output: "Linux armv7l\n", os: "linux", arch: "arm", ok: true},
+		{output: "Linux armv8l\n", os: "linux", arch: "arm", ok: true},
+		{output: "Linux armv6l\n", os: "linux", arch: "arm", ok: true},
+		{output: "Linux i686\n", os: "linux", arch: "386", ok: true},
+		{output: "Darwin arm64\n", os: "darwin", arch: "arm64", ok: true},
+		{output: "FreeBSD amd64\n", os: "freebsd", arch: "amd64", ok: true},
+		{output: "SunOS i86pc\n", os: "solaris", arch: "386", ok: true},
+		{output: "MINGW64_NT-10.0-19045 x86_64\n", os: "windows", arch: "amd64", ok: true},
+		{output: "Windows_NT AMD64\r\n", os: "windows", arch: "amd64", ok: true},
+		{output: "Linux riscv64\n", os: "linux", arch: "riscv64", ok: true},
+		{output: "%OS% %PROCESSOR_ARCHITECTURE%\n", ok: false},
+		{output: "Linux\n", ok: false},
+		{output: "", ok: false},
+	}
+
+	for _, tc := range testCases {
+		os, arch, ok := parseUname(tc.output)
+		if os != tc.os || arch != tc.arch || ok != tc.ok {
+			t.Errorf("parseUname(%q) = %q, %q, %v, want %q, %q, %v", tc.output, os, arch, ok, tc.os, tc.arch, tc.ok)
+		}
+	}
+}
+
+// TestRemoteUname 测试检测远程平台，没有 uname 时回退到 Windows 环境变量
+func TestRemoteUname(t *testing.T) {
+	t.Run("Windows 回退", func(t *testing.T) {
+		client := &mockClient{id: "win", execute: func(ctx context.Context, cmd string) (string, error) {
+			if strings.HasPrefix(cmd, "uname") {
+				return "'uname' is not recognized as an internal or external command", errors.New("exit status 1")
+			}
+			return "Windows_NT ARM64\r\n", nil
+		}}
+
+		os, arch, err := RemoteUname(context.Background(), client)
+		if err != nil || os != "windows" || arch != "arm64" {
+			t.Errorf("RemoteUname() = %q, %q, %v, want windows, arm64", os, arch, err)
+		}
+	})
+
+	t.Run("无法检测", func(t *testing.T) {
+		server := newTestSSHServer(t)
+		client, err := NewSSHClient("host1", server.sshConfig())
+		if err != nil {
+			t.Fatalf("NewSSHClient() error = %v", err)
+		}
+		defer client.Close()
+
+		// 测试服务器没有 uname，echo 原样输出 %OS%
+		if _, _, err := RemoteUname(context.Background(), client); err == nil || !strings.Contains(err.Error(), "failed to detect remote platform") {
+			t.Errorf("RemoteUname() error = %v, want detection failure", err)
+		}
+	})
+}
+
+// TestSSHClient_Uname 测试每个连接只检测一次远程平台
+func TestSSHClient_Uname(t *testing.T) {
+	server := newTestSSHServer(t)
+	server.handler = func(ctx context.Context, command string, env map[string]string, stdin io.Reader, stdout, stderr io.Writer) uint32 {
+		fmt.Fprintln(stdout, "Linux aarch64")
+		return 0
+	}
+
+	client, err := NewSSHClient("host1", server.sshConfig())
+	if err != nil {
+		t.Fatalf("NewSSHClient() error = %v", err)
+	}
+	defer client.Close()
+	sc := client.(*SSHClient)
+
+	for range 3 {
+		os, arch, err := sc.Uname(context.Background())
+		if err != nil || os != "linux" || arch != "arm64" {
+			t.Fatalf("Uname() = %q, %q, %v, want linux, arm64", os, arch, err)
+		}
+	}
+	if got := server.executed(); !slices.Equal(got, []string{"uname -s -m"}) {
+		t.Errorf("executed = %q, want a single uname", got)
+	}
+}
+
+// newEchoServer 启动一个原样返回数据的 TCP 服务器
+func newEchoServer(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// roundTrip 通过 addr 发送 message 并读取全部响应
+func roundTrip(t *testing.T, addr, message string) string {
+	t.Helper()
+
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatalf("Dial(%s) error = %v", addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := io.WriteString(conn, message); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	return string(response)
+}
+
+// TestSSHClient_Forward 测试本地与远程端口转发
+func TestSSHClient_Forward(t *testing.T) {
+	server := newTestSSHServer(t)
+	echoAddr := newEchoServer(t)
+
+	client, err := NewSSHClient("host1", server.sshConfig())
+	if err != nil {
+		t.Fatalf("NewSSHClient() error = %v", err)
+	}
+	sc := client.(*SSHClient)
+
+	local, err := sc.LocalForward("127.0.0.1:0", echoAddr)
+	if err != nil {
+		t.Fatalf("LocalForward() error = %v", err)
+	}
+	remote, err := sc.RemoteForward("127.0.0.1:0", echoAddr)
+	if err != nil {
+		t.Fatalf("RemoteForward() error = %v", err)
+	}
+
+	for name, forward := range map[string]*Forward{"本地转发": local, "远程转发": remote} {
+		t.Run(name, func(t *testing.T) {
+			for i := range 3 {
+				message := fmt.Sprintf("hello %d", i)
+				if got := roundTrip(t, forward.Addr().String(), message); got != message {
+					t.Errorf("response = %q, want %q", got, message)
+				}
+			}
+		})
+	}
+
+	t.Run("关闭转发", func(t *testing.T) {
+		addr := local.Addr().String()
+		if err := local.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+		if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			conn.Close()
+			t.Error("Dial() succeeded after the forward was closed")
+		}
+	})
+
+	t.Run("关闭客户端停止转发", func(t *testing.T) {
+		forward, err := sc.LocalForward("127.0.0.1:0", echoAddr)
+		if err != nil {
+			t.Fatalf("LocalForward() error = %v", err)
+		}
+		addr := forward.Addr().String()
+
+		if err := sc.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+		if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			conn.Close()
+			t.Error("Dial() succeeded after the client was closed")
+		}
+		if _, err := sc.LocalForward("127.0.0.1:0", echoAddr); !errors.Is(err, ErrClientClosed) {
+			t.Errorf("LocalForward() after Close error = %v, want %v", err, ErrClientClosed)
+		}
+	})
+}
+
+// TestSSHClient_ForwardAgent 测试把本地 agent 转发给远程命令
+func TestSSHClient_ForwardAgent(t *testing.T) {
+	server := newTestSSHServer(t)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv, Comment: "deploy@ci"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	for _, forward := range []bool{true, false} {
+		t.Run(fmt.Sprintf("ForwardAgent=%v", forward), func(t *testing.T) {
+			config := server.sshConfig()
+			config.ForwardAgent = forward
+			config.Agent = keyring
+
+			client, err := NewSSHClient("host1", config)
+			if err != nil {
+				t.Fatalf("NewSSHClient() error = %v", err)
+			}
+			defer client.Close()
+
+			output, err := client.ExecuteCommand(context.Background(), "ssh-add -L")
+			if forward && (err != nil || !strings.Contains(output, "deploy@ci")) {
+				t.Errorf("ExecuteCommand() = %q, %v, want the forwarded key", output, err)
+			}
+			if !forward && err == nil {
+				t.Errorf("ExecuteCommand() = %q without agent forwarding, want error", output)
+			}
+		})
+	}
+
+	t.Run("未设置 SSH_AUTH_SOCK", func(t *testing.T) {
+		t.Setenv("SSH_AUTH_SOCK", "")
+		config := server.sshConfig()
+		config.ForwardAgent = true
+
+		if _, err := NewSSHClient("host1", config); err == nil || !strings.Contains(err.Error(), "SSH_AUTH_SOCK") {
+			t.Errorf("NewSSHClient() error = %v, want SSH_AUTH_SOCK error", err)
+		}
+	})
+}
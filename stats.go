@@ -0,0 +1,102 @@
+package remex
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ExecStats summarizes a batch execution on multiple hosts, see Remex.Stats
+type ExecStats struct {
+	// Succeeded and Failed hold the sorted IDs of the hosts that ran every
+	// command successfully and of the hosts that failed
+	Succeeded []string
+	Failed    []string
+	// Errors holds the error of every failed host keyed by ID
+	Errors map[string]error
+
+	// Commands is the number of commands finished on all hosts, failed ones included
+	Commands int
+	// OutputBytes is the total size of the output captured from the commands
+	OutputBytes int64
+	// Duration is the wall time of the whole batch
+	Duration time.Duration
+}
+
+// statsRecorder collects the statistics of a batch while it runs
+type statsRecorder struct {
+	mutex sync.Mutex
+	start time.Time
+	stats ExecStats
+}
+
+func newStatsRecorder() *statsRecorder {
+	return &statsRecorder{start: time.Now(), stats: ExecStats{Errors: make(map[string]error)}}
+}
+
+// collect wraps a result handler so every finished command is counted
+func (s *statsRecorder) collect(collect ResultHandler) ResultHandler {
+	return func(result ExecResult) {
+		s.mutex.Lock()
+		s.stats.Commands++
+		s.stats.OutputBytes += int64(len(result.Output))
+		s.mutex.Unlock()
+
+		if collect != nil {
+			collect(result)
+		}
+	}
+}
+
+// done records the outcome of a host
+func (s *statsRecorder) done(id string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err != nil {
+		s.stats.Errors[id] = err
+	} else {
+		s.stats.Succeeded = append(s.stats.Succeeded, id)
+	}
+}
+
+// summary returns the statistics of the finished batch
+func (s *statsRecorder) summary() ExecStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stats := s.stats
+	stats.Succeeded = slices.Sorted(slices.Values(stats.Succeeded))
+	stats.Failed = slices.Sorted(maps.Keys(stats.Errors))
+	stats.Errors = maps.Clone(stats.Errors)
+	stats.Duration = time.Since(s.start)
+	return stats
+}
+
+// Stats returns the summary of the last finished Execute, ExecuteOn, ExecuteOnTag,
+// ExecuteCollect, ExecuteBestEffort, Broadcast or BroadcastUpload, ok is false
+// when none has finished yet
+func (r *Remex) Stats() (stats ExecStats, ok bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if r.lastStats == nil {
+		return ExecStats{}, false
+	}
+
+	stats = *r.lastStats
+	stats.Succeeded = slices.Clone(stats.Succeeded)
+	stats.Failed = slices.Clone(stats.Failed)
+	stats.Errors = maps.Clone(stats.Errors)
+	return stats, true
+}
+
+// recordStats stores the summary of a finished batch for Stats
+func (r *Remex) recordStats(recorder *statsRecorder) {
+	stats := recorder.summary()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.lastStats = &stats
+}
@@ -0,0 +1,118 @@
+package remex
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultSudoCommands are the commands whose password prompt is answered when
+	// the auto root password is enabled and no custom list is configured
+	DefaultSudoCommands = []string{"sudo", "doas"}
+	// DefaultSudoPromptTimeout is how long a sudo command given ExecOptions.Stdin
+	// is waited for to prompt for the password before the input is passed through
+	// unchanged, see ExecOptions.SudoPromptTimeout. Without Stdin the prompt is
+	// awaited until the command exits or its context is done.
+	DefaultSudoPromptTimeout = 30 * time.Second
+)
+
+// maxPromptLength bounds the output kept while looking for a password prompt
+const maxPromptLength = 256
+
+// isSudoCommand reports whether command runs one of names, ignoring leading
+// environment assignments such as "LANG=C sudo" or "env LANG=C sudo"
+func isSudoCommand(command string, names []string) bool {
+	if names == nil {
+		names = DefaultSudoCommands
+	}
+
+	for _, field := range strings.Fields(command) {
+		if field == "env" || isEnvAssignment(field) {
+			continue
+		}
+		return slices.Contains(names, path.Base(field))
+	}
+	return false
+}
+
+// isEnvAssignment reports whether field is a shell variable assignment
+func isEnvAssignment(field string) bool {
+	name, _, ok := strings.Cut(field, "=")
+	if !ok || name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for _, c := range name {
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// isPasswordPrompt reports whether the pending output line looks like a sudo or doas password prompt
+func isPasswordPrompt(line []byte) bool {
+	s := strings.ToLower(strings.TrimSpace(string(line)))
+	return strings.HasSuffix(s, ":") && strings.Contains(s, "password")
+}
+
+// passwordPrompter watches command output for a password prompt and
+// answers it once, so the password never reaches a command that does not ask
+type passwordPrompter struct {
+	password string
+	prompted chan struct{}
+	once     sync.Once
+
+	mutex sync.Mutex
+	line  []byte
+}
+
+func newPasswordPrompter(password string) *passwordPrompter {
+	return &passwordPrompter{password: password, prompted: make(chan struct{})}
+}
+
+// Write implements io.Writer, it is added to the writers receiving the command output
+func (p *passwordPrompter) Write(b []byte) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// 只保留最后一行未结束的输出
+	p.line = append(p.line, b...)
+	if i := bytes.LastIndexByte(p.line, '\n'); i >= 0 {
+		p.line = p.line[i+1:]
+	}
+	if len(p.line) > maxPromptLength {
+		p.line = p.line[len(p.line)-maxPromptLength:]
+	}
+
+	if isPasswordPrompt(p.line) {
+		p.line = p.line[:0]
+		p.once.Do(func() { close(p.prompted) })
+	}
+	return len(b), nil
+}
+
+// answer writes the password to w once the command prompts for it. It gives up
+// when ctx is done, the command exited or, for a positive timeout, the timeout
+// expired, so the input of commands with cached credentials is not held up.
+func (p *passwordPrompter) answer(ctx context.Context, exited <-chan struct{}, timeout time.Duration, w io.Writer) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-p.prompted:
+		io.WriteString(w, p.password+"\n")
+	case <-ctx.Done():
+	case <-exited:
+	case <-expired:
+	}
+}
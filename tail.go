@@ -0,0 +1,206 @@
+package remex
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// tailFollowInterval is how often remex.tail -f checks the file for new data
+var tailFollowInterval = time.Second
+
+// tailRemoteFile returns the last n lines of a remote file, read backwards from the end
+// over SFTP, tail -n is run on the remote host when SFTP is unavailable. With -f the
+// lines and every line appended later are only streamed to the LineHandler of the
+// context until it is done, which ends the command successfully with an empty output,
+// so a long running tail does not keep the lines in memory.
+func tailRemoteFile(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+	var follow bool
+
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&follow, "f", false, "stream new lines until the context is done")
+
+	if err := fs.Parse(args); err != nil {
+		return "", fmt.Errorf("invalid tail flags: %w", err)
+	}
+	if args = fs.Args(); len(args) != 2 {
+		return "", errors.New("tail requires exactly 2 arguments: path lines")
+	}
+
+	path := strings.TrimSpace(args[0])
+	if path == "" {
+		return "", errors.New("path cannot be empty")
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(args[1]))
+	if err != nil || n < 0 {
+		return "", fmt.Errorf("invalid line count %q", args[1])
+	}
+
+	sftpClient, release, err := newSFTPClient(ctx, client)
+	if err != nil {
+		return tailCommand(ctx, client, path, n, follow)
+	}
+	defer release()
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	remoteFile, err := sftpClient.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("remote file not found: %w", err)
+		}
+		return "", fmt.Errorf("failed to open remote file: %w", err)
+	}
+	defer remoteFile.Close()
+
+	info, err := remoteFile.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to stat remote file: %w", err)
+	}
+
+	output, err := lastLines(ctx, remoteFile, info.Size(), n)
+	if err != nil || !follow {
+		return output, err
+	}
+
+	// 只在读到换行后输出一行，未完成的行保留到下次读取
+	var partial []byte
+	if i := strings.LastIndexByte(output, '\n'); !strings.HasSuffix(output, "\n") {
+		output, partial = output[:i+1], []byte(output[i+1:])
+	}
+
+	emit := lineHandlerFromContext(ctx)
+	if emit == nil {
+		emit = func(Stream, string) {}
+	}
+	for line := range strings.Lines(output) {
+		emit(StreamStdout, strings.TrimSuffix(line, "\n"))
+	}
+
+	offset := info.Size()
+	ticker := time.NewTicker(tailFollowInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if len(partial) > 0 {
+				emit(StreamStdout, string(partial))
+			}
+			return "", nil
+		case <-ticker.C:
+		}
+
+		info, err := remoteFile.Stat()
+		if err != nil {
+			return "", fmt.Errorf("failed to stat remote file: %w", err)
+		}
+		if info.Size() < offset {
+			// 文件被截断，从头开始读取
+			offset, partial = 0, nil
+		}
+		if info.Size() == offset {
+			continue
+		}
+
+		data := make([]byte, info.Size()-offset)
+		read, err := remoteFile.ReadAt(data, offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("failed to read remote file: %w", err)
+		}
+		offset += int64(read)
+
+		partial = append(partial, data[:read]...)
+		for {
+			i := bytes.IndexByte(partial, '\n')
+			if i < 0 {
+				break
+			}
+			emit(StreamStdout, string(partial[:i]))
+			partial = partial[i+1:]
+		}
+	}
+}
+
+// lastLines returns the last n lines of the first size bytes of r, reading backwards
+// in chunks so only the tail of the file is transferred
+func lastLines(ctx context.Context, r io.ReaderAt, size int64, n int) (string, error) {
+	if n == 0 || size == 0 {
+		return "", nil
+	}
+
+	var (
+		data   []byte
+		offset = size
+	)
+	for offset > 0 {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		chunk := min(int64(DefaultCopyBufferSize), offset)
+		offset -= chunk
+
+		p := make([]byte, chunk)
+		if _, err := r.ReadAt(p, offset); err != nil && !errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("failed to read remote file: %w", err)
+		}
+		data = append(p, data...)
+
+		// 文件末尾的换行不算作新的一行
+		if bytes.Count(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) >= n {
+			break
+		}
+	}
+
+	body := bytes.TrimSuffix(data, []byte("\n"))
+	for i := len(body) - 1; i >= 0; i-- {
+		if body[i] == '\n' {
+			if n--; n == 0 {
+				return string(data[i+1:]), nil
+			}
+		}
+	}
+	return string(data), nil
+}
+
+// tailCommand runs tail on the remote host, with follow the lines are only streamed
+// to the LineHandler of the context until it is done
+func tailCommand(ctx context.Context, client *ssh.Client, path string, n int, follow bool) (string, error) {
+	quoted, err := QuoteArg(path)
+	if err != nil {
+		return "", err
+	}
+
+	command := fmt.Sprintf("tail -n %d -- %s", n, quoted)
+	if follow {
+		command = fmt.Sprintf("tail -n %d -F -- %s", n, quoted)
+	}
+
+	opts := ExecOptions{OnLine: lineHandlerFromContext(ctx)}
+	if follow {
+		// 输出只交给 LineHandler，不在内存中累积
+		opts.Stdout = io.Discard
+	}
+
+	output, err := ExecRemoteCommandWithOptions(ctx, client, command, opts)
+	if follow && ctx.Err() != nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("tail failed: %w", err)
+	}
+	return output.Stdout, nil
+}
@@ -0,0 +1,45 @@
+package remex
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Span attribute keys, see WithTracer
+const (
+	attrHostID   = attribute.Key("remex.host.id")
+	attrHostAddr = attribute.Key("remex.host.addr")
+	attrCommand  = attribute.Key("remex.command")
+	attrExitCode = attribute.Key("remex.exit_code")
+	attrHosts    = attribute.Key("remex.hosts")
+	attrCommands = attribute.Key("remex.commands")
+)
+
+// noopTracer is used when WithTracer is not given
+var noopTracer = noop.NewTracerProvider().Tracer("")
+
+// startSpan starts a span named name as a child of the span in ctx
+func (r *Remex) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return r.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// hostAttributes returns the span attributes identifying the host of client
+func hostAttributes(client RemoteClient) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attrHostID.String(client.ID()),
+		attrHostAddr.String(client.RemoteAddr().String()),
+	}
+}
+
+// endSpan marks span as failed when err is not nil and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,355 @@
+package remex
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// DefaultCopyBufferSize is the buffer size used by transfers when TransferOptions.BufferSize is zero
+	DefaultCopyBufferSize = 32 * 1024
+
+	// ErrChecksumMismatch is returned when a transferred file does not match its source
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrInsufficientSpace is returned when the remote filesystem cannot hold an upload
+	ErrInsufficientSpace = errors.New("insufficient disk space")
+)
+
+// AtomicUploadSuffix is appended to the destination of an upload to name the
+// temporary file that is renamed over the destination once it is complete. The
+// rename is atomic when the server supports posix-rename, otherwise the existing
+// destination is removed just before it. An existing destination keeps its mode.
+const AtomicUploadSuffix = ".remex.tmp"
+
+// TransferOptions controls how files are transferred by the upload and download commands
+type TransferOptions struct {
+	// VerifyChecksum compares the SHA-256 digest of the transferred data with the
+	// remote file after the transfer, removing the destination on mismatch
+	VerifyChecksum bool
+	// Resume continues a download when the local file is a prefix of the
+	// remote file, a local file larger than the remote one is downloaded again
+	Resume bool
+	// DirectWrite writes uploads straight to the destination instead of a
+	// temporary file renamed into place, for streaming into FIFOs or devices
+	DirectWrite bool
+	// CheckSpace fails an upload with ErrInsufficientSpace before writing when the
+	// remote filesystem has less free space than the size of the data. The size is
+	// only known for files and in-memory readers, other readers are not checked.
+	CheckSpace bool
+	// PreserveTimes copies the modification time of the source to the destination,
+	// like scp -p. Uploads only preserve it when reading from a file.
+	PreserveTimes bool
+	// BufferSize is the size of the copy buffer, defaults to DefaultCopyBufferSize when zero
+	BufferSize int
+	// Transferred is increased atomically by the bytes copied while the transfer
+	// runs when set, for progress bars and bandwidth accounting. A resumed download
+	// only counts the bytes fetched by this transfer.
+	Transferred *int64
+	// SFTPOptions are passed to the SFTP client used for the transfer, e.g.
+	// sftp.UseConcurrentWrites(true) or sftp.MaxConcurrentRequestsPerFile(n)
+	SFTPOptions []sftp.ClientOption
+}
+
+// parseTransferFlags parses the leading flags of a transfer command on top of the given defaults
+//
+//	-verify  verify the SHA-256 checksum after the transfer
+//	-resume  resume a partial download
+//	-direct  upload straight to the destination without a temporary file
+//	-check-space  check the remote free disk space before uploading
+//	-preserve  preserve the modification time of the source
+//	-buffer-size n  size of the copy buffer in bytes
+//	-concurrent  enable concurrent SFTP reads and writes
+//	-max-packet n  maximum SFTP packet size
+//	-max-requests n  maximum concurrent SFTP requests per file
+func parseTransferFlags(name string, defaults TransferOptions, args []string) (TransferOptions, []string, error) {
+	var (
+		opts = defaults
+
+		concurrent             bool
+		maxPacket, maxRequests int
+	)
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&opts.VerifyChecksum, "verify", opts.VerifyChecksum, "verify the SHA-256 checksum after the transfer")
+	fs.BoolVar(&opts.Resume, "resume", opts.Resume, "resume a partial download")
+	fs.BoolVar(&opts.DirectWrite, "direct", opts.DirectWrite, "upload straight to the destination without a temporary file")
+	fs.BoolVar(&opts.CheckSpace, "check-space", opts.CheckSpace, "check the remote free disk space before uploading")
+	fs.BoolVar(&opts.PreserveTimes, "preserve", opts.PreserveTimes, "preserve the modification time of the source")
+	fs.IntVar(&opts.BufferSize, "buffer-size", opts.BufferSize, "size of the copy buffer in bytes")
+	fs.BoolVar(&concurrent, "concurrent", false, "enable concurrent SFTP reads and writes")
+	fs.IntVar(&maxPacket, "max-packet", 0, "maximum SFTP packet size")
+	fs.IntVar(&maxRequests, "max-requests", 0, "maximum concurrent SFTP requests per file")
+
+	if err := fs.Parse(args); err != nil {
+		return opts, nil, fmt.Errorf("invalid %s flags: %w", name, err)
+	}
+
+	// Clip so appending never modifies the defaults
+	opts.SFTPOptions = slices.Clip(opts.SFTPOptions)
+	if concurrent {
+		opts.SFTPOptions = append(opts.SFTPOptions, sftp.UseConcurrentReads(true), sftp.UseConcurrentWrites(true))
+	}
+	if maxPacket > 0 {
+		opts.SFTPOptions = append(opts.SFTPOptions, sftp.MaxPacket(maxPacket))
+	}
+	if maxRequests > 0 {
+		opts.SFTPOptions = append(opts.SFTPOptions, sftp.MaxConcurrentRequestsPerFile(maxRequests))
+	}
+
+	return opts, fs.Args(), nil
+}
+
+// UploadCommand returns a remex.upload command that uses opts as the default transfer options
+func UploadCommand(opts TransferOptions) remexCommand {
+	return func(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+		return uploadFileWithOptions(ctx, client, opts, args...)
+	}
+}
+
+// DownloadCommand returns a remex.download command that uses opts as the default transfer options
+func DownloadCommand(opts TransferOptions) remexCommand {
+	return func(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+		return downloadFileWithOptions(ctx, client, opts, args...)
+	}
+}
+
+// copyBuffer copies src to dst through a buffer of the configured size
+func copyBuffer(dst io.Writer, src io.Reader, opts TransferOptions) (int64, error) {
+	size := opts.BufferSize
+	if size <= 0 {
+		size = DefaultCopyBufferSize
+	}
+
+	if opts.Transferred != nil {
+		dst = &countingWriter{w: dst, n: opts.Transferred}
+	}
+
+	// Hide io.ReaderFrom so the buffer is used instead of the destination's own copy loop
+	return io.CopyBuffer(struct{ io.Writer }{dst}, src, make([]byte, size))
+}
+
+// openDownloadFile opens the local destination of a download, returning the offset to resume from
+func openDownloadFile(localFilePath string, remoteSize int64, resume bool) (*os.File, int64, error) {
+	if resume {
+		// 本地文件比远程文件大时视为损坏，重新下载
+		if info, err := os.Stat(localFilePath); err == nil && info.Mode().IsRegular() && info.Size() <= remoteSize {
+			localFile, err := os.OpenFile(localFilePath, os.O_RDWR|os.O_APPEND, 0)
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to open local file: %w", err)
+			}
+			return localFile, info.Size(), nil
+		}
+	}
+
+	localFile, err := os.OpenFile(localFilePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create local file: %w", err)
+	}
+	return localFile, 0, nil
+}
+
+// uploadSize returns the number of bytes left in reader, or -1 when it is unknown
+func uploadSize(reader io.Reader) int64 {
+	switch r := reader.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return info.Size() - offset
+	}
+	return -1
+}
+
+// fileTimes returns the access and modification times of a file, the access time
+// is only known for remote files and is the modification time otherwise
+func fileTimes(info os.FileInfo) (atime, mtime time.Time) {
+	mtime = info.ModTime()
+	if stat, ok := info.Sys().(*sftp.FileStat); ok {
+		return time.Unix(int64(stat.Atime), 0), mtime
+	}
+	return mtime, mtime
+}
+
+// checkRemoteSpace returns an error wrapping ErrInsufficientSpace when the filesystem
+// holding remoteDir has less than size bytes available
+func checkRemoteSpace(ctx context.Context, client *ssh.Client, sftpClient *sftp.Client, remoteDir string, size int64) error {
+	usage, err := remoteDiskUsage(ctx, client, sftpClient, remoteDir)
+	if err != nil {
+		return fmt.Errorf("failed to check remote disk space: %w", err)
+	}
+
+	if uint64(size) > usage.Free {
+		return fmt.Errorf("%w: %s has %d bytes available, upload needs %d", ErrInsufficientSpace, remoteDir, usage.Free, size)
+	}
+	return nil
+}
+
+// DiskUsage describes the filesystem holding a remote path in the JSON output of remex.df
+type DiskUsage struct {
+	Path string `json:"path"`
+	// Total, Used and Free are in bytes, Free is the space available to
+	// unprivileged users and excludes the blocks reserved for root
+	Total uint64 `json:"total"`
+	Used  uint64 `json:"used"`
+	Free  uint64 `json:"free"`
+}
+
+// remoteDiskUsage returns the usage of the filesystem holding remotePath, it uses
+// the statvfs SFTP extension and falls back to running df
+func remoteDiskUsage(ctx context.Context, client *ssh.Client, sftpClient *sftp.Client, remotePath string) (DiskUsage, error) {
+	if _, ok := sftpClient.HasExtension("statvfs@openssh.com"); ok {
+		if stat, err := sftpClient.StatVFS(remotePath); err == nil {
+			return DiskUsage{
+				Path:  remotePath,
+				Total: stat.Frsize * stat.Blocks,
+				Used:  stat.Frsize * (stat.Blocks - stat.Bfree),
+				Free:  stat.Frsize * stat.Bavail,
+			}, nil
+		}
+	}
+
+	quoted, err := QuoteArg(remotePath)
+	if err != nil {
+		return DiskUsage{}, err
+	}
+	output, err := ExecRemoteCommandWithOptions(ctx, client, "df -Pk -- "+quoted, ExecOptions{})
+	if err != nil {
+		return DiskUsage{}, fmt.Errorf("df failed: %w", err)
+	}
+
+	usage, err := parseDF(output.Stdout)
+	usage.Path = remotePath
+	return usage, err
+}
+
+// parseDF parses the output of df -Pk
+func parseDF(output string) (DiskUsage, error) {
+	// 第二行为文件系统信息：Filesystem 1024-blocks Used Available Capacity Mounted on
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) < 2 {
+		return DiskUsage{}, fmt.Errorf("unexpected df output %q", output)
+	}
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) < 4 {
+		return DiskUsage{}, fmt.Errorf("unexpected df output %q", output)
+	}
+
+	var blocks [3]uint64
+	for i := range blocks {
+		n, err := strconv.ParseUint(fields[i+1], 10, 64)
+		if err != nil {
+			return DiskUsage{}, fmt.Errorf("unexpected df output %q: %w", output, err)
+		}
+		blocks[i] = n * 1024
+	}
+	return DiskUsage{Total: blocks[0], Used: blocks[1], Free: blocks[2]}, nil
+}
+
+// checksumAlgorithm describes a digest computed by the checksum commands
+type checksumAlgorithm struct {
+	name string
+	// tool is the remote utility printing the digest, e.g. sha256sum
+	tool    string
+	newHash func() hash.Hash
+	size    int
+}
+
+var (
+	checksumSHA256 = checksumAlgorithm{name: "sha256", tool: "sha256sum", newHash: sha256.New, size: sha256.Size}
+	checksumMD5    = checksumAlgorithm{name: "md5", tool: "md5sum", newHash: md5.New, size: md5.Size}
+)
+
+// verifyRemoteChecksum compares the expected SHA-256 digest with the digest of the remote file
+func verifyRemoteChecksum(ctx context.Context, client *ssh.Client, sftpClient *sftp.Client, remoteFilePath string, expected []byte) error {
+	digest, err := remoteDigest(ctx, client, sftpClient, remoteFilePath, checksumSHA256)
+	if err != nil {
+		return fmt.Errorf("failed to compute remote checksum: %w", err)
+	}
+
+	if want := hex.EncodeToString(expected); digest != want {
+		return fmt.Errorf("%w: %s has sha256 %s, want %s", ErrChecksumMismatch, remoteFilePath, digest, want)
+	}
+	return nil
+}
+
+// remoteDigest returns the hex encoded digest of a remote file, it runs the tool of
+// the algorithm on the remote host and falls back to hashing the file over SFTP
+func remoteDigest(ctx context.Context, client *ssh.Client, sftpClient *sftp.Client, remoteFilePath string, algorithm checksumAlgorithm) (string, error) {
+	if quoted, err := QuoteArg(remoteFilePath); err == nil {
+		output, err := ExecRemoteCommandWithOptions(ctx, client, algorithm.tool+" -- "+quoted, ExecOptions{})
+		if err == nil {
+			if fields := strings.Fields(output.Stdout); len(fields) > 0 && len(fields[0]) == algorithm.size*2 {
+				return strings.ToLower(fields[0]), nil
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+	}
+
+	// 远程主机没有对应工具时通过 SFTP 读取文件计算
+	remoteFile, err := sftpClient.Open(remoteFilePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open remote file: %w", err)
+	}
+	defer remoteFile.Close()
+
+	hash := algorithm.newHash()
+	if _, err := io.Copy(hash, newInterruptibleReader(ctx, remoteFile)); err != nil {
+		return "", fmt.Errorf("failed to read remote file: %w", err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// remoteChecksum returns the SHA-256 digest of a remote file
+func remoteChecksum(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+	return computeRemoteChecksum(ctx, client, checksumSHA256, args...)
+}
+
+// remoteMD5 returns the MD5 digest of a remote file
+func remoteMD5(ctx context.Context, client *ssh.Client, args ...string) (string, error) {
+	return computeRemoteChecksum(ctx, client, checksumMD5, args...)
+}
+
+func computeRemoteChecksum(ctx context.Context, client *ssh.Client, algorithm checksumAlgorithm, args ...string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("%s requires exactly one argument: remoteFilePath", algorithm.name)
+	}
+
+	remoteFilePath := strings.TrimSpace(args[0])
+	if remoteFilePath == "" {
+		return "", errors.New("remote file path cannot be empty")
+	}
+
+	sftpClient, release, err := newSFTPClient(ctx, client)
+	if err != nil {
+		return "", fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	defer release()
+
+	return remoteDigest(ctx, client, sftpClient, remoteFilePath, algorithm)
+}